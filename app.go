@@ -2,17 +2,17 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/csv"
-	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 
+	"proxy-manager-desktop/internal/accesslog"
+	"proxy-manager-desktop/internal/api"
 	"proxy-manager-desktop/internal/config"
+	"proxy-manager-desktop/internal/instance"
 	"proxy-manager-desktop/internal/server"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -20,11 +20,26 @@ import (
 
 // App struct - 代理管理器应用
 type App struct {
-	ctx           context.Context
-	configManager *config.ConfigManager
-	proxyManager  *server.ProxyManager
+	ctx             context.Context
+	configPath      string
+	instance        *instance.Instance
+	launchArgs      []string // 启动时的命令行参数，启动完成后执行
+	configManager   *config.ConfigManager
+	proxyManager    *server.ProxyManager
+	settingsManager *config.AppSettingsManager
+	accessLog       *accesslog.Logger
+	apiServer       *api.Server
+
+	pendingLogsMu sync.Mutex
+	pendingLogs   []accesslog.Entry // 还没有推送给前端的访问记录
 }
 
+// accessLogFlushInterval 访问记录攒够这段时间后一次推送给前端
+const accessLogFlushInterval = 500 * time.Millisecond
+
+// maxPendingLogs 一次最多推送的访问记录数，与前端显示的条数相同
+const maxPendingLogs = 200
+
 // ProxyConfig 代理配置结构 - 前端接口
 type ProxyConfig struct {
 	ID          string        `json:"id"`
@@ -33,45 +48,57 @@ type ProxyConfig struct {
 	Local       LocalProxy    `json:"local"`
 	Enabled     bool          `json:"enabled"`
 	Description string        `json:"description,omitempty"`
+
+	HeaderRules []config.HeaderRule      `json:"header_rules,omitempty"` // HTTP头部改写规则
+	Quota       *config.TrafficQuota     `json:"quota,omitempty"`        // 流量配额
+	Bandwidth   *config.BandwidthLimit   `json:"bandwidth,omitempty"`    // 带宽限制
+	Limits      *config.ConnectionLimits `json:"limits,omitempty"`       // 连接数限制和访问控制
+	Timeouts    *config.Timeouts         `json:"timeouts,omitempty"`     // 超时设置，为空使用全局默认值
 }
 
 type UpstreamProxy struct {
-	Protocol   string `json:"protocol"` // "http" or "socks5"
+	Protocol   string `json:"protocol"` // "http", "socks5" or "shadowsocks"
 	Address    string `json:"address"`  // IP:Port
 	Username   string `json:"username"`
 	Password   string `json:"password"`
 	AuthMethod string `json:"auth_method,omitempty"` // "basic", "digest", "ntlm"
+	Cipher     string `json:"cipher,omitempty"`      // shadowsocks加密方式
+
+	Transport *config.UpstreamTransport `json:"transport,omitempty"` // ws / h2 传输层，为空时直接TCP
 }
 
 type LocalProxy struct {
 	Protocol   string `json:"protocol"`  // "http" or "socks5"
 	ListenIP   string `json:"listen_ip"` // "127.0.0.1" or "::1"
 	ListenPort int    `json:"listen_port"`
+	Via        string `json:"via,omitempty"` // HTTP转发时Via头部中的代理名称，为空不添加
 }
 
 // ProxyStatus 代理状态
 type ProxyStatus struct {
-	ID      string `json:"id"`
-	Running bool   `json:"running"`
-	Error   string `json:"error,omitempty"`
+	ID        string    `json:"id"`
+	Running   bool      `json:"running"`
+	State     string    `json:"state"` // stopped, starting, running, stopping, failed
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	StoppedAt time.Time `json:"stopped_at"`
 }
 
 // ProxyWithStatus 带状态的代理
 type ProxyWithStatus struct {
 	ProxyConfig
-	Running bool `json:"running"`
+	Running bool   `json:"running"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"` // 运行失败的原因
 }
 
 // NewApp 创建新的应用实例
-func NewApp() *App {
-	return &App{}
+func NewApp(configPath string, inst *instance.Instance, launchArgs []string) *App {
+	return &App{configPath: configPath, instance: inst, launchArgs: launchArgs}
 }
 
-// startup 应用启动时调用
-func (a *App) startup(ctx context.Context) {
-	a.ctx = ctx
-
-	// 配置文件路径 - 优先使用当前目录
+// findConfigPath 配置文件路径 - 优先使用当前目录
+func findConfigPath() string {
 	configPath := "config.json"
 
 	// 如果当前目录没有配置文件，尝试可执行文件目录
@@ -83,17 +110,96 @@ func (a *App) startup(ctx context.Context) {
 			configPath = filepath.Join(filepath.Dir(execDir), "config.json")
 		}
 	}
+	return configPath
+}
+
+// startup 应用启动时调用
+func (a *App) startup(ctx context.Context) {
+	a.ctx = ctx
+	configPath := a.configPath
 
 	log.Printf("使用配置文件路径: %s", configPath)
 
 	// 初始化配置管理器
 	a.configManager = config.NewConfigManager(configPath)
 
+	// 初始化应用设置，与配置文件放在同一目录
+	a.settingsManager = config.NewAppSettingsManager(filepath.Dir(configPath))
+
 	// 初始化代理管理器
 	a.proxyManager = server.NewProxyManager(a.configManager)
+	a.proxyManager.SetDrainTimeout(time.Duration(a.settingsManager.GetSettings().DrainTimeoutSeconds) * time.Second)
+	a.proxyManager.SetDefaultTimeouts(a.settingsManager.GetSettings().DefaultTimeouts)
+	// 活动连接只按秒推送快照，不逐个推送连接的打开和关闭
+	go a.emitConnectionUpdates(ctx)
 
-	// 自动启动标记为AutoStart的代理
-	errors := a.startAutoStartProxies()
+	// 访问日志写在配置目录下的logs目录，新记录定期批量推送给前端
+	accessLog, err := accesslog.Open(filepath.Join(filepath.Dir(configPath), "logs", "access.log"), a.settingsManager.GetSettings().AccessLog)
+	if err != nil {
+		log.Printf("打开访问日志失败: %v", err)
+	} else {
+		accessLog.SetObserver(a.queueAccessLog)
+		a.accessLog = accessLog
+		a.proxyManager.SetAccessLog(accessLog)
+		go a.emitAccessLogs(ctx)
+	}
+
+	// 流量历史保存在配置文件旁边
+	if err := a.proxyManager.EnableTrafficHistory(filepath.Join(filepath.Dir(configPath), "traffic.log")); err != nil {
+		log.Printf("启用流量历史失败: %v", err)
+	}
+
+	// 配额状态需要在启动代理之前恢复，已超额的代理不会被自动启动
+	a.proxyManager.SetQuotaObserver(func(event string, status server.QuotaStatus) {
+		runtime.EventsEmit(a.ctx, "quota:"+event, status)
+	})
+	if err := a.proxyManager.EnableQuotas(filepath.Join(filepath.Dir(configPath), "quota_state.json")); err != nil {
+		log.Printf("启用流量配额失败: %v", err)
+	}
+
+	// 指标监听器启动失败不影响代理运行
+	if metrics := a.settingsManager.GetSettings().Metrics; metrics.Enabled {
+		if err := a.proxyManager.StartMetrics(metrics.ListenAddr); err != nil {
+			log.Printf("启动指标服务失败: %v", err)
+		}
+	}
+
+	// 通过管理API所做的修改通知前端刷新列表
+	a.apiServer = api.NewServer(a.configManager, a.proxyManager)
+	a.apiServer.SetChangeObserver(func() {
+		runtime.EventsEmit(a.ctx, "proxies:changed")
+	})
+	if apiSettings := a.settingsManager.GetSettings().API; apiSettings.Enabled {
+		if err := a.apiServer.Start(apiSettings.ListenAddr, apiSettings.Token); err != nil {
+			log.Printf("启动管理API失败: %v", err)
+		}
+	}
+
+	// 手动编辑或其他程序修改配置文件后自动重新加载，并通知前端刷新
+	a.proxyManager.WatchConfig(server.DefaultConfigWatchInterval, func(errs []error) {
+		messages := []string{}
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		runtime.EventsEmit(a.ctx, "config:reloaded", messages)
+	})
+
+	// 配置文件已加密时，等前端输入主密码解锁后再启动代理
+	if a.configManager.Locked() {
+		log.Println("配置文件已加密，等待输入主密码")
+	} else {
+		a.startConfiguredProxies()
+	}
+
+	// 开始接收其他实例转发的命令
+	a.instance.Serve(a.handleForwardedArgs)
+
+	log.Println("🚀 代理管理器桌面应用已启动")
+}
+
+// startConfiguredProxies 自动启动标记为AutoStart的代理，然后执行启动参数中的命令
+func (a *App) startConfiguredProxies() {
+	errors := a.proxyManager.StartAutoStartProxies()
 	if len(errors) > 0 {
 		// 只记录实际的错误，减少日志输出
 		log.Printf("自动启动代理时有 %d 个错误", len(errors))
@@ -101,46 +207,100 @@ func (a *App) startup(ctx context.Context) {
 		log.Println("所有标记为自动启动的代理已启动")
 	}
 
-	log.Println("🚀 代理管理器桌面应用已启动")
+	if len(a.launchArgs) > 0 {
+		if err := a.runLaunchArgs(a.launchArgs); err != nil {
+			log.Printf("执行命令行参数失败: %v", err)
+		}
+	}
 }
 
 // shutdown 应用关闭时调用
 func (a *App) shutdown(ctx context.Context) {
-	log.Println("正在保存代理状态...")
+	// 先停止监视配置文件和关闭管理API，避免保存状态期间再有修改
+	a.proxyManager.StopWatchingConfig()
+	a.apiServer.Stop()
 
-	// 获取所有代理的当前运行状态
-	proxies := a.configManager.GetAllProxies()
-	for _, proxy := range proxies {
-		isRunning := a.proxyManager.IsProxyRunning(proxy.ID)
-		a.configManager.UpdateProxyAutoStart(proxy.ID, isRunning)
-	}
+	log.Println("正在保存代理状态...")
 
-	// 保存配置
-	if err := a.configManager.SaveConfig(); err != nil {
+	// 把当前运行状态保存为自动启动标记
+	if err := a.proxyManager.SaveRunningState(); err != nil {
 		log.Printf("保存配置失败: %v", err)
 	} else {
 		log.Println("代理状态已保存")
 	}
 
+	// 状态保存后再停止代理，等待处理中的连接结束
+	if errors := a.proxyManager.StopAllProxies(); len(errors) > 0 {
+		for _, err := range errors {
+			log.Printf("%v", err)
+		}
+	}
+	a.proxyManager.StopMetrics()
+	a.proxyManager.CloseQuotas()
+	a.proxyManager.CloseTrafficHistory()
+	a.accessLog.Close()
+
+	a.instance.Close()
+
 	log.Println("应用正在关闭...")
 }
 
-// startAutoStartProxies 启动所有标记为自动启动的代理
-func (a *App) startAutoStartProxies() []error {
-	var errors []error
-	proxies := a.configManager.GetAllProxies()
-
-	for _, proxyConfig := range proxies {
-		if !proxyConfig.Enabled || !proxyConfig.AutoStart {
-			continue
+// emitConnectionUpdates 每秒向前端推送一次活动连接及其流量，连接全部结束后再推送一次空列表
+func (a *App) emitConnectionUpdates(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	hadConnections := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			connections := a.proxyManager.GetAllConnections()
+			if len(connections) == 0 && !hadConnections {
+				continue
+			}
+			hadConnections = len(connections) > 0
+			runtime.EventsEmit(ctx, "connections:update", connections)
 		}
+	}
+}
 
-		if err := a.proxyManager.StartProxy(proxyConfig.ID); err != nil {
-			errors = append(errors, fmt.Errorf("启动代理 %s 失败: %w", proxyConfig.ID, err))
-		}
+// queueAccessLog 暂存新的访问记录，超过一次推送的数量时丢弃最旧的
+func (a *App) queueAccessLog(entry accesslog.Entry) {
+	a.pendingLogsMu.Lock()
+	defer a.pendingLogsMu.Unlock()
+
+	if len(a.pendingLogs) >= 2*maxPendingLogs {
+		a.pendingLogs = append(a.pendingLogs[:0], a.pendingLogs[len(a.pendingLogs)-maxPendingLogs:]...)
 	}
+	a.pendingLogs = append(a.pendingLogs, entry)
+}
 
-	return errors
+// emitAccessLogs 每隔 accessLogFlushInterval 把暂存的访问记录一次推送给前端
+func (a *App) emitAccessLogs(ctx context.Context) {
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.pendingLogsMu.Lock()
+			entries := a.pendingLogs
+			a.pendingLogs = nil
+			a.pendingLogsMu.Unlock()
+
+			if len(entries) == 0 {
+				continue
+			}
+			if len(entries) > maxPendingLogs {
+				entries = entries[len(entries)-maxPendingLogs:]
+			}
+			runtime.EventsEmit(ctx, "accesslog:entries", entries)
+		}
+	}
 }
 
 // GetAllProxies 获取所有代理配置
@@ -149,7 +309,7 @@ func (a *App) GetAllProxies() ([]*ProxyWithStatus, error) {
 	var result []*ProxyWithStatus
 
 	for _, proxy := range proxies {
-		status := a.proxyManager.IsProxyRunning(proxy.ID)
+		status := a.proxyManager.GetStatus(proxy.ID)
 		result = append(result, &ProxyWithStatus{
 			ProxyConfig: ProxyConfig{
 				ID:          proxy.ID,
@@ -158,8 +318,15 @@ func (a *App) GetAllProxies() ([]*ProxyWithStatus, error) {
 				Local:       LocalProxy(proxy.Local),
 				Enabled:     proxy.Enabled,
 				Description: "",
+				HeaderRules: proxy.HeaderRules,
+				Quota:       proxy.Quota,
+				Bandwidth:   proxy.Bandwidth,
+				Limits:      proxy.Limits,
+				Timeouts:    proxy.Timeouts,
 			},
-			Running: status,
+			Running: status.State == server.StateRunning,
+			State:   status.State.String(),
+			Error:   status.LastError,
 		})
 	}
 
@@ -170,12 +337,20 @@ func (a *App) GetAllProxies() ([]*ProxyWithStatus, error) {
 func (a *App) AddProxy(proxy ProxyConfig) (string, error) {
 	// 转换为内部配置格式
 	internalProxy := &config.ProxyConfig{
-		ID:        proxy.ID,
-		Name:      proxy.Name,
-		Upstream:  config.UpstreamProxy(proxy.Upstream),
-		Local:     config.LocalProxy(proxy.Local),
-		Enabled:   proxy.Enabled,
-		AutoStart: false, // 新添加的代理默认不自动启动
+		ID:          proxy.ID,
+		Name:        proxy.Name,
+		Upstream:    config.UpstreamProxy(proxy.Upstream),
+		Local:       config.LocalProxy(proxy.Local),
+		Enabled:     proxy.Enabled,
+		AutoStart:   false, // 新添加的代理默认不自动启动
+		HeaderRules: proxy.HeaderRules,
+		Quota:       proxy.Quota,
+		Bandwidth:   proxy.Bandwidth,
+		Limits:      proxy.Limits,
+		Timeouts:    proxy.Timeouts,
+	}
+	if err := a.configManager.ValidateProxy(internalProxy, proxy.Enabled); err != nil {
+		return "", err
 	}
 
 	id, err := a.configManager.AddProxy(internalProxy)
@@ -212,19 +387,134 @@ func (a *App) UpdateProxy(proxy ProxyConfig) error {
 	}
 
 	internalProxy := &config.ProxyConfig{
-		ID:        proxy.ID,
-		Name:      proxy.Name,
-		Upstream:  config.UpstreamProxy(proxy.Upstream),
-		Local:     config.LocalProxy(proxy.Local),
-		Enabled:   proxy.Enabled,
-		AutoStart: currentProxy.AutoStart, // 保留原有的AutoStart状态
+		ID:          proxy.ID,
+		Name:        proxy.Name,
+		Upstream:    config.UpstreamProxy(proxy.Upstream),
+		Local:       config.LocalProxy(proxy.Local),
+		Enabled:     proxy.Enabled,
+		AutoStart:   currentProxy.AutoStart, // 保留原有的AutoStart状态
+		HeaderRules: proxy.HeaderRules,
+		Quota:       proxy.Quota,
+		Bandwidth:   proxy.Bandwidth,
+		Limits:      proxy.Limits,
+		Timeouts:    proxy.Timeouts,
+	}
+	// 监听地址没有变化时端口被代理自己占用，不检查端口是否可用
+	listenChanged := internalProxy.Local.ListenIP != currentProxy.Local.ListenIP ||
+		internalProxy.Local.ListenPort != currentProxy.Local.ListenPort
+	if err := a.configManager.ValidateProxy(internalProxy, listenChanged); err != nil {
+		return err
 	}
 
 	if err := a.configManager.UpdateProxy(internalProxy); err != nil {
 		return err
 	}
 
-	return a.configManager.SaveConfig()
+	if err := a.configManager.SaveConfig(); err != nil {
+		return err
+	}
+
+	// 头部规则、带宽限制、连接限制和超时可以直接应用到运行中的代理
+	a.proxyManager.UpdateHeaderRules(proxy.ID, proxy.HeaderRules)
+	a.proxyManager.UpdateBandwidthLimit(proxy.ID, proxy.Bandwidth)
+	a.proxyManager.UpdateConnectionLimits(proxy.ID, proxy.Limits)
+	a.proxyManager.UpdateTimeouts(proxy.ID, proxy.Timeouts)
+	return nil
+}
+
+// GetHeaderRules 获取代理的HTTP头部改写规则
+func (a *App) GetHeaderRules(id string) ([]config.HeaderRule, error) {
+	proxy, err := a.configManager.GetProxy(id)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.HeaderRules, nil
+}
+
+// SetHeaderRules 保存代理的HTTP头部改写规则，运行中的代理立即生效
+func (a *App) SetHeaderRules(id string, rules []config.HeaderRule) error {
+	if err := config.ValidateHeaderRules(rules); err != nil {
+		return err
+	}
+
+	if err := a.configManager.UpdateHeaderRules(id, rules); err != nil {
+		return err
+	}
+
+	if err := a.configManager.SaveConfig(); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	a.proxyManager.UpdateHeaderRules(id, rules)
+	return nil
+}
+
+// SetBandwidthLimit 保存代理的带宽限制，运行中的代理立即生效
+func (a *App) SetBandwidthLimit(id string, limit *config.BandwidthLimit) error {
+	if err := config.ValidateBandwidthLimit(limit); err != nil {
+		return err
+	}
+
+	if err := a.configManager.UpdateBandwidthLimit(id, limit); err != nil {
+		return err
+	}
+
+	if err := a.configManager.SaveConfig(); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	a.proxyManager.UpdateBandwidthLimit(id, limit)
+	return nil
+}
+
+// SetConnectionLimits 保存代理的连接数限制和访问控制，运行中的代理对新连接立即生效
+func (a *App) SetConnectionLimits(id string, limits *config.ConnectionLimits) error {
+	if err := config.ValidateConnectionLimits(limits); err != nil {
+		return err
+	}
+
+	if err := a.configManager.UpdateConnectionLimits(id, limits); err != nil {
+		return err
+	}
+
+	if err := a.configManager.SaveConfig(); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	a.proxyManager.UpdateConnectionLimits(id, limits)
+	return nil
+}
+
+// SetTimeouts 保存代理的超时设置，运行中的代理对之后的连接立即生效
+func (a *App) SetTimeouts(id string, timeouts *config.Timeouts) error {
+	if err := config.ValidateTimeouts(timeouts); err != nil {
+		return err
+	}
+
+	if err := a.configManager.UpdateTimeouts(id, timeouts); err != nil {
+		return err
+	}
+
+	if err := a.configManager.SaveConfig(); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	a.proxyManager.UpdateTimeouts(id, timeouts)
+	return nil
+}
+
+// GetQuotaStatus 获取代理当前周期的配额用量
+func (a *App) GetQuotaStatus(id string) (*server.QuotaStatus, error) {
+	return a.proxyManager.GetQuotaStatus(id)
+}
+
+// ResetQuota 清零代理当前周期的配额用量
+func (a *App) ResetQuota(id string) error {
+	if err := a.proxyManager.ResetQuota(id); err != nil {
+		return err
+	}
+	log.Printf("已重置代理 %s 的流量配额", id)
+	return nil
 }
 
 // DeleteProxy 删除代理
@@ -303,89 +593,58 @@ func (a *App) StopAllProxies() []string {
 	return errorMessages
 }
 
-// GetProxyStatus 获取代理状态
-func (a *App) GetProxyStatus(id string) ProxyStatus {
-	running := a.proxyManager.IsProxyRunning(id)
-	return ProxyStatus{
-		ID:      id,
-		Running: running,
-		Error:   "",
-	}
+// GetConnections 获取代理的活动连接
+func (a *App) GetConnections(proxyID string) []server.ConnectionInfo {
+	return a.proxyManager.GetConnections(proxyID)
 }
 
-// ExportConfig 导出配置为CSV格式
-func (a *App) ExportConfig() (string, error) {
-	proxies := a.configManager.GetAllProxies()
-
-	// 创建CSV字符串，使用UTF-8 BOM
-	var csvData strings.Builder
-
-	// 添加UTF-8 BOM头，确保Excel正确识别中文编码
-	csvData.WriteString("\ufeff")
-
-	writer := csv.NewWriter(&csvData)
-
-	// 设置CSV分隔符为逗号（默认）
-	writer.Comma = ','
-
-	// 写入标题行
-	headers := []string{
-		"代理名称", "上游协议", "上游地址", "上游用户名", "上游密码",
-		"本地协议", "本地IP", "本地端口", "是否启用",
-	}
-	if err := writer.Write(headers); err != nil {
-		return "", fmt.Errorf("写入CSV标题失败: %w", err)
+// CloseConnection 断开指定连接
+func (a *App) CloseConnection(connID string) error {
+	if err := a.proxyManager.CloseConnection(connID); err != nil {
+		return err
 	}
+	log.Printf("已断开连接 %s", connID)
+	return nil
+}
 
-	// 写入数据行
-	for _, proxy := range proxies {
-		enabledStr := "否"
-		if proxy.Enabled {
-			enabledStr = "是"
-		}
+// GetTrafficStats 获取代理本次运行以来的累计流量和连接数
+func (a *App) GetTrafficStats(id string) server.TrafficStats {
+	return a.proxyManager.GetTrafficStats(id)
+}
 
-		row := []string{
-			proxy.Name,
-			proxy.Upstream.Protocol,
-			proxy.Upstream.Address,
-			proxy.Upstream.Username,
-			proxy.Upstream.Password,
-			proxy.Local.Protocol,
-			proxy.Local.ListenIP,
-			strconv.Itoa(proxy.Local.ListenPort),
-			enabledStr,
-		}
+// GetTrafficHistory 获取代理的流量历史，范围为 hour、day、week 或 month
+func (a *App) GetTrafficHistory(id string, rangeName string) (*server.TrafficHistory, error) {
+	return a.proxyManager.GetTrafficHistory(id, rangeName)
+}
 
-		if err := writer.Write(row); err != nil {
-			return "", fmt.Errorf("写入CSV数据失败: %w", err)
-		}
+// GetProxyStatus 获取代理状态
+func (a *App) GetProxyStatus(id string) ProxyStatus {
+	status := a.proxyManager.GetStatus(id)
+	return ProxyStatus{
+		ID:        id,
+		Running:   status.State == server.StateRunning,
+		State:     status.State.String(),
+		Error:     status.LastError,
+		StartedAt: status.StartedAt,
+		StoppedAt: status.StoppedAt,
 	}
+}
 
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		return "", fmt.Errorf("CSV写入出错: %w", err)
+// ExportConfig 导出配置为CSV格式，withPasswords 为 false 时不导出上游密码
+func (a *App) ExportConfig(withPasswords bool) (string, error) {
+	proxies := a.configManager.GetAllProxies()
+	result, err := config.ExportCSV(proxies, withPasswords)
+	if err != nil {
+		return "", err
 	}
-
-	// 记录生成的CSV内容用于调试
-	result := csvData.String()
-	log.Printf("导出CSV内容（前200字符）: %s", result[:min(200, len(result))])
 	log.Printf("导出了 %d 个代理配置", len(proxies))
-
 	return result, nil
 }
 
-// min 辅助函数
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // ExportConfigToFile 导出配置到用户选择的文件
-func (a *App) ExportConfigToFile() error {
+func (a *App) ExportConfigToFile(withPasswords bool) error {
 	// 获取CSV数据
-	csvData, err := a.ExportConfig()
+	csvData, err := a.ExportConfig(withPasswords)
 	if err != nil {
 		return fmt.Errorf("生成CSV数据失败: %w", err)
 	}
@@ -417,8 +676,8 @@ func (a *App) ExportConfigToFile() error {
 		return nil
 	}
 
-	// 写入文件
-	if err := os.WriteFile(filename, []byte(csvData), 0644); err != nil {
+	// 写入文件，可能包含上游密码，只允许当前用户读写
+	if err := os.WriteFile(filename, []byte(csvData), 0600); err != nil {
 		return fmt.Errorf("写入文件失败: %w", err)
 	}
 
@@ -427,73 +686,78 @@ func (a *App) ExportConfigToFile() error {
 
 // ImportConfig 导入CSV格式配置
 func (a *App) ImportConfig(configData string) error {
-	// 移除UTF-8 BOM（如果存在）
-	configData = strings.TrimPrefix(configData, "\ufeff")
-
-	reader := csv.NewReader(strings.NewReader(configData))
-
-	// 设置CSV分隔符
-	reader.Comma = ','
-
-	// 设置允许不等长的字段（容错处理）
-	reader.FieldsPerRecord = -1
-
-	records, err := reader.ReadAll()
+	imported, err := a.configManager.ImportCSV(configData)
 	if err != nil {
-		return fmt.Errorf("解析CSV数据失败: %w", err)
+		return err
 	}
+	log.Printf("导入了 %d 个代理配置", imported)
+	return a.configManager.SaveConfig()
+}
 
-	if len(records) < 2 {
-		return fmt.Errorf("CSV文件至少需要包含标题行和一行数据")
-	}
+// IsConfigLocked 配置文件已加密且还没有输入主密码时返回true
+func (a *App) IsConfigLocked() bool {
+	return a.configManager.Locked()
+}
 
-	// 跳过标题行，从第二行开始处理数据
-	for i, record := range records[1:] {
-		if len(record) < 9 {
-			log.Printf("第%d行数据不完整，跳过", i+2)
-			continue
-		}
+// IsConfigEncrypted 配置文件是否设置了主密码
+func (a *App) IsConfigEncrypted() bool {
+	return a.configManager.Encrypted()
+}
 
-		// 解析本地端口
-		localPort, err := strconv.Atoi(record[7])
-		if err != nil {
-			log.Printf("第%d行本地端口格式错误: %v，跳过", i+2, err)
-			continue
-		}
+// UnlockConfig 用主密码解锁配置文件，然后启动标记为自动启动的代理
+func (a *App) UnlockConfig(password string) error {
+	if !a.configManager.Locked() {
+		return nil
+	}
+	if err := a.configManager.Unlock(password); err != nil {
+		return err
+	}
+	log.Println("配置文件已解锁")
+	a.startConfiguredProxies()
+	return nil
+}
 
-		// 解析是否启用
-		enabled := record[8] == "是" || strings.ToLower(record[8]) == "true" || record[8] == "1"
+// ChangeMasterPassword 设置、更换或取消主密码，next 为空表示取消加密
+func (a *App) ChangeMasterPassword(current, next string) error {
+	if err := a.configManager.ChangeMasterPassword(current, next); err != nil {
+		return err
+	}
+	if next == "" {
+		log.Println("已取消主密码")
+	} else {
+		log.Println("主密码已更新")
+	}
+	return nil
+}
 
-		// 生成新的ID
-		id, err := generateUniqueID()
-		if err != nil {
-			return fmt.Errorf("生成ID失败: %w", err)
-		}
+// ListBackups 列出配置文件的自动备份，最新的在前
+func (a *App) ListBackups() ([]config.Backup, error) {
+	return a.configManager.ListBackups()
+}
 
-		proxy := &config.ProxyConfig{
-			ID:   id,
-			Name: record[0],
-			Upstream: config.UpstreamProxy{
-				Protocol:   record[1],
-				Address:    record[2],
-				Username:   record[3],
-				Password:   record[4],
-				AuthMethod: "basic",
-			},
-			Local: config.LocalProxy{
-				Protocol:   record[5],
-				ListenIP:   record[6],
-				ListenPort: localPort,
-			},
-			Enabled: enabled,
-		}
+// RestoreBackup 恢复配置备份，并按恢复后的配置停止、重启或启动代理
+func (a *App) RestoreBackup(name string) error {
+	if err := a.configManager.RestoreBackup(name); err != nil {
+		return err
+	}
+	log.Printf("已恢复配置备份 %s", name)
+	for _, err := range a.proxyManager.ReloadConfig() {
+		log.Printf("%v", err)
+	}
+	return nil
+}
 
-		if _, err := a.configManager.AddProxy(proxy); err != nil {
-			log.Printf("导入代理 %s 失败: %v", proxy.Name, err)
-		}
+// ParseUpstreamURL 解析 http://、socks5://、ss:// 代理链接，供前端填充上游配置
+func (a *App) ParseUpstreamURL(rawURL string) (*ProxyConfig, error) {
+	upstream, name, err := config.ParseUpstreamURL(rawURL)
+	if err != nil {
+		return nil, err
 	}
 
-	return a.configManager.SaveConfig()
+	return &ProxyConfig{
+		Name:     name,
+		Upstream: UpstreamProxy(upstream),
+	}, nil
 }
 
 // ImportConfigFromFile 从用户选择的文件导入配置
@@ -534,6 +798,79 @@ func (a *App) ImportConfigFromFile() error {
 	return a.ImportConfig(string(data))
 }
 
+// GetAppSettings 获取应用设置
+func (a *App) GetAppSettings() config.AppSettings {
+	return a.settingsManager.GetSettings()
+}
+
+// SetDrainTimeout 设置停止代理时等待连接结束的秒数，超时后强制关闭剩余连接
+func (a *App) SetDrainTimeout(seconds int) error {
+	if err := a.settingsManager.SetDrainTimeoutSeconds(seconds); err != nil {
+		return err
+	}
+	a.proxyManager.SetDrainTimeout(time.Duration(seconds) * time.Second)
+	return nil
+}
+
+// SetDefaultTimeouts 设置代理未单独设置时使用的超时，运行中的代理立即生效
+func (a *App) SetDefaultTimeouts(timeouts config.Timeouts) error {
+	if err := a.settingsManager.SetDefaultTimeouts(timeouts); err != nil {
+		return fmt.Errorf("超时设置无效: %w", err)
+	}
+	a.proxyManager.SetDefaultTimeouts(timeouts)
+	return nil
+}
+
+// TailLogs 返回最近的n条访问日志，之后的新记录通过 accesslog:entries 事件批量推送
+func (a *App) TailLogs(n int) []accesslog.Entry {
+	return a.accessLog.Tail(n)
+}
+
+// SetAccessLogSettings 设置访问日志的级别和轮转方式，立即生效
+func (a *App) SetAccessLogSettings(settings config.AccessLogSettings) error {
+	if err := a.settingsManager.SetAccessLogSettings(settings); err != nil {
+		return fmt.Errorf("访问日志设置无效: %w", err)
+	}
+	if a.accessLog != nil {
+		a.accessLog.SetSettings(settings)
+	}
+	return nil
+}
+
+// SetMetricsSettings 设置本地指标监听器，立即按新设置启动或停止
+func (a *App) SetMetricsSettings(settings config.MetricsSettings) error {
+	if settings.Enabled {
+		if err := settings.Validate(); err != nil {
+			return fmt.Errorf("指标设置无效: %w", err)
+		}
+		if err := a.proxyManager.StartMetrics(settings.ListenAddr); err != nil {
+			return err
+		}
+	} else {
+		a.proxyManager.StopMetrics()
+	}
+	if err := a.settingsManager.SetMetricsSettings(settings); err != nil {
+		return fmt.Errorf("指标设置无效: %w", err)
+	}
+	return nil
+}
+
+// SetAPISettings 设置本地管理API，立即按新设置启动或停止。启用时令牌为空会自动生成，返回保存后的设置
+func (a *App) SetAPISettings(settings config.APISettings) (config.APISettings, error) {
+	settings, err := a.settingsManager.SetAPISettings(settings)
+	if err != nil {
+		return settings, fmt.Errorf("API设置无效: %w", err)
+	}
+	if settings.Enabled {
+		if err := a.apiServer.Start(settings.ListenAddr, settings.Token); err != nil {
+			return settings, err
+		}
+	} else {
+		a.apiServer.Stop()
+	}
+	return settings, nil
+}
+
 // GetStats 获取统计信息
 func (a *App) GetStats() map[string]int {
 	proxies := a.configManager.GetAllProxies()
@@ -557,12 +894,3 @@ func (a *App) GetStats() map[string]int {
 		"enabled": enabled,
 	}
 }
-
-// generateUniqueID 生成唯一ID
-func generateUniqueID() (string, error) {
-	b := make([]byte, 8)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(b), nil
-}
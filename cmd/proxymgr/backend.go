@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"proxy-manager-desktop/internal/api"
+	"proxy-manager-desktop/internal/config"
+)
+
+// backend 命令操作代理的方式，连接管理API或直接读写配置文件
+type backend interface {
+	list() ([]api.Proxy, error)
+	status(id string) (api.Status, error)
+	add(proxy *config.ProxyConfig) (string, error)
+	start(id string) error
+	stop(id string) error
+	importCSV(data string) (int, error)
+	exportCSV(withPasswords bool) (string, error)
+	changeMasterPassword(current, next string) error
+}
+
+// openBackend 选择操作方式：-direct 时直接读写配置文件，否则连接 -api 指定的管理API，
+// 未指定时读取配置文件旁的应用设置，启用了管理API就连接它
+func openBackend(configPath, apiURL, token string, direct bool) (backend, error) {
+	if !direct && apiURL == "" {
+		if settings, ok := readAPISettings(filepath.Dir(configPath)); ok && settings.Enabled {
+			apiURL = "http://" + localAddr(settings.ListenAddr)
+			if token == "" {
+				token = settings.Token
+			}
+		}
+	}
+	if direct || apiURL == "" {
+		// 配置文件无法解析时不能继续，否则保存时会覆盖原有内容
+		configManager := config.NewConfigManager(configPath)
+		if err := configManager.LoadConfig(); err != nil {
+			if errors.Is(err, config.ErrLocked) {
+				return nil, &exitError{exitUsage, fmt.Errorf("%w，请通过环境变量 %s 提供正确的主密码", err, config.MasterPasswordEnv)}
+			}
+			return nil, err
+		}
+		return &directBackend{configManager: configManager}, nil
+	}
+	return &remoteBackend{
+		base:   strings.TrimSuffix(apiURL, "/") + "/api/v1",
+		token:  token,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// readAPISettings 只读取应用设置中的管理API部分，不创建或改写设置文件
+func readAPISettings(dir string) (config.APISettings, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "app_settings.json"))
+	if err != nil {
+		return config.APISettings{}, false
+	}
+	var settings config.AppSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return config.APISettings{}, false
+	}
+	return settings.API, true
+}
+
+// localAddr 把监听所有地址的 0.0.0.0 和 :: 换成本机地址，用于连接
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// remoteBackend 通过运行中实例的管理API操作，代理的启停立即生效
+type remoteBackend struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func (r *remoteBackend) do(method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, r.base+path, body)
+	if err != nil {
+		return usageError("无效的管理API地址: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &exitError{exitUnavailable, fmt.Errorf("无法连接管理API: %w（可以使用 -direct 直接修改配置文件）", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr api.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		err := errors.New(apiErr.Error)
+		if len(apiErr.Fields) > 0 { // 配置校验失败，与直接模式一样按用法错误退出
+			return &exitError{exitUsage, err}
+		}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return &exitError{exitUnavailable, err}
+		case http.StatusNotFound:
+			return &exitError{exitNotFound, err}
+		default:
+			return err
+		}
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		data, err := io.ReadAll(resp.Body)
+		*out = string(data)
+		return err
+	default:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("无法解析管理API的响应: %w", err)
+		}
+		return nil
+	}
+}
+
+func (r *remoteBackend) list() ([]api.Proxy, error) {
+	var proxies []api.Proxy
+	if err := r.do(http.MethodGet, "/proxies", "", nil, &proxies); err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+func (r *remoteBackend) status(id string) (api.Status, error) {
+	var status api.Status
+	if err := r.do(http.MethodGet, "/proxies/"+id+"/status", "", nil, &status); err != nil {
+		return api.Status{}, err
+	}
+	return status, nil
+}
+
+func (r *remoteBackend) add(proxy *config.ProxyConfig) (string, error) {
+	body, err := json.Marshal(proxy)
+	if err != nil {
+		return "", err
+	}
+	var created api.CreatedResponse
+	if err := r.do(http.MethodPost, "/proxies", "application/json", bytes.NewReader(body), &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (r *remoteBackend) start(id string) error {
+	return r.do(http.MethodPost, "/proxies/"+id+"/start", "", nil, nil)
+}
+
+func (r *remoteBackend) stop(id string) error {
+	return r.do(http.MethodPost, "/proxies/"+id+"/stop", "", nil, nil)
+}
+
+func (r *remoteBackend) importCSV(data string) (int, error) {
+	var result api.ImportResponse
+	if err := r.do(http.MethodPost, "/import", "text/csv", strings.NewReader(data), &result); err != nil {
+		return 0, err
+	}
+	return result.Imported, nil
+}
+
+func (r *remoteBackend) changeMasterPassword(current, next string) error {
+	return usageError("主密码只能在 -direct 模式下修改")
+}
+
+func (r *remoteBackend) exportCSV(withPasswords bool) (string, error) {
+	var data string
+	if err := r.do(http.MethodGet, "/export?passwords="+strconv.FormatBool(withPasswords), "", nil, &data); err != nil {
+		return "", err
+	}
+	return data, nil
+}
+
+// directBackend 直接读写配置文件。没有运行中的代理，启停只修改自动启动标记，
+// 在桌面应用或 proxymgrd 下次启动或重新加载配置时生效
+type directBackend struct {
+	configManager *config.ConfigManager
+}
+
+func (d *directBackend) list() ([]api.Proxy, error) {
+	var proxies []api.Proxy
+	for _, proxy := range d.configManager.GetAllProxies() {
+		proxies = append(proxies, api.Proxy{ProxyConfig: proxy, State: "unknown"})
+	}
+	return proxies, nil
+}
+
+func (d *directBackend) status(id string) (api.Status, error) {
+	return api.Status{ID: id, State: "unknown"}, nil
+}
+
+func (d *directBackend) add(proxy *config.ProxyConfig) (string, error) {
+	if err := d.configManager.ValidateProxy(proxy, false); err != nil {
+		return "", &exitError{exitUsage, err}
+	}
+	proxy.AutoStart = proxy.Enabled
+	id, err := d.configManager.AddProxy(proxy)
+	if err != nil {
+		return "", err
+	}
+	return id, d.configManager.SaveConfig()
+}
+
+func (d *directBackend) setAutoStart(id string, autoStart bool) error {
+	proxy, err := d.configManager.GetProxy(id)
+	if err != nil {
+		return &exitError{exitNotFound, err}
+	}
+	updated := *proxy
+	updated.AutoStart = autoStart
+	if autoStart {
+		updated.Enabled = true
+	}
+	if err := d.configManager.UpdateProxy(&updated); err != nil {
+		return err
+	}
+	return d.configManager.SaveConfig()
+}
+
+func (d *directBackend) start(id string) error { return d.setAutoStart(id, true) }
+func (d *directBackend) stop(id string) error  { return d.setAutoStart(id, false) }
+
+func (d *directBackend) importCSV(data string) (int, error) {
+	imported, err := d.configManager.ImportCSV(data)
+	if err != nil {
+		return 0, &exitError{exitUsage, err}
+	}
+	return imported, d.configManager.SaveConfig()
+}
+
+func (d *directBackend) exportCSV(withPasswords bool) (string, error) {
+	return config.ExportCSV(d.configManager.GetAllProxies(), withPasswords)
+}
+
+func (d *directBackend) changeMasterPassword(current, next string) error {
+	if err := d.configManager.ChangeMasterPassword(current, next); err != nil {
+		if errors.Is(err, config.ErrWrongPassword) {
+			return &exitError{exitUsage, err}
+		}
+		return err
+	}
+	return nil
+}
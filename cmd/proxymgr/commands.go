@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"proxy-manager-desktop/internal/api"
+	"proxy-manager-desktop/internal/config"
+)
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// resolve 按ID或名称查找代理，名称对应多个代理时要求使用ID
+func resolve(proxies []api.Proxy, ref string) (api.Proxy, error) {
+	var matches []api.Proxy
+	for _, proxy := range proxies {
+		if proxy.ID == ref {
+			return proxy, nil
+		}
+		if proxy.Name == ref {
+			matches = append(matches, proxy)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return api.Proxy{}, &exitError{exitNotFound, fmt.Errorf("代理 %s 不存在", ref)}
+	case 1:
+		return matches[0], nil
+	default:
+		return api.Proxy{}, usageError("有 %d 个代理名为 %s，请使用ID", len(matches), ref)
+	}
+}
+
+// resolveAll 查找参数中的所有代理，没有参数时返回全部代理
+func resolveAll(b backend, refs []string) ([]api.Proxy, error) {
+	proxies, err := b.list()
+	if err != nil || len(refs) == 0 {
+		return proxies, err
+	}
+	var selected []api.Proxy
+	for _, ref := range refs {
+		proxy, err := resolve(proxies, ref)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, proxy)
+	}
+	return selected, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func localURL(local config.LocalProxy) string {
+	return fmt.Sprintf("%s://%s", local.Protocol, net.JoinHostPort(local.ListenIP, strconv.Itoa(local.ListenPort)))
+}
+
+// redactedPassword 输出中代替上游密码显示的内容
+const redactedPassword = "******"
+
+// redactPassword 返回隐藏了上游密码的副本，list 的输出常被复制到别处，不应带出密码
+func redactPassword(proxy api.Proxy) api.Proxy {
+	if proxy.ProxyConfig == nil || proxy.Upstream.Password == "" {
+		return proxy
+	}
+	copied := *proxy.ProxyConfig
+	copied.Upstream.Password = redactedPassword
+	proxy.ProxyConfig = &copied
+	return proxy
+}
+
+func runList(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	proxies, err := b.list()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		redacted := make([]api.Proxy, 0, len(proxies))
+		for _, proxy := range proxies {
+			redacted = append(redacted, redactPassword(proxy))
+		}
+		return writeJSON(stdout, redacted)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\t名称\t本地\t上游\t启用\t状态")
+	for _, proxy := range proxies {
+		enabled := "否"
+		if proxy.Enabled {
+			enabled = "是"
+		}
+		upstream := fmt.Sprintf("%s://%s", proxy.Upstream.Protocol, proxy.Upstream.Address)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", proxy.ID, orDash(proxy.Name), localURL(proxy.Local), upstream, enabled, proxy.State)
+	}
+	return tw.Flush()
+}
+
+func runStatus(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	refs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	proxies, err := resolveAll(b, refs)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		statuses := []api.Status{}
+		for _, proxy := range proxies {
+			status, err := b.status(proxy.ID)
+			if err != nil {
+				return err
+			}
+			statuses = append(statuses, status)
+		}
+		return writeJSON(stdout, statuses)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\t名称\t状态\t错误")
+	for _, proxy := range proxies {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", proxy.ID, orDash(proxy.Name), proxy.State, orDash(proxy.Error))
+	}
+	return tw.Flush()
+}
+
+// parseLocalURL 解析 http://127.0.0.1:8001 形式的本地监听地址
+func parseLocalURL(raw string) (config.LocalProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return config.LocalProxy{}, fmt.Errorf("无法解析本地地址: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return config.LocalProxy{}, fmt.Errorf("本地代理协议只能是 http 或 socks5: %q", u.Scheme)
+	}
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return config.LocalProxy{}, fmt.Errorf("本地地址缺少端口: %s", u.Host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return config.LocalProxy{}, fmt.Errorf("无效的本地端口: %s", portStr)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return config.LocalProxy{Protocol: u.Scheme, ListenIP: host, ListenPort: port}, nil
+}
+
+func runAdd(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	upstreamURL := fs.String("upstream", "", "")
+	localStr := fs.String("local", "", "")
+	name := fs.String("name", "", "")
+	disabled := fs.Bool("disabled", false, "")
+	asJSON := fs.Bool("json", false, "")
+	if rest, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usageError("add: 多余的参数 %v", rest)
+	}
+	if *upstreamURL == "" || *localStr == "" {
+		return usageError("add: 必须指定 --upstream 和 --local")
+	}
+
+	upstream, linkName, err := config.ParseUpstreamURL(*upstreamURL)
+	if err != nil {
+		return &exitError{exitUsage, err}
+	}
+	local, err := parseLocalURL(*localStr)
+	if err != nil {
+		return &exitError{exitUsage, err}
+	}
+	if *name == "" {
+		*name = linkName
+	}
+	if *name == "" {
+		*name = upstream.Address
+	}
+
+	id, err := b.add(&config.ProxyConfig{
+		Name:     *name,
+		Upstream: upstream,
+		Local:    local,
+		Enabled:  !*disabled,
+	})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(stdout, api.CreatedResponse{ID: id})
+	}
+	fmt.Fprintln(stdout, id)
+	return nil
+}
+
+// runStartStop 依次处理所有参数中的代理，有失败时继续处理其余代理，返回最后一个错误
+func runStartStop(name string, op func(b backend, id string) error) command {
+	return func(b backend, args []string, stdout, stderr io.Writer) error {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		refs, err := parseArgs(fs, args)
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			return usageError("%s: 需要指定代理", name)
+		}
+
+		proxies, err := resolveAll(b, refs)
+		if err != nil {
+			return err
+		}
+		var lastErr error
+		for _, proxy := range proxies {
+			if err := op(b, proxy.ID); err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", proxy.ID, err)
+				lastErr = err
+			}
+		}
+		if _, ok := b.(*directBackend); ok && lastErr == nil {
+			fmt.Fprintln(stderr, "已修改配置文件，将在桌面应用或 proxymgrd 下次启动或重新加载配置时生效")
+		}
+		return lastErr
+	}
+}
+
+var (
+	runStart = runStartStop("start", backend.start)
+	runStop  = runStartStop("stop", backend.stop)
+)
+
+// TestResult test 命令的结果
+type TestResult struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	OK        bool   `json:"ok"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+func runTest(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	target := fs.String("url", "http://www.gstatic.com/generate_204", "")
+	timeout := fs.Duration("timeout", 10*time.Second, "")
+	asJSON := fs.Bool("json", false, "")
+	refs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) != 1 {
+		return usageError("test: 需要指定一个代理")
+	}
+
+	proxies, err := resolveAll(b, refs)
+	if err != nil {
+		return err
+	}
+	proxy := proxies[0]
+
+	// 通过本地监听地址访问，检查本地代理和上游整条链路
+	local := proxy.Local
+	proxyURL := &url.URL{Scheme: local.Protocol, Host: localAddr(net.JoinHostPort(local.ListenIP, strconv.Itoa(local.ListenPort)))}
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true},
+	}
+
+	result := TestResult{ID: proxy.ID, Name: proxy.Name, URL: *target}
+	start := time.Now()
+	resp, err := client.Get(*target)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err == nil {
+		resp.Body.Close()
+		result.Status = resp.StatusCode
+		result.OK = resp.StatusCode < 500
+		if !result.OK {
+			err = fmt.Errorf("测试地址返回 %s", resp.Status)
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if *asJSON {
+		if err := writeJSON(stdout, result); err != nil {
+			return err
+		}
+	} else if result.OK {
+		fmt.Fprintf(stdout, "%s 可用: HTTP %d，耗时 %dms\n", proxy.ID, result.Status, result.LatencyMs)
+	}
+	if !result.OK {
+		return &exitError{exitTestFailed, fmt.Errorf("通过代理 %s 访问 %s 失败: %s", proxy.ID, *target, result.Error)}
+	}
+	return nil
+}
+
+func runImport(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) > 1 {
+		return usageError("import: 只能指定一个文件")
+	}
+
+	var data []byte
+	if len(files) == 0 || files[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(files[0])
+	}
+	if err != nil {
+		return fmt.Errorf("读取CSV失败: %w", err)
+	}
+
+	imported, err := b.importCSV(string(data))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(stdout, api.ImportResponse{Imported: imported})
+	}
+	fmt.Fprintf(stdout, "导入了 %d 个代理\n", imported)
+	return nil
+}
+
+func runExport(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "")
+	withPasswords := fs.Bool("with-passwords", false, "")
+	if rest, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usageError("export: 多余的参数 %v", rest)
+	}
+
+	data, err := b.exportCSV(*withPasswords)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = io.WriteString(stdout, data)
+		return err
+	}
+	if err := os.WriteFile(*output, []byte(data), 0600); err != nil {
+		return fmt.Errorf("写入文件失败: %w", err)
+	}
+	return nil
+}
+
+func runPasswd(b backend, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("passwd", flag.ContinueOnError)
+	remove := fs.Bool("remove", false, "")
+	if rest, err := parseArgs(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usageError("passwd: 多余的参数 %v", rest)
+	}
+
+	var next string
+	if !*remove {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("读取新主密码失败: %w", err)
+		}
+		if next = strings.TrimRight(line, "\r\n"); next == "" {
+			return usageError("passwd: 新主密码不能为空，取消加密请使用 --remove")
+		}
+	}
+
+	if err := b.changeMasterPassword(os.Getenv(config.MasterPasswordEnv), next); err != nil {
+		return err
+	}
+	if *remove {
+		fmt.Fprintln(stdout, "已取消主密码，上游密码以明文保存")
+	} else {
+		fmt.Fprintf(stdout, "主密码已更新，之后请用新密码设置 %s\n", config.MasterPasswordEnv)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// proxymgrd 不带窗口运行代理管理器，适合在服务器上使用。
+// 配置文件与桌面应用通用，应用设置、访问日志和流量记录放在配置文件所在目录。
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"proxy-manager-desktop/internal/accesslog"
+	"proxy-manager-desktop/internal/api"
+	"proxy-manager-desktop/internal/config"
+	"proxy-manager-desktop/internal/instance"
+	"proxy-manager-desktop/internal/server"
+)
+
+func main() {
+	configPath := flag.String("config", "config.json", "代理配置文件路径")
+	printOpenAPI := flag.Bool("openapi", false, "输出管理API的OpenAPI文档后退出")
+	flag.Parse()
+
+	if *printOpenAPI {
+		doc, err := api.OpenAPI()
+		if err != nil {
+			log.Fatalf("生成OpenAPI文档失败: %v", err)
+		}
+		os.Stdout.Write(append(doc, '\n'))
+		return
+	}
+
+	if _, err := os.Stat(*configPath); err != nil {
+		log.Fatalf("无法读取配置文件: %v", err)
+	}
+	log.Printf("使用配置文件路径: %s", *configPath)
+	configDir := filepath.Dir(*configPath)
+
+	// 与桌面应用共用实例锁，避免两个进程同时使用相同端口和改写配置文件
+	inst, err := instance.Lock(configDir)
+	if errors.Is(err, instance.ErrRunning) {
+		log.Fatalf("配置目录 %s 已有代理管理器在运行", configDir)
+	}
+	if err != nil {
+		log.Fatalf("无法获取实例锁: %v", err)
+	}
+	defer inst.Close()
+	inst.Serve(func(args []string) error {
+		return errors.New("proxymgrd 不接受命令行参数，请使用 proxymgr 管理代理")
+	})
+
+	configManager := config.NewConfigManager(*configPath)
+	if configManager.Locked() {
+		inst.Close()
+		log.Fatalf("配置文件已加密，请通过环境变量 %s 提供正确的主密码", config.MasterPasswordEnv)
+	}
+	settingsManager := config.NewAppSettingsManager(configDir)
+	settings := settingsManager.GetSettings()
+
+	proxyManager := server.NewProxyManager(configManager)
+	proxyManager.SetDrainTimeout(time.Duration(settings.DrainTimeoutSeconds) * time.Second)
+	proxyManager.SetDefaultTimeouts(settings.DefaultTimeouts)
+
+	accessLog, err := accesslog.Open(filepath.Join(configDir, "logs", "access.log"), settings.AccessLog)
+	if err != nil {
+		log.Printf("打开访问日志失败: %v", err)
+	} else {
+		proxyManager.SetAccessLog(accessLog)
+	}
+	if err := proxyManager.EnableTrafficHistory(filepath.Join(configDir, "traffic.log")); err != nil {
+		log.Printf("启用流量历史失败: %v", err)
+	}
+	if err := proxyManager.EnableQuotas(filepath.Join(configDir, "quota_state.json")); err != nil {
+		log.Printf("启用流量配额失败: %v", err)
+	}
+	if settings.Metrics.Enabled {
+		if err := proxyManager.StartMetrics(settings.Metrics.ListenAddr); err != nil {
+			log.Printf("启动指标服务失败: %v", err)
+		}
+	}
+
+	apiServer := api.NewServer(configManager, proxyManager)
+	if settings.API.Enabled {
+		if err := apiServer.Start(settings.API.ListenAddr, settings.API.Token); err != nil {
+			log.Printf("启动管理API失败: %v", err)
+		}
+	}
+
+	for _, err := range proxyManager.StartAutoStartProxies() {
+		log.Printf("%v", err)
+	}
+	log.Printf("代理管理器已启动，%d 个代理正在运行", len(proxyManager.GetRunningProxies()))
+
+	// 配置文件被修改后自动重新加载，也可以发送重新加载信号立即重新加载
+	proxyManager.WatchConfig(server.DefaultConfigWatchInterval, nil)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(stopSignals, reloadSignals...)...)
+	for sig := range signals {
+		if !isReloadSignal(sig) {
+			log.Printf("收到信号 %s，正在停止...", sig)
+			break
+		}
+		log.Println("收到重新加载信号，重新读取配置文件")
+		for _, err := range proxyManager.ReloadConfig() {
+			log.Printf("%v", err)
+		}
+	}
+	signal.Stop(signals)
+
+	proxyManager.StopWatchingConfig()
+	apiServer.Stop()
+
+	// 与桌面应用关闭时一样，先保存运行状态再停止代理
+	if err := proxyManager.SaveRunningState(); err != nil {
+		log.Printf("保存配置失败: %v", err)
+	} else {
+		log.Println("代理状态已保存")
+	}
+	for _, err := range proxyManager.StopAllProxies() {
+		log.Printf("%v", err)
+	}
+	proxyManager.StopMetrics()
+	proxyManager.CloseQuotas()
+	proxyManager.CloseTrafficHistory()
+	accessLog.Close()
+
+	log.Println("代理管理器已停止")
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	stopSignals   = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+)
+
+func isReloadSignal(sig os.Signal) bool {
+	return sig == syscall.SIGHUP
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows没有SIGHUP，只处理停止信号
+var (
+	stopSignals   = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	reloadSignals []os.Signal
+)
+
+func isReloadSignal(sig os.Signal) bool {
+	return false
+}
@@ -11,19 +11,47 @@ import {
     StopAllProxies,
     GetStats,
     ExportConfigToFile,
-    ImportConfigFromFile
+    ImportConfigFromFile,
+    GetConnections,
+    CloseConnection,
+    TailLogs,
+    ListBackups,
+    RestoreBackup,
+    IsConfigLocked,
+    IsConfigEncrypted,
+    UnlockConfig,
+    ChangeMasterPassword,
+    ParseUpstreamURL
 } from '../wailsjs/go/main/App'
 
-import { BrowserOpenURL } from '../wailsjs/runtime/runtime'
+import { BrowserOpenURL, EventsOn } from '../wailsjs/runtime/runtime'
+
+function formatBytes(bytes) {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let value = bytes || 0;
+    let i = 0;
+    while (value >= 1024 && i < units.length - 1) {
+        value /= 1024;
+        i++;
+    }
+    return `${i === 0 ? value : value.toFixed(1)} ${units[i]}`;
+}
+
+// errorMessage 绑定方法的错误一般是字符串，配置校验错误是 {message, fields}
+function errorMessage(error) {
+    return error && error.message ? error.message : String(error);
+}
 
 class ProxyManager {
     constructor() {
         this.proxies = [];
         this.selectedProxies = new Set();
         this.currentEditingProxy = null; // 添加当前编辑的代理
+        this.connectionsProxyId = null; // 正在查看连接的代理
         this.initializeElements();
         this.bindEvents();
         this.loadProxies();
+        this.checkLocked();
         setInterval(() => this.loadProxies(), 15000); // 改为15秒刷新一次
     }
 
@@ -45,6 +73,33 @@ class ProxyManager {
         this.proxyForm = document.getElementById('proxyForm');
         this.closeBtn = document.querySelector('.close');
         this.cancelBtn = document.getElementById('cancelBtn');
+        this.upstreamProtocol = document.getElementById('upstreamProtocol');
+        this.upstreamCipherRow = document.getElementById('upstreamCipherRow');
+        this.upstreamURL = document.getElementById('upstreamURL');
+        this.upstreamURLError = document.getElementById('upstreamURLError');
+        this.connectionsModal = document.getElementById('connectionsModal');
+        this.connectionsTitle = document.getElementById('connectionsTitle');
+        this.connectionsList = document.getElementById('connectionsList');
+        this.connectionsCloseBtn = document.getElementById('connectionsCloseBtn');
+        this.logsBtn = document.getElementById('logsBtn');
+        this.logsModal = document.getElementById('logsModal');
+        this.logsList = document.getElementById('logsList');
+        this.logsCloseBtn = document.getElementById('logsCloseBtn');
+        this.backupsBtn = document.getElementById('backupsBtn');
+        this.backupsModal = document.getElementById('backupsModal');
+        this.backupsList = document.getElementById('backupsList');
+        this.backupsCloseBtn = document.getElementById('backupsCloseBtn');
+        this.unlockModal = document.getElementById('unlockModal');
+        this.unlockForm = document.getElementById('unlockForm');
+        this.unlockPassword = document.getElementById('unlockPassword');
+        this.unlockError = document.getElementById('unlockError');
+        this.masterPasswordBtn = document.getElementById('masterPasswordBtn');
+        this.masterPasswordModal = document.getElementById('masterPasswordModal');
+        this.masterPasswordForm = document.getElementById('masterPasswordForm');
+        this.masterPasswordCloseBtn = document.getElementById('masterPasswordCloseBtn');
+        this.currentMasterPasswordRow = document.getElementById('currentMasterPasswordRow');
+        this.removeMasterPasswordBtn = document.getElementById('removeMasterPasswordBtn');
+        this.masterPasswordError = document.getElementById('masterPasswordError');
     }
 
     bindEvents() {
@@ -57,6 +112,34 @@ class ProxyManager {
         this.deleteSelectedBtn.addEventListener('click', () => this.deleteSelectedProxies());
         this.closeBtn.addEventListener('click', () => this.hideModal());
         this.cancelBtn.addEventListener('click', () => this.hideModal());
+        this.upstreamProtocol.addEventListener('change', () => this.updateCipherVisibility());
+        this.upstreamURL.addEventListener('change', () => this.applyUpstreamURL());
+        this.upstreamURL.addEventListener('keydown', (e) => {
+            // 回车时只解析链接，不提交表单
+            if (e.key === 'Enter') {
+                e.preventDefault();
+                this.applyUpstreamURL();
+            }
+        });
+        this.connectionsCloseBtn.addEventListener('click', () => this.hideConnections());
+        this.logsBtn.addEventListener('click', () => this.showLogs());
+        this.logsCloseBtn.addEventListener('click', () => this.hideLogs());
+        this.backupsBtn.addEventListener('click', () => this.showBackups());
+        this.backupsCloseBtn.addEventListener('click', () => this.hideBackups());
+        this.unlockForm.addEventListener('submit', (e) => this.unlock(e));
+        this.masterPasswordBtn.addEventListener('click', () => this.showMasterPassword());
+        this.masterPasswordCloseBtn.addEventListener('click', () => this.hideMasterPassword());
+        this.masterPasswordForm.addEventListener('submit', (e) => this.saveMasterPassword(e));
+        this.removeMasterPasswordBtn.addEventListener('click', () => this.removeMasterPassword());
+        EventsOn('accesslog:entries', (entries) => (entries || []).forEach(entry => this.onLogEntry(entry)));
+        EventsOn('proxies:changed', () => this.loadProxies());
+        EventsOn('config:reloaded', errors => {
+            this.showNotice(errors && errors.length ? `重新加载配置文件时出错: ${errors.join('; ')}` : '配置文件已修改，已重新加载');
+            this.loadProxies();
+        });
+        EventsOn('connections:update', (connections) => this.onConnectionsUpdate(connections || []));
+        EventsOn('quota:warning', (status) => this.onQuotaEvent(status, `已使用 ${status.threshold}% 流量配额`));
+        EventsOn('quota:exceeded', (status) => this.onQuotaEvent(status, status.action === 'stop' ? '已达到流量配额，代理已停止' : '已达到流量配额，正在拒绝新连接'));
         
         if (this.modalContent) {
             this.modalContent.addEventListener('click', (e) => {
@@ -129,7 +212,8 @@ class ProxyManager {
         div.className = 'proxy-item';
         const isSelected = this.selectedProxies.has(proxy.id);
         const statusClass = proxy.running ? 'status-running' : 'status-stopped';
-        const statusText = proxy.running ? '运行中' : '已停止';
+        const stateText = { starting: '启动中', running: '运行中', stopping: '停止中', failed: '运行失败' };
+        const statusText = stateText[proxy.state] || '已停止';
         const actionText = proxy.running ? '停止' : '启动';
         const actionClass = proxy.running ? 'btn-danger' : 'btn-success';
         
@@ -142,13 +226,14 @@ class ProxyManager {
                 <div class="proxy-endpoints-compact">
                     上游: ${proxy.upstream.protocol}://${proxy.upstream.address} | 本地: ${proxy.local.protocol}://${proxy.local.listen_ip}:${proxy.local.listen_port}
                 </div>
-                <div class="proxy-status-inline ${statusClass}">
+                <div class="proxy-status-inline ${statusClass}" title="${proxy.error || ''}">
                     <div class="status-dot"></div>
                     <span>${statusText}</span>
                 </div>
             </div>
             <div class="proxy-actions-inline">
                 <button class="btn ${actionClass} toggle-btn" data-id="${proxy.id}">${actionText}</button>
+                <button class="btn btn-outline conn-btn" data-id="${proxy.id}" ${proxy.running ? '' : 'disabled'}>连接</button>
                 <button class="btn btn-outline edit-btn" data-id="${proxy.id}">编辑</button>
                 <button class="btn btn-danger delete-btn" data-id="${proxy.id}">删除</button>
             </div>
@@ -156,11 +241,13 @@ class ProxyManager {
 
         const checkbox = div.querySelector('input[type="checkbox"]');
         const toggleBtn = div.querySelector('.toggle-btn');
+        const connBtn = div.querySelector('.conn-btn');
         const editBtn = div.querySelector('.edit-btn');
         const deleteBtn = div.querySelector('.delete-btn');
 
         checkbox.addEventListener('change', () => this.toggleProxySelection(proxy.id));
         toggleBtn.addEventListener('click', () => this.toggleProxy(proxy.id, proxy.running));
+        connBtn.addEventListener('click', () => this.showConnections(proxy));
         editBtn.addEventListener('click', () => this.showEditModal(proxy));
         deleteBtn.addEventListener('click', () => this.deleteProxy(proxy.id, proxy.name));
 
@@ -260,6 +347,7 @@ class ProxyManager {
             await this.loadProxies();
         } catch (error) {
             console.error('切换代理状态失败:', error);
+            alert(`${isRunning ? '停止' : '启动'}代理失败: ${errorMessage(error)}`);
         }
     }
 
@@ -280,6 +368,8 @@ class ProxyManager {
         this.proxyForm.reset();
         document.getElementById('localIP').value = '127.0.0.1';
         document.getElementById('proxyEnabled').checked = true;
+        this.upstreamURLError.textContent = '';
+        this.updateCipherVisibility();
         this.showModal();
     }
 
@@ -291,42 +381,411 @@ class ProxyManager {
         document.getElementById('upstreamAddress').value = proxy.upstream.address;
         document.getElementById('upstreamUsername').value = proxy.upstream.username || '';
         document.getElementById('upstreamPassword').value = proxy.upstream.password || '';
+        document.getElementById('upstreamCipher').value = proxy.upstream.cipher || 'aes-128-gcm';
+        this.upstreamURL.value = '';
+        this.upstreamURLError.textContent = '';
         document.getElementById('localProtocol').value = proxy.local.protocol;
         document.getElementById('localIP').value = proxy.local.listen_ip;
         document.getElementById('localPort').value = proxy.local.listen_port;
         document.getElementById('proxyEnabled').checked = proxy.enabled;
+        this.setBandwidthFields(proxy.bandwidth);
+        this.setLimitFields(proxy.limits);
+        this.setTimeoutFields(proxy.timeouts);
+        this.updateCipherVisibility();
         this.showModal();
     }
 
+    setBandwidthFields(bandwidth) {
+        const toKB = (bps) => bps ? Math.round(bps / 1024) : '';
+        const limit = bandwidth || {};
+        document.getElementById('bandwidthUpload').value = toKB(limit.upload_bps);
+        document.getElementById('bandwidthDownload').value = toKB(limit.download_bps);
+        document.getElementById('bandwidthConnUpload').value = toKB(limit.conn_upload_bps);
+        document.getElementById('bandwidthConnDownload').value = toKB(limit.conn_download_bps);
+    }
+
+    // getBandwidthFields 读取限速设置，全部留空时返回null表示不限速
+    getBandwidthFields() {
+        const toBps = (id) => (parseInt(document.getElementById(id).value) || 0) * 1024;
+        const limit = {
+            upload_bps: toBps('bandwidthUpload'),
+            download_bps: toBps('bandwidthDownload'),
+            conn_upload_bps: toBps('bandwidthConnUpload'),
+            conn_download_bps: toBps('bandwidthConnDownload')
+        };
+        return Object.values(limit).some(v => v > 0) ? limit : null;
+    }
+
+    setLimitFields(limits) {
+        const l = limits || {};
+        document.getElementById('limitMaxConnections').value = l.max_connections || '';
+        document.getElementById('limitMaxPerIP').value = l.max_connections_per_ip || '';
+        document.getElementById('limitAllow').value = (l.allow || []).join('\n');
+        document.getElementById('limitDeny').value = (l.deny || []).join('\n');
+    }
+
+    // getLimitFields 读取连接限制，全部留空时返回null表示不限制
+    getLimitFields() {
+        const lines = (id) => document.getElementById(id).value.split('\n').map(s => s.trim()).filter(Boolean);
+        const limits = {
+            max_connections: parseInt(document.getElementById('limitMaxConnections').value) || 0,
+            max_connections_per_ip: parseInt(document.getElementById('limitMaxPerIP').value) || 0,
+            allow: lines('limitAllow'),
+            deny: lines('limitDeny')
+        };
+        const empty = !limits.max_connections && !limits.max_connections_per_ip && !limits.allow.length && !limits.deny.length;
+        return empty ? null : limits;
+    }
+
+    setTimeoutFields(timeouts) {
+        const t = timeouts || {};
+        document.getElementById('timeoutDial').value = t.dial_seconds || '';
+        document.getElementById('timeoutHandshake').value = t.handshake_seconds || '';
+        document.getElementById('timeoutIdle').value = t.idle_seconds || '';
+        document.getElementById('timeoutKeepAlive').value = t.keepalive_seconds || '';
+    }
+
+    // getTimeoutFields 读取超时设置，全部留空时返回null表示使用全局默认值，空闲超时填-1表示不启用
+    getTimeoutFields() {
+        const seconds = (id) => parseInt(document.getElementById(id).value) || 0;
+        const timeouts = {
+            dial_seconds: seconds('timeoutDial'),
+            handshake_seconds: seconds('timeoutHandshake'),
+            idle_seconds: seconds('timeoutIdle'),
+            keepalive_seconds: seconds('timeoutKeepAlive')
+        };
+        return Object.values(timeouts).some(v => v !== 0) ? timeouts : null;
+    }
+
+    // applyUpstreamURL 解析粘贴的代理链接并填写上游设置，名称为空时使用链接中的名称
+    async applyUpstreamURL() {
+        const link = this.upstreamURL.value.trim();
+        this.upstreamURLError.textContent = '';
+        if (!link) {
+            return;
+        }
+        try {
+            const parsed = await ParseUpstreamURL(link);
+            const upstream = parsed.upstream;
+            document.getElementById('upstreamProtocol').value = upstream.protocol;
+            document.getElementById('upstreamAddress').value = upstream.address;
+            document.getElementById('upstreamUsername').value = upstream.username || '';
+            document.getElementById('upstreamPassword').value = upstream.password || '';
+            if (upstream.cipher) {
+                document.getElementById('upstreamCipher').value = upstream.cipher;
+            }
+            const nameInput = document.getElementById('proxyName');
+            if (parsed.name && !nameInput.value.trim()) {
+                nameInput.value = parsed.name;
+            }
+            this.updateCipherVisibility();
+        } catch (error) {
+            this.upstreamURLError.textContent = errorMessage(error);
+        }
+    }
+
+    updateCipherVisibility() {
+        const isShadowsocks = this.upstreamProtocol.value === 'shadowsocks';
+        this.upstreamCipherRow.style.display = isShadowsocks ? '' : 'none';
+    }
+
+    async showConnections(proxy) {
+        this.connectionsProxyId = proxy.id;
+        this.connectionsTitle.textContent = `活动连接 - ${proxy.name}`;
+        this.connectionsModal.classList.add('show');
+        try {
+            this.renderConnections(await GetConnections(proxy.id) || []);
+        } catch (error) {
+            console.error('获取连接失败:', error);
+        }
+    }
+
+    hideConnections() {
+        this.connectionsModal.classList.remove('show');
+        this.connectionsProxyId = null;
+    }
+
+    onConnectionsUpdate(connections) {
+        if (!this.connectionsProxyId) return;
+        this.renderConnections(connections.filter(c => c.proxy_id === this.connectionsProxyId));
+    }
+
+    renderConnections(connections) {
+        if (connections.length === 0) {
+            this.connectionsList.innerHTML = '<tr><td colspan="6" class="empty">暂无活动连接</td></tr>';
+            return;
+        }
+        connections.sort((a, b) => new Date(a.started_at) - new Date(b.started_at));
+        this.connectionsList.innerHTML = '';
+        connections.forEach(conn => {
+            const tr = document.createElement('tr');
+            const seconds = Math.max(0, Math.floor((Date.now() - new Date(conn.started_at)) / 1000));
+            tr.innerHTML = `
+                <td>${conn.client}</td>
+                <td title="${conn.upstream}">${conn.target || '-'}</td>
+                <td>${formatBytes(conn.bytes_up)}</td>
+                <td>${formatBytes(conn.bytes_down)}</td>
+                <td>${seconds}s</td>
+                <td><button class="btn btn-danger btn-small">断开</button></td>
+            `;
+            tr.querySelector('button').addEventListener('click', async () => {
+                try {
+                    await CloseConnection(conn.id);
+                    tr.remove();
+                } catch (error) {
+                    console.error('断开连接失败:', error);
+                }
+            });
+            this.connectionsList.appendChild(tr);
+        });
+    }
+
+    async showLogs() {
+        this.logsModal.classList.add('show');
+        this.logsList.innerHTML = '';
+        try {
+            (await TailLogs(200) || []).forEach(entry => this.onLogEntry(entry));
+        } catch (error) {
+            console.error('获取访问日志失败:', error);
+        }
+        if (!this.logsList.children.length) {
+            this.logsList.innerHTML = '<tr><td colspan="7" class="empty">暂无访问记录</td></tr>';
+        }
+    }
+
+    hideLogs() {
+        this.logsModal.classList.remove('show');
+    }
+
+    // onLogEntry 新记录插入到最前面，只保留最近200条
+    onLogEntry(entry) {
+        if (!this.logsModal.classList.contains('show')) return;
+        const empty = this.logsList.querySelector('.empty');
+        if (empty) empty.parentElement.remove();
+
+        const tr = document.createElement('tr');
+        const result = entry.error ? `${entry.outcome} (${entry.error_class || entry.error})` : entry.outcome;
+        [
+            new Date(entry.time).toLocaleTimeString(),
+            entry.proxy_name || entry.proxy_id,
+            entry.client,
+            entry.target || '-',
+            `↑${formatBytes(entry.bytes_up)} ↓${formatBytes(entry.bytes_down)}`,
+            `${(entry.duration_ms / 1000).toFixed(1)}s`,
+            result
+        ].forEach(text => {
+            const td = document.createElement('td');
+            td.textContent = text;
+            tr.appendChild(td);
+        });
+        if (entry.error) tr.title = entry.error;
+        this.logsList.prepend(tr);
+        while (this.logsList.children.length > 200) {
+            this.logsList.lastElementChild.remove();
+        }
+    }
+
+    async showBackups() {
+        this.backupsModal.classList.add('show');
+        this.backupsList.innerHTML = '';
+        let backups = [];
+        try {
+            backups = await ListBackups() || [];
+        } catch (error) {
+            console.error('获取配置备份失败:', error);
+        }
+        if (backups.length === 0) {
+            this.backupsList.innerHTML = '<tr><td colspan="4" class="empty">暂无备份，修改配置后会自动备份修改前的版本</td></tr>';
+            return;
+        }
+        backups.forEach(backup => {
+            const tr = document.createElement('tr');
+            tr.innerHTML = `
+                <td>${new Date(backup.time).toLocaleString()}</td>
+                <td>${backup.proxies < 0 ? '无法解析' : backup.proxies}</td>
+                <td>${formatBytes(backup.size)}</td>
+                <td><button class="btn btn-primary btn-small">恢复</button></td>
+            `;
+            tr.title = backup.name;
+            tr.querySelector('button').addEventListener('click', () => this.restoreBackup(backup));
+            this.backupsList.appendChild(tr);
+        });
+    }
+
+    hideBackups() {
+        this.backupsModal.classList.remove('show');
+    }
+
+    async restoreBackup(backup) {
+        if (!confirm(`确定恢复 ${new Date(backup.time).toLocaleString()} 的配置吗？当前配置会先被备份。`)) return;
+        try {
+            await RestoreBackup(backup.name);
+            this.hideBackups();
+            this.showNotice('配置已恢复');
+            await this.loadProxies();
+        } catch (error) {
+            console.error('恢复配置备份失败:', error);
+            this.showNotice(`恢复配置备份失败: ${errorMessage(error)}`);
+        }
+    }
+
+    // checkLocked 配置文件已加密时显示解锁窗口，解锁前代理列表为空
+    async checkLocked() {
+        try {
+            if (await IsConfigLocked()) {
+                this.unlockModal.classList.add('show');
+                this.unlockPassword.focus();
+            }
+        } catch (error) {
+            console.error('检查配置状态失败:', error);
+        }
+    }
+
+    async unlock(e) {
+        e.preventDefault();
+        this.unlockError.textContent = '';
+        try {
+            await UnlockConfig(this.unlockPassword.value);
+            this.unlockPassword.value = '';
+            this.unlockModal.classList.remove('show');
+            await this.loadProxies();
+        } catch (error) {
+            this.unlockError.textContent = errorMessage(error);
+            this.unlockPassword.select();
+        }
+    }
+
+    async showMasterPassword() {
+        this.masterPasswordForm.reset();
+        this.masterPasswordError.textContent = '';
+        let encrypted = false;
+        try {
+            encrypted = await IsConfigEncrypted();
+        } catch (error) {
+            console.error('获取主密码状态失败:', error);
+        }
+        this.currentMasterPasswordRow.style.display = encrypted ? '' : 'none';
+        this.removeMasterPasswordBtn.style.display = encrypted ? '' : 'none';
+        this.masterPasswordModal.classList.add('show');
+    }
+
+    hideMasterPassword() {
+        this.masterPasswordModal.classList.remove('show');
+        this.masterPasswordForm.reset();
+    }
+
+    async saveMasterPassword(e) {
+        e.preventDefault();
+        const next = document.getElementById('newMasterPassword').value;
+        if (!next) {
+            this.masterPasswordError.textContent = '新主密码不能为空';
+            return;
+        }
+        if (next !== document.getElementById('confirmMasterPassword').value) {
+            this.masterPasswordError.textContent = '两次输入的新主密码不一致';
+            return;
+        }
+        await this.changeMasterPassword(next, '主密码已更新');
+    }
+
+    async removeMasterPassword() {
+        if (!confirm('确定取消主密码吗？配置文件和备份中的上游密码将以明文保存。')) return;
+        await this.changeMasterPassword('', '已取消主密码');
+    }
+
+    async changeMasterPassword(next, notice) {
+        this.masterPasswordError.textContent = '';
+        try {
+            await ChangeMasterPassword(document.getElementById('currentMasterPassword').value, next);
+            this.hideMasterPassword();
+            this.showNotice(notice);
+        } catch (error) {
+            this.masterPasswordError.textContent = errorMessage(error);
+        }
+    }
+
+    onQuotaEvent(status, message) {
+        const proxy = this.proxies.find(p => p.id === status.proxy_id);
+        const name = proxy ? proxy.name : status.proxy_id;
+        this.showNotice(`代理 "${name}" ${message} (${formatBytes(status.used)} / ${formatBytes(status.limit)})`);
+        this.loadProxies();
+    }
+
+    showNotice(message) {
+        const notice = document.createElement('div');
+        notice.className = 'notice';
+        notice.textContent = message;
+        document.body.appendChild(notice);
+        setTimeout(() => notice.remove(), 8000);
+    }
+
     showModal() {
         this.modal.classList.add('show');
     }
 
     hideModal() {
         this.modal.classList.remove('show');
+        this.clearFieldErrors();
         this.currentEditingProxy = null;
     }
 
+    // showFieldErrors 标出校验失败的输入框，没有对应输入框的错误（如头部规则）显示在提示中
+    showFieldErrors(error) {
+        this.clearFieldErrors();
+        const unmatched = [];
+        (error && error.fields || []).forEach(field => {
+            const input = this.proxyForm.querySelector(`[name="${field.field}"]`);
+            if (input) {
+                input.classList.add('field-invalid');
+                input.title = field.message;
+            } else {
+                unmatched.push(field.message);
+            }
+        });
+        const fields = error && error.fields;
+        if (!fields || unmatched.length) {
+            this.showNotice(`保存代理失败: ${fields ? unmatched.join('; ') : errorMessage(error)}`);
+        }
+    }
+
+    clearFieldErrors() {
+        this.proxyForm.querySelectorAll('.field-invalid').forEach(input => {
+            input.classList.remove('field-invalid');
+            input.title = '';
+        });
+    }
+
     async handleFormSubmit(e) {
         e.preventDefault();
+        this.clearFieldErrors();
         try {
             const formData = new FormData(this.proxyForm);
+            const upstreamProtocol = formData.get('upstream.protocol');
+            // 编辑时保留表单中没有的字段，避免保存时被清空
+            const base = this.currentEditingProxy || {};
             const proxy = {
+                ...base,
                 id: this.currentEditingProxy ? this.currentEditingProxy.id : '',
                 name: formData.get('name'),
                 upstream: {
-                    protocol: formData.get('upstream.protocol'),
+                    ...(base.upstream || {}),
+                    protocol: upstreamProtocol,
                     address: formData.get('upstream.address'),
                     username: formData.get('upstream.username') || '',
                     password: formData.get('upstream.password') || '',
-                    auth_method: 'basic'
+                    auth_method: 'basic',
+                    cipher: upstreamProtocol === 'shadowsocks' ? formData.get('upstream.cipher') : ''
                 },
                 local: {
+                    ...(base.local || {}),
                     protocol: formData.get('local.protocol'),
                     listen_ip: formData.get('local.listen_ip'),
                     listen_port: parseInt(formData.get('local.listen_port'))
                 },
-                enabled: document.getElementById('proxyEnabled').checked
+                enabled: document.getElementById('proxyEnabled').checked,
+                bandwidth: this.getBandwidthFields(),
+                limits: this.getLimitFields(),
+                timeouts: this.getTimeoutFields()
             };
             
             if (this.currentEditingProxy) {
@@ -339,12 +798,14 @@ class ProxyManager {
             await this.loadProxies();
         } catch (error) {
             console.error('保存代理失败:', error);
+            this.showFieldErrors(error);
         }
     }
 
     async exportConfig() {
         try {
-            await ExportConfigToFile();
+            const withPasswords = confirm('导出的CSV是否包含上游密码？\n选择"取消"将不导出密码，导入后需要重新填写。');
+            await ExportConfigToFile(withPasswords);
         } catch (error) {
             console.error('导出配置失败:', error);
         }
@@ -360,6 +821,7 @@ class ProxyManager {
             await this.loadProxies();
         } catch (error) {
             console.error('导入配置失败:', error);
+            this.showNotice(`导入配置失败: ${errorMessage(error)}`);
         }
     }
 }
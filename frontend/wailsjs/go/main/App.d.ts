@@ -1,25 +1,74 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {accesslog} from '../models';
 import {main} from '../models';
+import {config} from '../models';
+import {server} from '../models';
 
 export function AddProxy(arg1:main.ProxyConfig):Promise<string>;
 
+export function ChangeMasterPassword(arg1:string,arg2:string):Promise<void>;
+
+export function CloseConnection(arg1:string):Promise<void>;
+
 export function DeleteProxy(arg1:string):Promise<void>;
 
-export function ExportConfig():Promise<string>;
+export function ExportConfig(arg1:boolean):Promise<string>;
 
-export function ExportConfigToFile():Promise<void>;
+export function ExportConfigToFile(arg1:boolean):Promise<void>;
 
 export function GetAllProxies():Promise<Array<main.ProxyWithStatus>>;
 
+export function GetAppSettings():Promise<config.AppSettings>;
+
+export function GetConnections(arg1:string):Promise<Array<server.ConnectionInfo>>;
+
+export function GetHeaderRules(arg1:string):Promise<Array<config.HeaderRule>>;
+
 export function GetProxyStatus(arg1:string):Promise<main.ProxyStatus>;
 
+export function GetQuotaStatus(arg1:string):Promise<server.QuotaStatus>;
+
 export function GetStats():Promise<Record<string, number>>;
 
+export function GetTrafficHistory(arg1:string,arg2:string):Promise<server.TrafficHistory>;
+
+export function GetTrafficStats(arg1:string):Promise<server.TrafficStats>;
+
 export function ImportConfig(arg1:string):Promise<void>;
 
 export function ImportConfigFromFile():Promise<void>;
 
+export function IsConfigEncrypted():Promise<boolean>;
+
+export function IsConfigLocked():Promise<boolean>;
+
+export function ListBackups():Promise<Array<config.Backup>>;
+
+export function ParseUpstreamURL(arg1:string):Promise<main.ProxyConfig>;
+
+export function ResetQuota(arg1:string):Promise<void>;
+
+export function RestoreBackup(arg1:string):Promise<void>;
+
+export function SetAPISettings(arg1:config.APISettings):Promise<config.APISettings>;
+
+export function SetAccessLogSettings(arg1:config.AccessLogSettings):Promise<void>;
+
+export function SetBandwidthLimit(arg1:string,arg2:config.BandwidthLimit):Promise<void>;
+
+export function SetConnectionLimits(arg1:string,arg2:config.ConnectionLimits):Promise<void>;
+
+export function SetDefaultTimeouts(arg1:config.Timeouts):Promise<void>;
+
+export function SetDrainTimeout(arg1:number):Promise<void>;
+
+export function SetHeaderRules(arg1:string,arg2:Array<config.HeaderRule>):Promise<void>;
+
+export function SetMetricsSettings(arg1:config.MetricsSettings):Promise<void>;
+
+export function SetTimeouts(arg1:string,arg2:config.Timeouts):Promise<void>;
+
 export function StartAllProxies():Promise<Array<string>>;
 
 export function StartProxy(arg1:string):Promise<void>;
@@ -28,4 +77,8 @@ export function StopAllProxies():Promise<Array<string>>;
 
 export function StopProxy(arg1:string):Promise<void>;
 
+export function TailLogs(arg1:number):Promise<Array<accesslog.Entry>>;
+
+export function UnlockConfig(arg1:string):Promise<void>;
+
 export function UpdateProxy(arg1:main.ProxyConfig):Promise<void>;
@@ -6,30 +6,62 @@ export function AddProxy(arg1) {
   return window['go']['main']['App']['AddProxy'](arg1);
 }
 
+export function ChangeMasterPassword(arg1, arg2) {
+  return window['go']['main']['App']['ChangeMasterPassword'](arg1, arg2);
+}
+
+export function CloseConnection(arg1) {
+  return window['go']['main']['App']['CloseConnection'](arg1);
+}
+
 export function DeleteProxy(arg1) {
   return window['go']['main']['App']['DeleteProxy'](arg1);
 }
 
-export function ExportConfig() {
-  return window['go']['main']['App']['ExportConfig']();
+export function ExportConfig(arg1) {
+  return window['go']['main']['App']['ExportConfig'](arg1);
 }
 
-export function ExportConfigToFile() {
-  return window['go']['main']['App']['ExportConfigToFile']();
+export function ExportConfigToFile(arg1) {
+  return window['go']['main']['App']['ExportConfigToFile'](arg1);
 }
 
 export function GetAllProxies() {
   return window['go']['main']['App']['GetAllProxies']();
 }
 
+export function GetAppSettings() {
+  return window['go']['main']['App']['GetAppSettings']();
+}
+
+export function GetConnections(arg1) {
+  return window['go']['main']['App']['GetConnections'](arg1);
+}
+
+export function GetHeaderRules(arg1) {
+  return window['go']['main']['App']['GetHeaderRules'](arg1);
+}
+
 export function GetProxyStatus(arg1) {
   return window['go']['main']['App']['GetProxyStatus'](arg1);
 }
 
+export function GetQuotaStatus(arg1) {
+  return window['go']['main']['App']['GetQuotaStatus'](arg1);
+}
+
 export function GetStats() {
   return window['go']['main']['App']['GetStats']();
 }
 
+export function GetTrafficHistory(arg1, arg2) {
+  return window['go']['main']['App']['GetTrafficHistory'](arg1, arg2);
+}
+
+export function GetTrafficStats(arg1) {
+  return window['go']['main']['App']['GetTrafficStats'](arg1);
+}
+
 export function ImportConfig(arg1) {
   return window['go']['main']['App']['ImportConfig'](arg1);
 }
@@ -38,6 +70,66 @@ export function ImportConfigFromFile() {
   return window['go']['main']['App']['ImportConfigFromFile']();
 }
 
+export function IsConfigEncrypted() {
+  return window['go']['main']['App']['IsConfigEncrypted']();
+}
+
+export function IsConfigLocked() {
+  return window['go']['main']['App']['IsConfigLocked']();
+}
+
+export function ListBackups() {
+  return window['go']['main']['App']['ListBackups']();
+}
+
+export function ParseUpstreamURL(arg1) {
+  return window['go']['main']['App']['ParseUpstreamURL'](arg1);
+}
+
+export function ResetQuota(arg1) {
+  return window['go']['main']['App']['ResetQuota'](arg1);
+}
+
+export function RestoreBackup(arg1) {
+  return window['go']['main']['App']['RestoreBackup'](arg1);
+}
+
+export function SetAPISettings(arg1) {
+  return window['go']['main']['App']['SetAPISettings'](arg1);
+}
+
+export function SetAccessLogSettings(arg1) {
+  return window['go']['main']['App']['SetAccessLogSettings'](arg1);
+}
+
+export function SetBandwidthLimit(arg1, arg2) {
+  return window['go']['main']['App']['SetBandwidthLimit'](arg1, arg2);
+}
+
+export function SetConnectionLimits(arg1, arg2) {
+  return window['go']['main']['App']['SetConnectionLimits'](arg1, arg2);
+}
+
+export function SetDefaultTimeouts(arg1) {
+  return window['go']['main']['App']['SetDefaultTimeouts'](arg1);
+}
+
+export function SetDrainTimeout(arg1) {
+  return window['go']['main']['App']['SetDrainTimeout'](arg1);
+}
+
+export function SetHeaderRules(arg1, arg2) {
+  return window['go']['main']['App']['SetHeaderRules'](arg1, arg2);
+}
+
+export function SetMetricsSettings(arg1) {
+  return window['go']['main']['App']['SetMetricsSettings'](arg1);
+}
+
+export function SetTimeouts(arg1, arg2) {
+  return window['go']['main']['App']['SetTimeouts'](arg1, arg2);
+}
+
 export function StartAllProxies() {
   return window['go']['main']['App']['StartAllProxies']();
 }
@@ -54,6 +146,14 @@ export function StopProxy(arg1) {
   return window['go']['main']['App']['StopProxy'](arg1);
 }
 
+export function TailLogs(arg1) {
+  return window['go']['main']['App']['TailLogs'](arg1);
+}
+
+export function UnlockConfig(arg1) {
+  return window['go']['main']['App']['UnlockConfig'](arg1);
+}
+
 export function UpdateProxy(arg1) {
   return window['go']['main']['App']['UpdateProxy'](arg1);
 }
@@ -1,9 +1,324 @@
+export namespace accesslog {
+	
+	export class Entry {
+	    // Go type: time
+	    time: any;
+	    level: string;
+	    proxy_id: string;
+	    proxy_name: string;
+	    client: string;
+	    target?: string;
+	    upstream: string;
+	    bytes_up: number;
+	    bytes_down: number;
+	    duration_ms: number;
+	    outcome: string;
+	    error_class?: string;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Entry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.level = source["level"];
+	        this.proxy_id = source["proxy_id"];
+	        this.proxy_name = source["proxy_name"];
+	        this.client = source["client"];
+	        this.target = source["target"];
+	        this.upstream = source["upstream"];
+	        this.bytes_up = source["bytes_up"];
+	        this.bytes_down = source["bytes_down"];
+	        this.duration_ms = source["duration_ms"];
+	        this.outcome = source["outcome"];
+	        this.error_class = source["error_class"];
+	        this.error = source["error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace config {
+	
+	export class APISettings {
+	    enabled: boolean;
+	    listen_addr: string;
+	    token: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new APISettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.listen_addr = source["listen_addr"];
+	        this.token = source["token"];
+	    }
+	}
+	export class AccessLogSettings {
+	    level: string;
+	    max_size_mb: number;
+	    max_age_days: number;
+	    max_backups: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AccessLogSettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.level = source["level"];
+	        this.max_size_mb = source["max_size_mb"];
+	        this.max_age_days = source["max_age_days"];
+	        this.max_backups = source["max_backups"];
+	    }
+	}
+	export class AppSettings {
+	    minimize_to_tray: boolean;
+	    show_tray_icon: boolean;
+	    first_close_asked: boolean;
+	    drain_timeout_seconds: number;
+	    default_timeouts: Timeouts;
+	    access_log: AccessLogSettings;
+	    metrics: MetricsSettings;
+	    api: APISettings;
+	
+	    static createFrom(source: any = {}) {
+	        return new AppSettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.minimize_to_tray = source["minimize_to_tray"];
+	        this.show_tray_icon = source["show_tray_icon"];
+	        this.first_close_asked = source["first_close_asked"];
+	        this.drain_timeout_seconds = source["drain_timeout_seconds"];
+	        this.default_timeouts = this.convertValues(source["default_timeouts"], Timeouts);
+	        this.access_log = this.convertValues(source["access_log"], AccessLogSettings);
+	        this.metrics = this.convertValues(source["metrics"], MetricsSettings);
+	        this.api = this.convertValues(source["api"], APISettings);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Backup {
+	    name: string;
+	    // Go type: time
+	    time: any;
+	    size: number;
+	    proxies: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Backup(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.time = this.convertValues(source["time"], null);
+	        this.size = source["size"];
+	        this.proxies = source["proxies"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class BandwidthLimit {
+	    upload_bps?: number;
+	    download_bps?: number;
+	    conn_upload_bps?: number;
+	    conn_download_bps?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new BandwidthLimit(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.upload_bps = source["upload_bps"];
+	        this.download_bps = source["download_bps"];
+	        this.conn_upload_bps = source["conn_upload_bps"];
+	        this.conn_download_bps = source["conn_download_bps"];
+	    }
+	}
+	export class ConnectionLimits {
+	    max_connections?: number;
+	    max_connections_per_ip?: number;
+	    allow?: string[];
+	    deny?: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectionLimits(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.max_connections = source["max_connections"];
+	        this.max_connections_per_ip = source["max_connections_per_ip"];
+	        this.allow = source["allow"];
+	        this.deny = source["deny"];
+	    }
+	}
+	export class HeaderRule {
+	    host: string;
+	    direction: string;
+	    action: string;
+	    name: string;
+	    value?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new HeaderRule(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.host = source["host"];
+	        this.direction = source["direction"];
+	        this.action = source["action"];
+	        this.name = source["name"];
+	        this.value = source["value"];
+	    }
+	}
+	export class MetricsSettings {
+	    enabled: boolean;
+	    listen_addr: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MetricsSettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.listen_addr = source["listen_addr"];
+	    }
+	}
+	export class Timeouts {
+	    dial_seconds?: number;
+	    handshake_seconds?: number;
+	    idle_seconds?: number;
+	    keepalive_seconds?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Timeouts(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.dial_seconds = source["dial_seconds"];
+	        this.handshake_seconds = source["handshake_seconds"];
+	        this.idle_seconds = source["idle_seconds"];
+	        this.keepalive_seconds = source["keepalive_seconds"];
+	    }
+	}
+	export class TrafficQuota {
+	    limit: number;
+	    period: string;
+	    reset_day?: number;
+	    action: string;
+	    warn_at?: number[];
+	
+	    static createFrom(source: any = {}) {
+	        return new TrafficQuota(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.limit = source["limit"];
+	        this.period = source["period"];
+	        this.reset_day = source["reset_day"];
+	        this.action = source["action"];
+	        this.warn_at = source["warn_at"];
+	    }
+	}
+	export class UpstreamTransport {
+	    type: string;
+	    tls?: boolean;
+	    server_name?: string;
+	    skip_verify?: boolean;
+	    host?: string;
+	    path?: string;
+	    headers?: {[key: string]: string};
+	    h2_protocol?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new UpstreamTransport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.tls = source["tls"];
+	        this.server_name = source["server_name"];
+	        this.skip_verify = source["skip_verify"];
+	        this.host = source["host"];
+	        this.path = source["path"];
+	        this.headers = source["headers"];
+	        this.h2_protocol = source["h2_protocol"];
+	    }
+	}
+
+}
+
 export namespace main {
 	
 	export class LocalProxy {
 	    protocol: string;
 	    listen_ip: string;
 	    listen_port: number;
+	    via?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new LocalProxy(source);
@@ -14,6 +329,7 @@ export namespace main {
 	        this.protocol = source["protocol"];
 	        this.listen_ip = source["listen_ip"];
 	        this.listen_port = source["listen_port"];
+	        this.via = source["via"];
 	    }
 	}
 	export class UpstreamProxy {
@@ -22,6 +338,8 @@ export namespace main {
 	    username: string;
 	    password: string;
 	    auth_method?: string;
+	    cipher?: string;
+	    transport?: config.UpstreamTransport;
 	
 	    static createFrom(source: any = {}) {
 	        return new UpstreamProxy(source);
@@ -34,7 +352,27 @@ export namespace main {
 	        this.username = source["username"];
 	        this.password = source["password"];
 	        this.auth_method = source["auth_method"];
+	        this.cipher = source["cipher"];
+	        this.transport = this.convertValues(source["transport"], config.UpstreamTransport);
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 	export class ProxyConfig {
 	    id: string;
@@ -43,6 +381,11 @@ export namespace main {
 	    local: LocalProxy;
 	    enabled: boolean;
 	    description?: string;
+	    header_rules?: config.HeaderRule[];
+	    quota?: config.TrafficQuota;
+	    bandwidth?: config.BandwidthLimit;
+	    limits?: config.ConnectionLimits;
+	    timeouts?: config.Timeouts;
 	
 	    static createFrom(source: any = {}) {
 	        return new ProxyConfig(source);
@@ -56,6 +399,11 @@ export namespace main {
 	        this.local = this.convertValues(source["local"], LocalProxy);
 	        this.enabled = source["enabled"];
 	        this.description = source["description"];
+	        this.header_rules = this.convertValues(source["header_rules"], config.HeaderRule);
+	        this.quota = this.convertValues(source["quota"], config.TrafficQuota);
+	        this.bandwidth = this.convertValues(source["bandwidth"], config.BandwidthLimit);
+	        this.limits = this.convertValues(source["limits"], config.ConnectionLimits);
+	        this.timeouts = this.convertValues(source["timeouts"], config.Timeouts);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -79,7 +427,12 @@ export namespace main {
 	export class ProxyStatus {
 	    id: string;
 	    running: boolean;
+	    state: string;
 	    error?: string;
+	    // Go type: time
+	    started_at: any;
+	    // Go type: time
+	    stopped_at: any;
 	
 	    static createFrom(source: any = {}) {
 	        return new ProxyStatus(source);
@@ -89,8 +442,29 @@ export namespace main {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.id = source["id"];
 	        this.running = source["running"];
+	        this.state = source["state"];
 	        this.error = source["error"];
+	        this.started_at = this.convertValues(source["started_at"], null);
+	        this.stopped_at = this.convertValues(source["stopped_at"], null);
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 	export class ProxyWithStatus {
 	    id: string;
@@ -99,7 +473,14 @@ export namespace main {
 	    local: LocalProxy;
 	    enabled: boolean;
 	    description?: string;
+	    header_rules?: config.HeaderRule[];
+	    quota?: config.TrafficQuota;
+	    bandwidth?: config.BandwidthLimit;
+	    limits?: config.ConnectionLimits;
+	    timeouts?: config.Timeouts;
 	    running: boolean;
+	    state: string;
+	    error?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new ProxyWithStatus(source);
@@ -113,7 +494,128 @@ export namespace main {
 	        this.local = this.convertValues(source["local"], LocalProxy);
 	        this.enabled = source["enabled"];
 	        this.description = source["description"];
+	        this.header_rules = this.convertValues(source["header_rules"], config.HeaderRule);
+	        this.quota = this.convertValues(source["quota"], config.TrafficQuota);
+	        this.bandwidth = this.convertValues(source["bandwidth"], config.BandwidthLimit);
+	        this.limits = this.convertValues(source["limits"], config.ConnectionLimits);
+	        this.timeouts = this.convertValues(source["timeouts"], config.Timeouts);
 	        this.running = source["running"];
+	        this.state = source["state"];
+	        this.error = source["error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace server {
+	
+	export class ConnectionInfo {
+	    id: string;
+	    proxy_id: string;
+	    client: string;
+	    target: string;
+	    upstream: string;
+	    // Go type: time
+	    started_at: any;
+	    bytes_up: number;
+	    bytes_down: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectionInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.proxy_id = source["proxy_id"];
+	        this.client = source["client"];
+	        this.target = source["target"];
+	        this.upstream = source["upstream"];
+	        this.started_at = this.convertValues(source["started_at"], null);
+	        this.bytes_up = source["bytes_up"];
+	        this.bytes_down = source["bytes_down"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MonthlyUsage {
+	    month: string;
+	    bytes_up: number;
+	    bytes_down: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MonthlyUsage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.month = source["month"];
+	        this.bytes_up = source["bytes_up"];
+	        this.bytes_down = source["bytes_down"];
+	    }
+	}
+	export class QuotaStatus {
+	    proxy_id: string;
+	    limit: number;
+	    used: number;
+	    period: string;
+	    action: string;
+	    // Go type: time
+	    period_start: any;
+	    // Go type: time
+	    reset_at: any;
+	    exceeded: boolean;
+	    threshold?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new QuotaStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.proxy_id = source["proxy_id"];
+	        this.limit = source["limit"];
+	        this.used = source["used"];
+	        this.period = source["period"];
+	        this.action = source["action"];
+	        this.period_start = this.convertValues(source["period_start"], null);
+	        this.reset_at = this.convertValues(source["reset_at"], null);
+	        this.exceeded = source["exceeded"];
+	        this.threshold = source["threshold"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -134,6 +636,105 @@ export namespace main {
 		    return a;
 		}
 	}
+	export class TrafficPoint {
+	    // Go type: time
+	    time: any;
+	    bytes_up: number;
+	    bytes_down: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TrafficPoint(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.bytes_up = source["bytes_up"];
+	        this.bytes_down = source["bytes_down"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TrafficHistory {
+	    proxy_id: string;
+	    range: string;
+	    step_seconds: number;
+	    points: TrafficPoint[];
+	    bytes_up: number;
+	    bytes_down: number;
+	    months: MonthlyUsage[];
+	
+	    static createFrom(source: any = {}) {
+	        return new TrafficHistory(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.proxy_id = source["proxy_id"];
+	        this.range = source["range"];
+	        this.step_seconds = source["step_seconds"];
+	        this.points = this.convertValues(source["points"], TrafficPoint);
+	        this.bytes_up = source["bytes_up"];
+	        this.bytes_down = source["bytes_down"];
+	        this.months = this.convertValues(source["months"], MonthlyUsage);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TrafficStats {
+	    bytes_up: number;
+	    bytes_down: number;
+	    connections_total: number;
+	    connections_active: number;
+	    connections_failed: number;
+	    connections_rejected: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TrafficStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.bytes_up = source["bytes_up"];
+	        this.bytes_down = source["bytes_down"];
+	        this.connections_total = source["connections_total"];
+	        this.connections_active = source["connections_active"];
+	        this.connections_failed = source["connections_failed"];
+	        this.connections_rejected = source["connections_rejected"];
+	    }
+	}
 
 }
 
@@ -0,0 +1,338 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// 连接结束的结果
+const (
+	OutcomeOK            = "ok"             // 正常结束
+	OutcomeClientError   = "client_error"   // 客户端握手失败
+	OutcomeUpstreamError = "upstream_error" // 无法通过上游连接到目标
+	OutcomeRelayError    = "relay_error"    // 转发过程中出错
+	OutcomeIdleTimeout   = "idle_timeout"   // 空闲超时被关闭
+	OutcomeClosed        = "closed"         // 被手动断开或停止代理时强制关闭
+	OutcomeRejected      = "rejected"       // 被连接数限制或访问控制拒绝
+	OutcomeBlocked       = "blocked"        // 达到流量配额被拒绝
+)
+
+// tailCapacity 内存中保留的最近记录数
+const tailCapacity = 1000
+
+// reopenInterval 日志文件打开失败后，至少间隔这么久再重试
+const reopenInterval = 5 * time.Second
+
+// Entry 访问日志中的一条记录，每个隧道或HTTP请求结束时写入一条
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Level      string    `json:"level"`
+	ProxyID    string    `json:"proxy_id"`
+	ProxyName  string    `json:"proxy_name"`
+	Client     string    `json:"client"`
+	Target     string    `json:"target,omitempty"`
+	Upstream   string    `json:"upstream"`
+	BytesUp    int64     `json:"bytes_up"`
+	BytesDown  int64     `json:"bytes_down"`
+	DurationMs int64     `json:"duration_ms"`
+	Outcome    string    `json:"outcome"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// levels 日志级别，记录的级别不低于设置的级别时才写入
+var levels = map[string]int{
+	"info":  0,
+	"warn":  1,
+	"error": 2,
+	"off":   3,
+}
+
+// outcomeLevel 按结果确定记录的级别
+func outcomeLevel(outcome string) string {
+	switch outcome {
+	case OutcomeRejected, OutcomeBlocked:
+		return "warn"
+	case OutcomeClientError, OutcomeUpstreamError, OutcomeRelayError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ClassifyError 把错误归类为便于统计的类别
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.Is(err, net.ErrClosed):
+		return "closed"
+	default:
+		return "other"
+	}
+}
+
+// Logger 把访问记录以JSON行写入文件，按大小和日期轮转，并在内存中保留最近的记录
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	settings config.AccessLogSettings
+	file     *os.File // 打开或轮转失败时为nil，之后的写入会重试打开
+	retryAt  time.Time
+	closed   bool
+	size     int64
+	day      string // 当前文件开始写入的日期
+	recent   []Entry
+	observer func(Entry)
+}
+
+// Open 打开或创建日志文件，并读取其中最近的记录
+func Open(path string, settings config.AccessLogSettings) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("无法创建日志目录: %w", err)
+	}
+
+	l := &Logger{path: path, settings: settings}
+	l.loadRecent()
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("无法打开访问日志: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("无法打开访问日志: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	l.day = info.ModTime().Format("2006-01-02")
+	return nil
+}
+
+func (l *Logger) loadRecent() {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			l.appendRecent(e)
+		}
+	}
+}
+
+// appendRecent 记录累积到两倍容量时才丢弃旧记录，避免每次写入都移动切片
+func (l *Logger) appendRecent(e Entry) {
+	if len(l.recent) >= 2*tailCapacity {
+		l.recent = append(l.recent[:0], l.recent[len(l.recent)-tailCapacity:]...)
+	}
+	l.recent = append(l.recent, e)
+}
+
+// SetObserver 设置每写入一条记录后的回调
+func (l *Logger) SetObserver(observer func(Entry)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.observer = observer
+}
+
+// SetSettings 更新日志级别和轮转设置，之后的写入立即生效
+func (l *Logger) SetSettings(settings config.AccessLogSettings) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings = settings
+}
+
+// Log 写入一条记录，低于设置级别的记录被丢弃。l为nil时不做任何事
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Level == "" {
+		e.Level = outcomeLevel(e.Outcome)
+	}
+
+	l.mu.Lock()
+	if levels[e.Level] < levels[l.settings.Level] || l.closed {
+		l.mu.Unlock()
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		l.mu.Unlock()
+		return
+	}
+	line = append(line, '\n')
+	l.reopenIfNeeded()
+	if l.file != nil {
+		if err := l.rotateIfNeeded(e.Time, len(line)); err != nil {
+			fmt.Printf("轮转访问日志失败: %v\n", err)
+		}
+	}
+	if l.file != nil {
+		n, err := l.file.Write(line)
+		l.size += int64(n)
+		if err != nil {
+			fmt.Printf("写入访问日志失败: %v\n", err)
+		}
+	}
+	l.appendRecent(e)
+	observer := l.observer
+	l.mu.Unlock()
+
+	if observer != nil {
+		observer(e)
+	}
+}
+
+// reopenIfNeeded 打开或轮转失败后重新打开日志文件，再次失败时间隔 reopenInterval 后重试，
+// 期间的记录仍保留在内存中
+func (l *Logger) reopenIfNeeded() {
+	if l.file != nil || time.Now().Before(l.retryAt) {
+		return
+	}
+	if err := l.openFile(); err != nil {
+		l.retryAt = time.Now().Add(reopenInterval)
+		fmt.Printf("%v，%s 后重试\n", err, reopenInterval)
+	}
+}
+
+// rotateIfNeeded 文件超过大小限制或跨天时把当前文件改名归档，再打开新文件
+func (l *Logger) rotateIfNeeded(now time.Time, next int) error {
+	day := now.Format("2006-01-02")
+	maxSize := int64(l.settings.MaxSizeMB) * 1024 * 1024
+	tooBig := maxSize > 0 && l.size > 0 && l.size+int64(next) > maxSize
+	if l.size == 0 || (!tooBig && day == l.day) {
+		l.day = day
+		return nil
+	}
+
+	l.file.Close()
+	l.file = nil
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext)
+	archived := fmt.Sprintf("%s-%s%s", base, now.Format("20060102-150405"), ext)
+	for i := 1; fileExists(archived); i++ {
+		archived = fmt.Sprintf("%s-%s.%d%s", base, now.Format("20060102-150405"), i, ext)
+	}
+	renameErr := os.Rename(l.path, archived)
+	if err := l.openFile(); err != nil {
+		return err
+	}
+	l.day = day
+	if renameErr != nil {
+		return fmt.Errorf("无法归档访问日志: %w", renameErr)
+	}
+	l.removeOldArchives(now)
+	return nil
+}
+
+// removeOldArchives 删除超过保留天数或超出保留个数的归档文件
+func (l *Logger) removeOldArchives(now time.Time) {
+	ext := filepath.Ext(l.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(l.path, ext) + "-*" + ext)
+	if err != nil {
+		return
+	}
+	type archive struct {
+		path    string
+		modTime time.Time
+	}
+	var archives []archive
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil {
+			archives = append(archives, archive{path, info.ModTime()})
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].modTime.After(archives[j].modTime) })
+
+	for i, a := range archives {
+		expired := l.settings.MaxBackups > 0 && i >= l.settings.MaxBackups
+		if l.settings.MaxAgeDays > 0 && now.Sub(a.modTime) > time.Duration(l.settings.MaxAgeDays)*24*time.Hour {
+			expired = true
+		}
+		if expired {
+			os.Remove(a.path)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Tail 返回最近的n条记录，按时间先后排列
+func (l *Logger) Tail(n int) []Entry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n <= 0 || n > tailCapacity {
+		n = tailCapacity
+	}
+	if n > len(l.recent) {
+		n = len(l.recent)
+	}
+	return append([]Entry(nil), l.recent[len(l.recent)-n:]...)
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPI 根据接口列表和请求、响应类型生成OpenAPI 3文档
+func OpenAPI() ([]byte, error) {
+	g := &schemaGenerator{components: map[string]any{}}
+	paths := map[string]map[string]any{}
+
+	for _, rt := range routes {
+		op := map[string]any{
+			"summary":     rt.summary,
+			"operationId": operationID(rt),
+		}
+		if strings.Contains(rt.path, "{id}") {
+			op["parameters"] = []any{map[string]any{
+				"name": "id", "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			}}
+		}
+		if rt.request != nil {
+			op["requestBody"] = map[string]any{"required": true, "content": g.content(rt.request)}
+		}
+
+		success := map[string]any{"description": http.StatusText(rt.status)}
+		if rt.response != nil {
+			success["content"] = g.content(rt.response)
+		}
+		errorContent := g.content(ErrorResponse{})
+		responses := map[string]any{
+			strconv.Itoa(rt.status): success,
+			"401":                   map[string]any{"description": "缺少或无效的访问令牌", "content": errorContent},
+			"default":               map[string]any{"description": "请求失败", "content": errorContent},
+		}
+		if strings.Contains(rt.path, "{id}") {
+			responses["404"] = map[string]any{"description": "代理不存在", "content": errorContent}
+		}
+		op["responses"] = responses
+
+		if paths[rt.path] == nil {
+			paths[rt.path] = map[string]any{}
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "代理管理器管理API",
+			"version":     "1.0.0",
+			"description": "请求需要在Authorization头中携带 Bearer <token>，令牌在应用设置中生成",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []string{}}},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// operationID 由方法和路径生成，如 POST /proxies/{id}/start -> postProxiesIdStart
+func operationID(rt route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(rt.method))
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(rt.path, basePath), func(r rune) bool {
+		return r == '/' || r == '-' || r == '{' || r == '}'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// schemaGenerator 把Go类型按json标签转换为JSON Schema，具名结构体放到components中引用
+type schemaGenerator struct {
+	components map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) content(v any) map[string]any {
+	if _, ok := v.(csvText); ok {
+		return map[string]any{"text/csv": map[string]any{"schema": map[string]any{"type": "string"}}}
+	}
+	return map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(v))}}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := t.Name()
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // 先占位，避免递归类型无限展开
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
+
+// object 生成结构体的属性，嵌入的结构体字段展开到当前层级，与encoding/json一致。
+// 同一类型既用于请求也用于响应（如添加代理时ID可以为空），所以不标记必填字段
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	g.addFields(t, properties)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addFields(embedded, properties)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+}
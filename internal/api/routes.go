@@ -0,0 +1,340 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+	"proxy-manager-desktop/internal/server"
+)
+
+const (
+	basePath    = "/api/v1"
+	openAPIPath = basePath + "/openapi.json"
+)
+
+// maxBodySize 请求体的最大长度，导入的CSV也不会超过这个大小
+const maxBodySize = 4 << 20
+
+// route 一个API接口，同时用于注册处理器和生成OpenAPI文档
+type route struct {
+	method   string
+	path     string
+	summary  string
+	request  any // 请求体类型的零值，nil表示没有请求体
+	response any // 成功时响应体类型的零值，nil表示没有响应体
+	status   int
+	handle   func(s *Server, r *http.Request) (any, error)
+}
+
+var routes = []route{
+	{http.MethodGet, basePath + "/proxies", "列出所有代理及其运行状态", nil, []Proxy{}, http.StatusOK, (*Server).listProxies},
+	{http.MethodPost, basePath + "/proxies", "添加代理，启用的代理会立即启动", config.ProxyConfig{}, CreatedResponse{}, http.StatusCreated, (*Server).addProxy},
+	{http.MethodPost, basePath + "/proxies/start-all", "启动所有已启用的代理", nil, ErrorsResponse{}, http.StatusOK, (*Server).startAll},
+	{http.MethodPost, basePath + "/proxies/stop-all", "停止所有运行中的代理", nil, ErrorsResponse{}, http.StatusOK, (*Server).stopAll},
+	{http.MethodGet, basePath + "/proxies/{id}", "获取代理配置及其运行状态", nil, Proxy{}, http.StatusOK, (*Server).getProxy},
+	{http.MethodPut, basePath + "/proxies/{id}", "更新代理配置，头部规则、带宽、连接限制和超时对运行中的代理立即生效", config.ProxyConfig{}, nil, http.StatusNoContent, (*Server).updateProxy},
+	{http.MethodDelete, basePath + "/proxies/{id}", "停止并删除代理", nil, nil, http.StatusNoContent, (*Server).deleteProxy},
+	{http.MethodPost, basePath + "/proxies/{id}/start", "启动代理", nil, nil, http.StatusNoContent, (*Server).startProxy},
+	{http.MethodPost, basePath + "/proxies/{id}/stop", "停止代理", nil, nil, http.StatusNoContent, (*Server).stopProxy},
+	{http.MethodGet, basePath + "/proxies/{id}/status", "获取代理运行状态", nil, Status{}, http.StatusOK, (*Server).proxyStatus},
+	{http.MethodGet, basePath + "/proxies/{id}/stats", "获取代理的累计流量和连接数", nil, server.TrafficStats{}, http.StatusOK, (*Server).proxyStats},
+	{http.MethodGet, basePath + "/stats", "获取代理数量统计", nil, Stats{}, http.StatusOK, (*Server).stats},
+	{http.MethodGet, basePath + "/export", "导出配置为CSV，默认不包含上游密码，带 ?passwords=true 时包含", nil, csvText(""), http.StatusOK, (*Server).exportCSV},
+	{http.MethodPost, basePath + "/import", "从CSV导入代理", csvText(""), ImportResponse{}, http.StatusOK, (*Server).importCSV},
+}
+
+// Proxy 代理配置及其运行状态
+type Proxy struct {
+	*config.ProxyConfig
+	Running bool   `json:"running"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"` // 运行失败的原因
+}
+
+// Status 代理的运行状态
+type Status struct {
+	ID        string    `json:"id"`
+	Running   bool      `json:"running"`
+	State     string    `json:"state"` // stopped, starting, running, stopping, failed
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	StoppedAt time.Time `json:"stopped_at"`
+}
+
+// Stats 代理数量统计
+type Stats struct {
+	Total   int `json:"total"`
+	Running int `json:"running"`
+	Stopped int `json:"stopped"`
+	Enabled int `json:"enabled"`
+}
+
+// CreatedResponse 添加代理后返回新代理的ID
+type CreatedResponse struct {
+	ID string `json:"id"`
+}
+
+// ErrorsResponse 批量操作中各个代理的错误，全部成功时为空
+type ErrorsResponse struct {
+	Errors []string `json:"errors"`
+}
+
+// ImportResponse 导入成功的代理数
+type ImportResponse struct {
+	Imported int `json:"imported"`
+}
+
+// csvText 以text/csv返回或读取的内容
+type csvText string
+
+func (s *Server) withStatus(proxy *config.ProxyConfig) Proxy {
+	status := s.proxyManager.GetStatus(proxy.ID)
+	return Proxy{
+		ProxyConfig: proxy,
+		Running:     status.State == server.StateRunning,
+		State:       status.State.String(),
+		Error:       status.LastError,
+	}
+}
+
+// lookup 按路径中的ID获取代理配置，不存在时返回404
+func (s *Server) lookup(r *http.Request) (*config.ProxyConfig, error) {
+	proxy, err := s.configManager.GetProxy(r.PathValue("id"))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return proxy, nil
+}
+
+func decodeProxy(r *http.Request) (*config.ProxyConfig, error) {
+	var proxy config.ProxyConfig
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&proxy); err != nil {
+		return nil, badRequest(fmt.Errorf("无法解析请求: %w", err))
+	}
+	return &proxy, nil
+}
+
+// validateProxy 校验代理配置，错误中带有字段信息
+func (s *Server) validateProxy(proxy *config.ProxyConfig, checkPort bool) error {
+	if err := s.configManager.ValidateProxy(proxy, checkPort); err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+func (s *Server) listProxies(r *http.Request) (any, error) {
+	result := []Proxy{}
+	for _, proxy := range s.configManager.GetAllProxies() {
+		result = append(result, s.withStatus(proxy))
+	}
+	return result, nil
+}
+
+func (s *Server) getProxy(r *http.Request) (any, error) {
+	proxy, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.withStatus(proxy), nil
+}
+
+func (s *Server) addProxy(r *http.Request) (any, error) {
+	proxy, err := decodeProxy(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateProxy(proxy, proxy.Enabled); err != nil {
+		return nil, err
+	}
+	proxy.AutoStart = false // 新添加的代理默认不自动启动
+
+	id, err := s.configManager.AddProxy(proxy)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	if err := s.configManager.SaveConfig(); err != nil {
+		return nil, fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	// 启动失败不影响添加结果，失败原因可以通过状态查询
+	if proxy.Enabled {
+		if err := s.proxyManager.StartProxy(id); err != nil {
+			fmt.Printf("启动新添加的代理失败: %v\n", err)
+		} else {
+			s.configManager.UpdateProxyAutoStart(id, true)
+			s.configManager.SaveConfig()
+		}
+	}
+	return CreatedResponse{ID: id}, nil
+}
+
+func (s *Server) updateProxy(r *http.Request) (any, error) {
+	current, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := decodeProxy(r)
+	if err != nil {
+		return nil, err
+	}
+	proxy.ID = current.ID
+	proxy.AutoStart = current.AutoStart // 保留原有的AutoStart状态
+	listenChanged := proxy.Local.ListenIP != current.Local.ListenIP || proxy.Local.ListenPort != current.Local.ListenPort
+	if err := s.validateProxy(proxy, listenChanged); err != nil {
+		return nil, err
+	}
+
+	if err := s.configManager.UpdateProxy(proxy); err != nil {
+		return nil, notFound(err)
+	}
+	if err := s.configManager.SaveConfig(); err != nil {
+		return nil, fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	s.proxyManager.UpdateHeaderRules(proxy.ID, proxy.HeaderRules)
+	s.proxyManager.UpdateBandwidthLimit(proxy.ID, proxy.Bandwidth)
+	s.proxyManager.UpdateConnectionLimits(proxy.ID, proxy.Limits)
+	s.proxyManager.UpdateTimeouts(proxy.ID, proxy.Timeouts)
+	return nil, nil
+}
+
+func (s *Server) deleteProxy(r *http.Request) (any, error) {
+	proxy, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	s.proxyManager.StopProxy(proxy.ID)
+
+	if err := s.configManager.DeleteProxy(proxy.ID); err != nil {
+		return nil, notFound(err)
+	}
+	return nil, s.configManager.SaveConfig()
+}
+
+func (s *Server) startProxy(r *http.Request) (any, error) {
+	proxy, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.proxyManager.StartProxy(proxy.ID); err != nil {
+		var inUse *server.AddrInUseError
+		if errors.As(err, &inUse) {
+			return nil, conflict(err)
+		}
+		return nil, err
+	}
+	s.configManager.UpdateProxyAutoStart(proxy.ID, true)
+	return nil, s.configManager.SaveConfig()
+}
+
+func (s *Server) stopProxy(r *http.Request) (any, error) {
+	proxy, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.proxyManager.StopProxy(proxy.ID); err != nil {
+		return nil, badRequest(err)
+	}
+	s.configManager.UpdateProxyAutoStart(proxy.ID, false)
+	return nil, s.configManager.SaveConfig()
+}
+
+func (s *Server) startAll(r *http.Request) (any, error) {
+	result := ErrorsResponse{Errors: []string{}}
+	for _, proxy := range s.configManager.GetAllProxies() {
+		if !proxy.Enabled || s.proxyManager.IsProxyRunning(proxy.ID) {
+			continue
+		}
+		if err := s.proxyManager.StartProxy(proxy.ID); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		} else {
+			s.configManager.UpdateProxyAutoStart(proxy.ID, true)
+		}
+	}
+	return result, s.configManager.SaveConfig()
+}
+
+func (s *Server) stopAll(r *http.Request) (any, error) {
+	result := ErrorsResponse{Errors: []string{}}
+	for _, id := range s.proxyManager.GetRunningProxies() {
+		if err := s.proxyManager.StopProxy(id); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		} else {
+			s.configManager.UpdateProxyAutoStart(id, false)
+		}
+	}
+	return result, s.configManager.SaveConfig()
+}
+
+func (s *Server) proxyStatus(r *http.Request) (any, error) {
+	proxy, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	status := s.proxyManager.GetStatus(proxy.ID)
+	return Status{
+		ID:        proxy.ID,
+		Running:   status.State == server.StateRunning,
+		State:     status.State.String(),
+		Error:     status.LastError,
+		StartedAt: status.StartedAt,
+		StoppedAt: status.StoppedAt,
+	}, nil
+}
+
+func (s *Server) proxyStats(r *http.Request) (any, error) {
+	proxy, err := s.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.proxyManager.GetTrafficStats(proxy.ID), nil
+}
+
+func (s *Server) stats(r *http.Request) (any, error) {
+	var stats Stats
+	for _, proxy := range s.configManager.GetAllProxies() {
+		stats.Total++
+		if proxy.Enabled {
+			stats.Enabled++
+		}
+		if s.proxyManager.IsProxyRunning(proxy.ID) {
+			stats.Running++
+		}
+	}
+	stats.Stopped = stats.Total - stats.Running
+	return stats, nil
+}
+
+func (s *Server) exportCSV(r *http.Request) (any, error) {
+	withPasswords := false
+	if value := r.URL.Query().Get("passwords"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, badRequest(fmt.Errorf("无效的 passwords 参数: %s", value))
+		}
+		withPasswords = parsed
+	}
+	data, err := config.ExportCSV(s.configManager.GetAllProxies(), withPasswords)
+	if err != nil {
+		return nil, err
+	}
+	return csvText(data), nil
+}
+
+func (s *Server) importCSV(r *http.Request) (any, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return nil, badRequest(fmt.Errorf("读取请求失败: %w", err))
+	}
+	imported, err := s.configManager.ImportCSV(string(data))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return ImportResponse{Imported: imported}, s.configManager.SaveConfig()
+}
@@ -0,0 +1,196 @@
+// Package api 提供本地HTTP/JSON管理接口，功能与桌面应用绑定给前端的方法一致，
+// 桌面模式和无界面模式都可以启用。
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+	"proxy-manager-desktop/internal/server"
+)
+
+// Server 管理API服务器
+type Server struct {
+	configManager *config.ConfigManager
+	proxyManager  *server.ProxyManager
+
+	mu       sync.Mutex
+	token    string
+	server   *http.Server
+	listener net.Listener
+	onChange func()
+}
+
+// NewServer 创建管理API服务器，调用Start后才开始监听
+func NewServer(configManager *config.ConfigManager, proxyManager *server.ProxyManager) *Server {
+	return &Server{configManager: configManager, proxyManager: proxyManager}
+}
+
+// SetChangeObserver 设置通过API修改代理配置或运行状态后的回调，桌面应用用来刷新界面
+func (s *Server) SetChangeObserver(onChange func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = onChange
+}
+
+// Start 在addr上开始监听，请求必须携带token。已在运行时先停止旧的监听器
+func (s *Server) Start(addr, token string) error {
+	if token == "" {
+		return fmt.Errorf("API访问令牌不能为空")
+	}
+	s.Stop()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("无法创建API监听器: %w", err)
+	}
+	httpServer := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	s.mu.Lock()
+	s.token = token
+	s.server = httpServer
+	s.listener = listener
+	s.mu.Unlock()
+
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("API服务器错误: %v\n", err)
+		}
+	}()
+	fmt.Printf("管理API开始监听 %s\n", addr)
+	return nil
+}
+
+// Stop 关闭监听器，未启动时什么也不做
+func (s *Server) Stop() {
+	s.mu.Lock()
+	httpServer, listener := s.server, s.listener
+	s.server, s.listener = nil, nil
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		httpServer.Close()
+	}
+	// Serve可能还没开始，监听器不一定已被Shutdown关闭
+	listener.Close()
+}
+
+// Handler 返回API的HTTP处理器，OpenAPI文档不需要令牌，其余请求都需要
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+openAPIPath, func(w http.ResponseWriter, r *http.Request) {
+		doc, err := OpenAPI()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+	for _, rt := range routes {
+		mux.Handle(rt.method+" "+rt.path, s.authorize(func(w http.ResponseWriter, r *http.Request) {
+			result, err := rt.handle(s, r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if rt.method != http.MethodGet {
+				s.notifyChange()
+			}
+			writeResult(w, rt.status, result)
+		}))
+	}
+	return mux
+}
+
+func (s *Server) authorize(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		token := s.token
+		s.mu.Unlock()
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="proxymgr"`)
+			writeError(w, &statusError{http.StatusUnauthorized, errors.New("缺少或无效的访问令牌")})
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) notifyChange() {
+	s.mu.Lock()
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// statusError 带HTTP状态码的错误，其他错误按500返回
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+func badRequest(err error) error { return &statusError{http.StatusBadRequest, err} }
+func notFound(err error) error   { return &statusError{http.StatusNotFound, err} }
+func conflict(err error) error   { return &statusError{http.StatusConflict, err} }
+
+// ErrorResponse 请求失败时返回的内容
+type ErrorResponse struct {
+	Error  string              `json:"error"`
+	Fields []config.FieldError `json:"fields,omitempty"` // 配置校验失败时各字段的错误
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var se *statusError
+	if errors.As(err, &se) {
+		status = se.status
+	}
+	response := ErrorResponse{Error: err.Error()}
+	var ve *config.ValidationError
+	if errors.As(err, &ve) {
+		response.Fields = ve.Fields
+	}
+	writeJSON(w, status, response)
+}
+
+func writeResult(w http.ResponseWriter, status int, result any) {
+	switch result := result.(type) {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case csvText:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(result))
+	default:
+		writeJSON(w, status, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -16,6 +19,95 @@ type AppSettings struct {
 
 	// 首次关闭提示设置
 	FirstCloseAsked bool `json:"first_close_asked"` // 是否已经询问过首次关闭行为
+
+	// 停止代理时等待连接结束的秒数，超时后强制关闭
+	DrainTimeoutSeconds int `json:"drain_timeout_seconds"`
+
+	// 代理未单独设置时使用的超时
+	DefaultTimeouts Timeouts `json:"default_timeouts"`
+
+	// 访问日志设置
+	AccessLog AccessLogSettings `json:"access_log"`
+
+	// 指标监听设置
+	Metrics MetricsSettings `json:"metrics"`
+
+	// 本地管理API设置
+	API APISettings `json:"api"`
+}
+
+// AccessLogSettings 访问日志的级别和轮转设置
+type AccessLogSettings struct {
+	Level      string `json:"level"`        // "info"记录所有连接，"warn"只记录被拒绝和失败的连接，"error"只记录失败的连接，"off"关闭
+	MaxSizeMB  int    `json:"max_size_mb"`  // 单个文件超过该大小时轮转，0不按大小轮转
+	MaxAgeDays int    `json:"max_age_days"` // 归档文件保留的天数，0不按时间删除
+	MaxBackups int    `json:"max_backups"`  // 最多保留的归档文件数，0不限制
+}
+
+// Validate 检查日志级别和轮转设置
+func (s AccessLogSettings) Validate() error {
+	switch s.Level {
+	case "info", "warn", "error", "off":
+	default:
+		return fmt.Errorf("无效的日志级别: %q", s.Level)
+	}
+	if s.MaxSizeMB < 0 || s.MaxAgeDays < 0 || s.MaxBackups < 0 {
+		return fmt.Errorf("日志轮转设置不能为负数")
+	}
+	return nil
+}
+
+// MetricsSettings 本地指标监听器的设置，启用后在 http://<listen_addr>/metrics 提供Prometheus文本格式的指标
+type MetricsSettings struct {
+	Enabled    bool   `json:"enabled"`
+	ListenAddr string `json:"listen_addr"`
+}
+
+// DefaultMetricsListenAddr 默认只监听本机
+const DefaultMetricsListenAddr = "127.0.0.1:9464"
+
+// Validate 检查监听地址
+func (s MetricsSettings) Validate() error {
+	if !s.Enabled && s.ListenAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(s.ListenAddr); err != nil {
+		return fmt.Errorf("无效的指标监听地址 %q: %w", s.ListenAddr, err)
+	}
+	return nil
+}
+
+// APISettings 本地管理API的设置，请求需要在Authorization头中携带 Bearer <token>
+type APISettings struct {
+	Enabled    bool   `json:"enabled"`
+	ListenAddr string `json:"listen_addr"`
+	Token      string `json:"token"`
+}
+
+// DefaultAPIListenAddr 默认只监听本机
+const DefaultAPIListenAddr = "127.0.0.1:9465"
+
+// Validate 检查监听地址，启用时必须设置令牌
+func (s APISettings) Validate() error {
+	if !s.Enabled && s.ListenAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(s.ListenAddr); err != nil {
+		return fmt.Errorf("无效的API监听地址 %q: %w", s.ListenAddr, err)
+	}
+	if s.Enabled && s.Token == "" {
+		return fmt.Errorf("启用API时必须设置访问令牌")
+	}
+	return nil
+}
+
+// GenerateAPIToken 生成随机的API访问令牌
+func GenerateAPIToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("无法生成访问令牌: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
 
 // AppSettingsManager 应用设置管理器
@@ -39,6 +131,21 @@ func NewAppSettingsManager(configDir string) *AppSettingsManager {
 			MinimizeToTray:  true,  // 默认最小化到托盘
 			ShowTrayIcon:    true,  // 默认显示托盘图标
 			FirstCloseAsked: false, // 默认未询问过
+
+			DrainTimeoutSeconds: 10,
+			DefaultTimeouts:     DefaultTimeouts,
+			AccessLog: AccessLogSettings{
+				Level:      "info",
+				MaxSizeMB:  10,
+				MaxAgeDays: 7,
+				MaxBackups: 10,
+			},
+			Metrics: MetricsSettings{
+				ListenAddr: DefaultMetricsListenAddr,
+			},
+			API: APISettings{
+				ListenAddr: DefaultAPIListenAddr,
+			},
 		},
 		filePath: settingsPath,
 	}
@@ -86,7 +193,8 @@ func (asm *AppSettingsManager) SaveSettings() error {
 		return fmt.Errorf("无法序列化应用设置: %w", err)
 	}
 
-	if err := os.WriteFile(asm.filePath, data, 0644); err != nil {
+	// 设置中有管理API的令牌，只允许当前用户读写
+	if err := WriteFileAtomic(asm.filePath, data, 0600); err != nil {
 		return fmt.Errorf("无法写入应用设置文件: %w", err)
 	}
 
@@ -127,6 +235,78 @@ func (asm *AppSettingsManager) SetFirstCloseAsked(asked bool) error {
 	return asm.SaveSettings()
 }
 
+// SetDrainTimeoutSeconds 设置停止代理时等待连接结束的秒数
+func (asm *AppSettingsManager) SetDrainTimeoutSeconds(seconds int) error {
+	if seconds < 0 {
+		return fmt.Errorf("等待时间不能为负数: %d", seconds)
+	}
+
+	asm.mu.Lock()
+	asm.settings.DrainTimeoutSeconds = seconds
+	asm.mu.Unlock()
+
+	return asm.SaveSettings()
+}
+
+// SetDefaultTimeouts 设置代理未单独设置时使用的超时
+func (asm *AppSettingsManager) SetDefaultTimeouts(timeouts Timeouts) error {
+	if err := timeouts.Validate(); err != nil {
+		return err
+	}
+
+	asm.mu.Lock()
+	asm.settings.DefaultTimeouts = timeouts
+	asm.mu.Unlock()
+
+	return asm.SaveSettings()
+}
+
+// SetAccessLogSettings 设置访问日志的级别和轮转方式
+func (asm *AppSettingsManager) SetAccessLogSettings(settings AccessLogSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	asm.mu.Lock()
+	asm.settings.AccessLog = settings
+	asm.mu.Unlock()
+
+	return asm.SaveSettings()
+}
+
+// SetMetricsSettings 设置本地指标监听器
+func (asm *AppSettingsManager) SetMetricsSettings(settings MetricsSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	asm.mu.Lock()
+	asm.settings.Metrics = settings
+	asm.mu.Unlock()
+
+	return asm.SaveSettings()
+}
+
+// SetAPISettings 设置本地管理API，启用时令牌为空则自动生成，返回保存后的设置
+func (asm *AppSettingsManager) SetAPISettings(settings APISettings) (APISettings, error) {
+	if settings.Enabled && settings.Token == "" {
+		token, err := GenerateAPIToken()
+		if err != nil {
+			return settings, err
+		}
+		settings.Token = token
+	}
+	if err := settings.Validate(); err != nil {
+		return settings, err
+	}
+
+	asm.mu.Lock()
+	asm.settings.API = settings
+	asm.mu.Unlock()
+
+	return settings, asm.SaveSettings()
+}
+
 // IsFirstClose 检查是否是首次关闭
 func (asm *AppSettingsManager) IsFirstClose() bool {
 	asm.mu.RLock()
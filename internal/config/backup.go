@@ -0,0 +1,244 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxBackups 保留的配置文件备份数量
+const MaxBackups = 20
+
+const backupTimeLayout = "20060102-150405.000"
+
+// Backup 配置文件的一个备份
+type Backup struct {
+	Name    string    `json:"name"`
+	Time    time.Time `json:"time"`
+	Size    int64     `json:"size"`
+	Proxies int       `json:"proxies"` // 备份中的代理数，无法解析时为-1
+}
+
+// backupDir 备份放在配置文件所在目录的backups目录
+func (cm *ConfigManager) backupDir() string {
+	return filepath.Join(filepath.Dir(cm.filePath), "backups")
+}
+
+// backupName 由配置文件名和时间组成，如 config-20250527-153000.000.json，按名称排序即按时间排序
+func (cm *ConfigManager) backupName(t time.Time) string {
+	ext := filepath.Ext(cm.filePath)
+	base := strings.TrimSuffix(filepath.Base(cm.filePath), ext)
+	return base + "-" + t.Format(backupTimeLayout) + ext
+}
+
+// parseBackupName 检查名称是否是本配置文件的备份并解析时间
+func (cm *ConfigManager) parseBackupName(name string) (time.Time, bool) {
+	ext := filepath.Ext(cm.filePath)
+	prefix := strings.TrimSuffix(filepath.Base(cm.filePath), ext) + "-"
+	if name != filepath.Base(name) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+	return t, err == nil
+}
+
+// backupCurrent 在覆盖配置文件前把当前内容保存为备份，只保留最近 MaxBackups 个备份。
+// 按解密后的内容比较，没有变化或只有 auto_start 变化时不备份，以免启停代理时
+// 记录的运行状态把较早的备份挤出
+func (cm *ConfigManager) backupCurrent(doc *ConfigDocument) error {
+	current, err := os.ReadFile(cm.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if cm.sameContent(current, doc) {
+		return nil
+	}
+
+	dir := cm.backupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(filepath.Join(dir, cm.backupName(time.Now())), current, 0600); err != nil {
+		return err
+	}
+
+	names, err := cm.backupNames()
+	if err != nil {
+		return err
+	}
+	for len(names) > MaxBackups {
+		os.Remove(filepath.Join(dir, names[len(names)-1]))
+		names = names[:len(names)-1]
+	}
+	return nil
+}
+
+// sameContent 判断文件内容解密后是否与 doc 相同，不比较加密参数和各代理的 auto_start。
+// 无法解析或解密时视为不同
+func (cm *ConfigManager) sameContent(data []byte, doc *ConfigDocument) bool {
+	current, _, err := parseConfigDocument(data, cm.isYAML())
+	if err != nil || openDocument(current, cm.key) != nil {
+		return false
+	}
+	a, err := marshalConfigDocument(comparableDocument(current), cm.isYAML())
+	if err != nil {
+		return false
+	}
+	b, err := marshalConfigDocument(comparableDocument(doc), cm.isYAML())
+	return err == nil && bytes.Equal(a, b)
+}
+
+// comparableDocument 返回去掉加密参数和 auto_start 的配置副本
+func comparableDocument(doc *ConfigDocument) *ConfigDocument {
+	copied := *doc
+	copied.Encryption = nil
+	copied.Proxies = make([]*ProxyConfig, len(doc.Proxies))
+	for i, proxy := range doc.Proxies {
+		p := *proxy
+		p.AutoStart = false
+		copied.Proxies[i] = &p
+	}
+	return &copied
+}
+
+// backupNames 返回所有备份的文件名，最新的在前
+func (cm *ConfigManager) backupNames() ([]string, error) {
+	entries, err := os.ReadDir(cm.backupDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, ok := cm.parseBackupName(entry.Name()); ok && entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// ListBackups 列出配置文件的备份，最新的在前
+func (cm *ConfigManager) ListBackups() ([]Backup, error) {
+	names, err := cm.backupNames()
+	if err != nil {
+		return nil, fmt.Errorf("无法读取备份目录: %w", err)
+	}
+	backups := []Backup{}
+	for _, name := range names {
+		path := filepath.Join(cm.backupDir(), name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		t, _ := cm.parseBackupName(name)
+		backup := Backup{Name: name, Time: t, Size: info.Size(), Proxies: -1}
+		if data, err := os.ReadFile(path); err == nil {
+			if doc, _, err := parseConfigDocument(data, cm.isYAML()); err == nil {
+				backup.Proxies = len(doc.Proxies)
+			}
+		}
+		backups = append(backups, backup)
+	}
+	return backups, nil
+}
+
+// RestoreBackup 用备份替换当前配置并保存。恢复前的配置会像其他修改一样先被备份，
+// 所以恢复操作本身也可以撤销
+func (cm *ConfigManager) RestoreBackup(name string) error {
+	if _, ok := cm.parseBackupName(name); !ok {
+		return fmt.Errorf("无效的备份名称: %s", name)
+	}
+	data, err := os.ReadFile(filepath.Join(cm.backupDir(), name))
+	if err != nil {
+		return fmt.Errorf("无法读取备份: %w", err)
+	}
+	doc, _, err := parseConfigDocument(data, cm.isYAML())
+	if err != nil {
+		return fmt.Errorf("无法恢复备份 %s: %w", name, err)
+	}
+
+	cm.mu.Lock()
+	if err := openDocument(doc, cm.key); err != nil {
+		cm.mu.Unlock()
+		if errors.Is(err, ErrLocked) || errors.Is(err, ErrWrongPassword) {
+			return fmt.Errorf("无法恢复备份 %s: 备份使用了其他主密码加密", name)
+		}
+		return fmt.Errorf("无法恢复备份 %s: %w", name, err)
+	}
+	cm.setDocument(doc)
+	cm.mu.Unlock()
+
+	return cm.SaveConfig()
+}
+
+// upgradeBackupNames 返回 upgradeFileLocked 保存的升级前的原文件，如 config.v1-时间.json
+func (cm *ConfigManager) upgradeBackupNames() ([]string, error) {
+	entries, err := os.ReadDir(cm.backupDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ext := filepath.Ext(cm.filePath)
+	prefix := strings.TrimSuffix(filepath.Base(cm.filePath), ext) + ".v"
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ext) && entry.Type().IsRegular() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// rekeyBackups 更换主密码后用新密钥重写备份和升级前的原文件，key 为空时解密为明文。
+// 原文件会以当前版本的格式重写。无法用旧密钥解密的备份保持不变。调用方需持有saveMu
+func (cm *ConfigManager) rekeyBackups(oldKey, newKey *masterKey) {
+	names, err := cm.backupNames()
+	if err == nil {
+		var upgraded []string
+		upgraded, err = cm.upgradeBackupNames()
+		names = append(names, upgraded...)
+	}
+	if err != nil {
+		fmt.Printf("警告: 无法读取备份目录: %v\n", err)
+		return
+	}
+	for _, name := range names {
+		path := filepath.Join(cm.backupDir(), name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		doc, _, err := parseConfigDocument(data, cm.isYAML())
+		if err != nil {
+			continue
+		}
+		if err := openDocument(doc, oldKey); err != nil {
+			fmt.Printf("警告: 备份 %s 无法用原主密码解密，未重新加密: %v\n", name, err)
+			continue
+		}
+		doc.Encryption = nil
+		sealed, err := sealDocument(doc, newKey)
+		if err != nil {
+			continue
+		}
+		if data, err = marshalConfigDocument(sealed, cm.isYAML()); err == nil {
+			if err := WriteFileAtomic(path, data, 0600); err != nil {
+				fmt.Printf("警告: 无法重写备份 %s: %v\n", name, err)
+			}
+		}
+	}
+}
@@ -2,23 +2,34 @@ package config
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
 // ConfigManager 负责配置的加载、保存和管理
 type ConfigManager struct {
 	proxies    map[string]*ProxyConfig
 	proxyOrder []string // 维护代理的顺序
+	defaults   ProxyDefaults
+	groups     []ProxyGroup
 	mu         sync.RWMutex
+	saveMu     sync.Mutex // 串行化写文件和备份
 	filePath   string
+
+	fileMu   sync.Mutex
+	fileSum  [sha256.Size]byte // 最后一次读取或写入的文件内容摘要，用于发现其他程序的修改
+	fileMod  time.Time
+	fileSize int64
+
+	key    *masterKey      // 设置了主密码时用于加密密码字段
+	locked *EncryptionInfo // 配置文件已加密但没有对应的密钥时不为空，此时不能保存
 }
 
 // NewConfigManager 创建一个新的配置管理器
@@ -35,7 +46,11 @@ func NewConfigManager(filePath string) *ConfigManager {
 		filePath:   filePath,
 	}
 
-	if err := cm.LoadConfig(); err != nil {
+	err := cm.LoadConfig()
+	if errors.Is(err, ErrLocked) && os.Getenv(MasterPasswordEnv) != "" {
+		err = cm.Unlock(os.Getenv(MasterPasswordEnv))
+	}
+	if err != nil {
 		fmt.Printf("警告: 无法加载初始配置: %v\n", err)
 		// 如果加载失败或文件不存在，则初始化为空配置
 		cm.proxies = make(map[string]*ProxyConfig)
@@ -44,79 +59,170 @@ func NewConfigManager(filePath string) *ConfigManager {
 	return cm
 }
 
-// LoadConfig 从文件加载配置
+// LoadConfig 从文件加载配置，旧版本的配置文件会先备份再升级为当前版本
 func (cm *ConfigManager) LoadConfig() error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
+	cm.fileMu.Lock()
+	defer cm.fileMu.Unlock()
 
 	data, err := os.ReadFile(cm.filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			cm.proxies = make(map[string]*ProxyConfig)
-			cm.proxyOrder = make([]string, 0)
+			cm.setDocument(&ConfigDocument{})
 			return nil // 文件不存在，全新开始
 		}
 		return fmt.Errorf("无法读取配置文件: %w", err)
 	}
+	// 无法解析的内容也记下摘要，文件再次修改前不会重复加载
+	cm.rememberFileLocked(data)
 
-	var proxies []*ProxyConfig
-
-	if strings.HasSuffix(cm.filePath, ".yaml") || strings.HasSuffix(cm.filePath, ".yml") {
-		if err := yaml.Unmarshal(data, &proxies); err != nil {
-			return fmt.Errorf("无法解析YAML配置: %w", err)
+	doc, fromVersion, err := parseConfigDocument(data, cm.isYAML())
+	if err != nil {
+		return err
+	}
+	// 加密的配置在解锁前不加载，也不允许保存，以免覆盖文件中的密码
+	if err := openDocument(doc, cm.key); err != nil {
+		if errors.Is(err, ErrLocked) || errors.Is(err, ErrWrongPassword) {
+			cm.locked = doc.Encryption
 		}
-	} else {
-		if err := json.Unmarshal(data, &proxies); err != nil {
-			return fmt.Errorf("无法解析JSON配置: %w", err)
+		return err
+	}
+	if doc.Encryption == nil {
+		cm.key = nil // 文件中的加密设置为准，其他程序去掉了加密时不再加密
+	}
+	cm.locked = nil
+	cm.setDocument(doc)
+
+	if fromVersion < CurrentConfigVersion {
+		if err := cm.upgradeFileLocked(data, fromVersion, doc); err != nil {
+			fmt.Printf("警告: 无法升级配置文件: %v\n", err)
 		}
 	}
+	return nil
+}
+
+// upgradeFileLocked 把升级前的配置文件备份为 backups/config.v1-时间.json，不参与备份轮换，
+// 然后以当前版本的格式重写配置文件。调用方需持有fileMu
+func (cm *ConfigManager) upgradeFileLocked(original []byte, fromVersion int, doc *ConfigDocument) error {
+	ext := filepath.Ext(cm.filePath)
+	base := strings.TrimSuffix(filepath.Base(cm.filePath), ext)
+	name := fmt.Sprintf("%s.v%d-%s%s", base, fromVersion, time.Now().Format(backupTimeLayout), ext)
+	if err := os.MkdirAll(cm.backupDir(), 0700); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(filepath.Join(cm.backupDir(), name), original, 0600); err != nil {
+		return fmt.Errorf("无法备份旧版本配置: %w", err)
+	}
+
+	sealed, err := sealDocument(doc, cm.key)
+	if err != nil {
+		return err
+	}
+	data, err := marshalConfigDocument(sealed, cm.isYAML())
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(cm.filePath, data, 0600); err != nil {
+		return fmt.Errorf("无法写入配置文件: %w", err)
+	}
+	cm.rememberFileLocked(data)
+	fmt.Printf("配置文件已从版本 %d 升级到 %d，原文件备份为 %s\n", fromVersion, CurrentConfigVersion, name)
+	return nil
+}
+
+// rememberFileLocked 记录刚读取或写入的文件内容，调用方需持有fileMu
+func (cm *ConfigManager) rememberFileLocked(data []byte) {
+	cm.fileSum = sha256.Sum256(data)
+	if info, err := os.Stat(cm.filePath); err == nil {
+		cm.fileMod, cm.fileSize = info.ModTime(), info.Size()
+	}
+}
+
+// FileChanged 检查配置文件是否在最后一次读取或写入后被其他程序修改过。
+// 先比较修改时间和大小，有变化时再比较内容，只改了修改时间不算修改
+func (cm *ConfigManager) FileChanged() (bool, error) {
+	cm.fileMu.Lock()
+	defer cm.fileMu.Unlock()
+
+	info, err := os.Stat(cm.filePath)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(cm.fileMod) && info.Size() == cm.fileSize {
+		return false, nil
+	}
+	data, err := os.ReadFile(cm.filePath)
+	if err != nil {
+		return false, err
+	}
+	cm.fileMod, cm.fileSize = info.ModTime(), info.Size()
+	return sha256.Sum256(data) != cm.fileSum, nil
+}
 
+// setDocument 替换全部配置，调用方需持有写锁
+func (cm *ConfigManager) setDocument(doc *ConfigDocument) {
 	// 将数组转换为映射表，并维护顺序
 	newProxies := make(map[string]*ProxyConfig)
-	newProxyOrder := make([]string, 0, len(proxies))
-	for _, proxy := range proxies {
+	newProxyOrder := make([]string, 0, len(doc.Proxies))
+	for _, proxy := range doc.Proxies {
 		newProxies[proxy.ID] = proxy
 		newProxyOrder = append(newProxyOrder, proxy.ID)
 	}
 	cm.proxies = newProxies
 	cm.proxyOrder = newProxyOrder
+	cm.defaults = doc.Defaults
+	cm.groups = doc.Groups
+}
 
-	return nil
+func (cm *ConfigManager) isYAML() bool {
+	return strings.HasSuffix(cm.filePath, ".yaml") || strings.HasSuffix(cm.filePath, ".yml")
 }
 
-// SaveConfig 保存配置到文件
+// SaveConfig 保存配置到文件。先写入临时文件再替换，被覆盖的内容保存到备份目录
 func (cm *ConfigManager) SaveConfig() error {
+	cm.saveMu.Lock()
+	defer cm.saveMu.Unlock()
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
+	if cm.locked != nil {
+		return ErrLocked
+	}
 
 	// 将映射表转换为数组，按照维护的顺序
-	var proxies []*ProxyConfig
+	doc := &ConfigDocument{
+		Version:  CurrentConfigVersion,
+		Defaults: cm.defaults,
+		Proxies:  make([]*ProxyConfig, 0, len(cm.proxyOrder)),
+		Groups:   cm.groups,
+	}
 	for _, id := range cm.proxyOrder {
 		if proxy, exists := cm.proxies[id]; exists {
-			proxies = append(proxies, proxy)
+			doc.Proxies = append(doc.Proxies, proxy)
 		}
 	}
 
-	var (
-		data []byte
-		err  error
-	)
+	sealed, err := sealDocument(doc, cm.key)
+	if err != nil {
+		return err
+	}
+	data, err := marshalConfigDocument(sealed, cm.isYAML())
+	if err != nil {
+		return err
+	}
 
-	if strings.HasSuffix(cm.filePath, ".yaml") || strings.HasSuffix(cm.filePath, ".yml") {
-		data, err = yaml.Marshal(proxies)
-		if err != nil {
-			return fmt.Errorf("无法序列化为YAML: %w", err)
-		}
-	} else {
-		data, err = json.MarshalIndent(proxies, "", "  ")
-		if err != nil {
-			return fmt.Errorf("无法序列化为JSON: %w", err)
-		}
+	// 备份失败不影响保存
+	if err := cm.backupCurrent(doc); err != nil {
+		fmt.Printf("警告: 无法备份配置文件: %v\n", err)
 	}
 
-	if err := os.WriteFile(cm.filePath, data, 0644); err != nil {
+	cm.fileMu.Lock()
+	defer cm.fileMu.Unlock()
+	// 配置中有上游密码，只允许当前用户读写
+	if err := WriteFileAtomic(cm.filePath, data, 0600); err != nil {
 		return fmt.Errorf("无法写入配置文件: %w", err)
 	}
+	cm.rememberFileLocked(data)
 
 	return nil
 }
@@ -125,7 +231,11 @@ func (cm *ConfigManager) SaveConfig() error {
 func (cm *ConfigManager) AddProxy(proxy *ProxyConfig) (string, error) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
+	return cm.addProxyLocked(proxy)
+}
 
+// addProxyLocked 添加代理，调用方需持有写锁
+func (cm *ConfigManager) addProxyLocked(proxy *ProxyConfig) (string, error) {
 	if proxy.ID == "" {
 		id, err := generateUniqueID()
 		if err != nil {
@@ -136,6 +246,22 @@ func (cm *ConfigManager) AddProxy(proxy *ProxyConfig) (string, error) {
 		return "", fmt.Errorf("ID '%s' 已存在", proxy.ID)
 	}
 
+	// 复制默认值，之后修改代理或默认值时不会互相影响
+	if proxy.Bandwidth == nil && cm.defaults.Bandwidth != nil {
+		v := *cm.defaults.Bandwidth
+		proxy.Bandwidth = &v
+	}
+	if proxy.Limits == nil && cm.defaults.Limits != nil {
+		v := *cm.defaults.Limits
+		v.Allow = append([]string(nil), v.Allow...)
+		v.Deny = append([]string(nil), v.Deny...)
+		proxy.Limits = &v
+	}
+	if proxy.Timeouts == nil && cm.defaults.Timeouts != nil {
+		v := *cm.defaults.Timeouts
+		proxy.Timeouts = &v
+	}
+
 	cm.proxies[proxy.ID] = proxy
 	cm.proxyOrder = append(cm.proxyOrder, proxy.ID)
 	return proxy.ID, nil
@@ -171,9 +297,33 @@ func (cm *ConfigManager) DeleteProxy(id string) error {
 			break
 		}
 	}
+	// 从分组中移除
+	for i := range cm.groups {
+		members := cm.groups[i].Proxies[:0:0]
+		for _, member := range cm.groups[i].Proxies {
+			if member != id {
+				members = append(members, member)
+			}
+		}
+		cm.groups[i].Proxies = members
+	}
 	return nil
 }
 
+// GetDefaults 获取添加代理时使用的默认设置
+func (cm *ConfigManager) GetDefaults() ProxyDefaults {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.defaults
+}
+
+// GetGroups 获取代理分组
+func (cm *ConfigManager) GetGroups() []ProxyGroup {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return append([]ProxyGroup(nil), cm.groups...)
+}
+
 // GetProxy 获取代理配置
 func (cm *ConfigManager) GetProxy(id string) (*ProxyConfig, error) {
 	cm.mu.RLock()
@@ -216,6 +366,62 @@ func (cm *ConfigManager) UpdateProxyAutoStart(id string, autoStart bool) error {
 	return nil
 }
 
+// UpdateHeaderRules 更新代理的HTTP头部改写规则
+func (cm *ConfigManager) UpdateHeaderRules(id string, rules []HeaderRule) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	proxy, exists := cm.proxies[id]
+	if !exists {
+		return fmt.Errorf("ID '%s' 不存在", id)
+	}
+
+	proxy.HeaderRules = rules
+	return nil
+}
+
+// UpdateBandwidthLimit 更新代理的带宽限制
+func (cm *ConfigManager) UpdateBandwidthLimit(id string, limit *BandwidthLimit) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	proxy, exists := cm.proxies[id]
+	if !exists {
+		return fmt.Errorf("ID '%s' 不存在", id)
+	}
+
+	proxy.Bandwidth = limit
+	return nil
+}
+
+// UpdateConnectionLimits 更新代理的连接数限制和访问控制
+func (cm *ConfigManager) UpdateConnectionLimits(id string, limits *ConnectionLimits) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	proxy, exists := cm.proxies[id]
+	if !exists {
+		return fmt.Errorf("ID '%s' 不存在", id)
+	}
+
+	proxy.Limits = limits
+	return nil
+}
+
+// UpdateTimeouts 更新代理的超时设置
+func (cm *ConfigManager) UpdateTimeouts(id string, timeouts *Timeouts) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	proxy, exists := cm.proxies[id]
+	if !exists {
+		return fmt.Errorf("ID '%s' 不存在", id)
+	}
+
+	proxy.Timeouts = timeouts
+	return nil
+}
+
 // SaveProxyStates 批量保存代理的运行状态
 func (cm *ConfigManager) SaveProxyStates(states map[string]bool) error {
 	cm.mu.Lock()
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// csvHeaders 导出CSV的标题行，导入时跳过第一行，按列的位置读取
+var csvHeaders = []string{
+	"代理名称", "上游协议", "上游地址", "上游用户名", "上游密码",
+	"本地协议", "本地IP", "本地端口", "是否启用", "上游加密方式",
+}
+
+// ExportCSV 把代理配置导出为CSV，带UTF-8 BOM以便Excel正确识别中文。
+// withPasswords 为 false 时上游密码一栏留空
+func ExportCSV(proxies []*ProxyConfig, withPasswords bool) (string, error) {
+	var csvData strings.Builder
+	csvData.WriteString("\ufeff")
+
+	writer := csv.NewWriter(&csvData)
+	if err := writer.Write(csvHeaders); err != nil {
+		return "", fmt.Errorf("写入CSV标题失败: %w", err)
+	}
+
+	for _, proxy := range proxies {
+		enabledStr := "否"
+		if proxy.Enabled {
+			enabledStr = "是"
+		}
+
+		password := proxy.Upstream.Password
+		if !withPasswords {
+			password = ""
+		}
+
+		row := []string{
+			proxy.Name,
+			proxy.Upstream.Protocol,
+			proxy.Upstream.Address,
+			proxy.Upstream.Username,
+			password,
+			proxy.Local.Protocol,
+			proxy.Local.ListenIP,
+			strconv.Itoa(proxy.Local.ListenPort),
+			enabledStr,
+			proxy.Upstream.Cipher,
+		}
+		if err := writer.Write(row); err != nil {
+			return "", fmt.Errorf("写入CSV数据失败: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("CSV写入出错: %w", err)
+	}
+	return csvData.String(), nil
+}
+
+// csvRowPrefix 导入CSV时字段错误的路径前缀，N为CSV中的行号（标题行为第1行），
+// 如 rows[3].local.listen_port
+func csvRowPrefix(line int) string {
+	return fmt.Sprintf("rows[%d].", line)
+}
+
+// ParseCSV 解析ExportCSV格式的数据，不完整或格式错误的行记为字段错误，
+// 任何一行有错误时返回 *ValidationError。返回的代理没有ID，添加时再生成
+func ParseCSV(data string) ([]*ProxyConfig, error) {
+	// 移除UTF-8 BOM（如果存在）
+	data = strings.TrimPrefix(data, "\ufeff")
+
+	reader := csv.NewReader(strings.NewReader(data))
+	// 允许不等长的字段，列数不足的行在下面报告
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("解析CSV数据失败: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("CSV文件至少需要包含标题行和一行数据")
+	}
+
+	v := &ValidationError{}
+	var proxies []*ProxyConfig
+	for i, record := range records[1:] {
+		prefix := csvRowPrefix(i + 2)
+		if len(record) < 9 {
+			v.add(strings.TrimSuffix(prefix, "."), CodeRequired, "第%d行数据不完整，需要至少9列，实际%d列", i+2, len(record))
+			continue
+		}
+
+		localPort, err := strconv.Atoi(strings.TrimSpace(record[7]))
+		if err != nil {
+			v.add(prefix+"local.listen_port", CodeInvalid, "第%d行本地端口格式错误: %q", i+2, record[7])
+			continue
+		}
+
+		enabled := record[8] == "是" || strings.ToLower(record[8]) == "true" || record[8] == "1"
+
+		upstream := UpstreamProxy{
+			Protocol:   record[1],
+			Address:    record[2],
+			Username:   record[3],
+			Password:   record[4],
+			AuthMethod: "basic",
+		}
+		if len(record) > 9 {
+			upstream.Cipher = record[9]
+		}
+
+		// 上游地址一栏也可以直接填写 ss:// 等代理链接
+		name := record[0]
+		if strings.Contains(record[2], "://") {
+			parsed, linkName, err := ParseUpstreamURL(record[2])
+			if err != nil {
+				v.add(prefix+"upstream.address", CodeInvalid, "第%d行上游链接无效: %v", i+2, err)
+				continue
+			}
+			upstream = parsed
+			if name == "" {
+				name = linkName
+			}
+		}
+
+		proxies = append(proxies, &ProxyConfig{
+			Name:     name,
+			Upstream: upstream,
+			Local: LocalProxy{
+				Protocol:   record[5],
+				ListenIP:   record[6],
+				ListenPort: localPort,
+			},
+			Enabled: enabled,
+		})
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+// ImportCSV 解析并校验CSV中的代理，包括与已有代理及CSV中其他行的本地地址冲突，
+// 全部有效时才添加，否则一个也不添加并返回 *ValidationError。返回添加的数量，不会保存配置文件。
+// 检查冲突和添加在同一次加锁内完成，期间其他修改不会插进来
+func (cm *ConfigManager) ImportCSV(data string) (int, error) {
+	proxies, err := ParseCSV(data)
+	if err != nil {
+		return 0, err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	v := &ValidationError{}
+	for i, proxy := range proxies {
+		prefix := csvRowPrefix(i + 2)
+		proxy.validate(v, prefix)
+		cm.checkDuplicateListenLocked(v, prefix, proxy, proxies[:i])
+	}
+	if err := v.err(); err != nil {
+		return 0, err
+	}
+
+	added := len(cm.proxyOrder)
+	for _, proxy := range proxies {
+		if _, err := cm.addProxyLocked(proxy); err != nil {
+			// 撤销已经添加的行
+			for _, id := range cm.proxyOrder[added:] {
+				delete(cm.proxies, id)
+			}
+			cm.proxyOrder = cm.proxyOrder[:added]
+			return 0, fmt.Errorf("导入代理 %s 失败: %w", proxy.Name, err)
+		}
+	}
+	return len(proxies), nil
+}
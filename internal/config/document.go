@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentConfigVersion 配置文件格式的当前版本。修改字段名或结构时增加版本号，
+// 并在 migrations 中添加从上一版本升级的步骤
+const CurrentConfigVersion = 2
+
+// ConfigDocument 配置文件的顶层结构
+type ConfigDocument struct {
+	Version  int            `json:"version" yaml:"version"`
+	Defaults ProxyDefaults  `json:"defaults" yaml:"defaults"`
+	Proxies  []*ProxyConfig `json:"proxies" yaml:"proxies"`
+	Groups   []ProxyGroup   `json:"groups,omitempty" yaml:"groups,omitempty"`
+
+	// Encryption 设置了主密码时记录加密参数，代理的密码字段以 enc:v1: 开头
+	Encryption *EncryptionInfo `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+}
+
+// ProxyDefaults 添加代理时，代理没有设置的项使用这里的值
+type ProxyDefaults struct {
+	Bandwidth *BandwidthLimit   `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+	Limits    *ConnectionLimits `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Timeouts  *Timeouts         `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+}
+
+// ProxyGroup 代理分组
+type ProxyGroup struct {
+	Name    string   `json:"name" yaml:"name"`
+	Proxies []string `json:"proxies" yaml:"proxies"` // 代理ID
+}
+
+// migration 把上一版本的配置升级到 to 版本。配置以 encoding/json 解码的通用结构表示，
+// YAML 文件先转换为同样的结构，同一个升级步骤对两种格式都适用
+type migration struct {
+	to      int
+	migrate func(doc any) (any, error)
+}
+
+// migrations 按版本顺序排列，migrations[i] 把版本 i+1 升级到版本 i+2
+var migrations = []migration{
+	{to: 2, migrate: migrateBareArray},
+}
+
+// migrateBareArray 版本1的配置文件只有代理数组，放到新结构的 proxies 中
+func migrateBareArray(doc any) (any, error) {
+	proxies, ok := doc.([]any)
+	if !ok && doc != nil { // 没有代理时旧版本保存为 null
+		return nil, fmt.Errorf("版本1的配置应为代理数组")
+	}
+	return map[string]any{"proxies": proxies}, nil
+}
+
+// configVersion 判断通用结构的版本：数组是版本1，否则读取 version 字段
+func configVersion(doc any) (int, error) {
+	switch doc := doc.(type) {
+	case nil, []any:
+		return 1, nil
+	case map[string]any:
+		version, ok := doc["version"].(float64)
+		if !ok || version != float64(int(version)) || version < 1 {
+			return 0, fmt.Errorf("配置文件缺少有效的 version 字段")
+		}
+		return int(version), nil
+	default:
+		return 0, fmt.Errorf("无法识别的配置文件结构")
+	}
+}
+
+// parseConfigDocument 解析配置文件内容，旧版本的配置会被升级到当前版本，
+// 返回解析前的版本号。比当前程序支持的版本新的配置不会被解析，以免保存时丢失数据
+func parseConfigDocument(data []byte, isYAML bool) (*ConfigDocument, int, error) {
+	var raw any
+	if isYAML {
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, 0, fmt.Errorf("无法解析YAML配置: %w", err)
+		}
+		// 转换为与JSON相同的通用结构
+		converted, err := json.Marshal(raw)
+		if err != nil {
+			return nil, 0, fmt.Errorf("无法解析YAML配置: %w", err)
+		}
+		data = converted
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, fmt.Errorf("无法解析JSON配置: %w", err)
+	}
+
+	fromVersion, err := configVersion(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	if fromVersion > CurrentConfigVersion {
+		return nil, 0, fmt.Errorf("配置文件版本 %d 比程序支持的版本 %d 新，请升级程序", fromVersion, CurrentConfigVersion)
+	}
+	for version := fromVersion; version < CurrentConfigVersion; version++ {
+		step := migrations[version-1]
+		if raw, err = step.migrate(raw); err != nil {
+			return nil, 0, fmt.Errorf("无法将配置从版本 %d 升级到 %d: %w", version, step.to, err)
+		}
+	}
+
+	upgraded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	var doc ConfigDocument
+	if err := json.Unmarshal(upgraded, &doc); err != nil {
+		return nil, 0, fmt.Errorf("无法解析配置: %w", err)
+	}
+	doc.Version = CurrentConfigVersion
+	return &doc, fromVersion, nil
+}
+
+// marshalConfigDocument 按配置文件的格式序列化
+func marshalConfigDocument(doc *ConfigDocument, isYAML bool) ([]byte, error) {
+	if doc.Proxies == nil {
+		doc.Proxies = []*ProxyConfig{}
+	}
+	if isYAML {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("无法序列化为YAML: %w", err)
+		}
+		return data, nil
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("无法序列化为JSON: %w", err)
+	}
+	return data, nil
+}
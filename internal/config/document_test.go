@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeBareArrayConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{
+			name: "json",
+			file: "config.json",
+			data: `[{"id":"a","name":"A","upstream":{"protocol":"socks5","address":"127.0.0.1:1080"},` +
+				`"local":{"protocol":"http","listen_ip":"127.0.0.1","listen_port":8001},"enabled":true,"auto_start":true}]`,
+		},
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: "- id: a\n  name: A\n  upstream:\n    protocol: socks5\n    address: 127.0.0.1:1080\n" +
+				"  local:\n    protocol: http\n    listen_ip: 127.0.0.1\n    listen_port: 8001\n  enabled: true\n  auto_start: true\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(MasterPasswordEnv, "")
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cm := NewConfigManager(path)
+			proxy, err := cm.GetProxy("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proxy.Local.ListenPort != 8001 || !proxy.AutoStart || proxy.Upstream.Address != "127.0.0.1:1080" {
+				t.Fatalf("升级后的代理不正确: %+v", proxy)
+			}
+
+			// 文件被重写为当前版本
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, fromVersion, err := parseConfigDocument(data, cm.isYAML())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fromVersion != CurrentConfigVersion || len(doc.Proxies) != 1 {
+				t.Fatalf("文件版本 = %d，代理数 = %d", fromVersion, len(doc.Proxies))
+			}
+
+			// 原文件原样备份，不出现在备份列表中
+			matches, err := filepath.Glob(filepath.Join(dir, "backups", "config.v1-*"+filepath.Ext(tt.file)))
+			if err != nil || len(matches) != 1 {
+				t.Fatalf("应有一个升级前的备份: %v", matches)
+			}
+			original, err := os.ReadFile(matches[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(original) != tt.data {
+				t.Fatal("升级前的备份与原文件不同")
+			}
+			if backups, _ := cm.ListBackups(); len(backups) != 0 {
+				t.Fatalf("升级前的备份不应参与备份轮换: %v", backups)
+			}
+
+			// 再次加载已升级的文件不会再备份
+			if n := len(NewConfigManager(path).GetAllProxies()); n != 1 {
+				t.Fatalf("重新加载后代理数 = %d", n)
+			}
+			if matches, _ := filepath.Glob(filepath.Join(dir, "backups", "config.v1-*")); len(matches) != 1 {
+				t.Fatalf("已升级的文件被再次升级: %v", matches)
+			}
+		})
+	}
+}
+
+func TestEmptyBareArrayConfig(t *testing.T) {
+	doc, fromVersion, err := parseConfigDocument([]byte("null"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromVersion != 1 || doc.Version != CurrentConfigVersion || len(doc.Proxies) != 0 {
+		t.Fatalf("版本 = %d → %d，代理数 = %d", fromVersion, doc.Version, len(doc.Proxies))
+	}
+}
+
+func TestNewerConfigVersionNotLoaded(t *testing.T) {
+	t.Setenv(MasterPasswordEnv, "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	newer := `{"version": 99, "proxies": []}`
+	if err := os.WriteFile(path, []byte(newer), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewConfigManager(path)
+	if err := cm.LoadConfig(); err == nil || !strings.Contains(err.Error(), "99") {
+		t.Fatalf("应拒绝加载更新版本的配置，实际 %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != newer {
+		t.Fatal("更新版本的配置文件被改写")
+	}
+}
+
+func TestAddProxyCopiesDefaults(t *testing.T) {
+	t.Setenv(MasterPasswordEnv, "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"version":2,"defaults":{"timeouts":{"dial_seconds":7},"limits":{"allow":["127.0.0.1"]}},"proxies":[]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewConfigManager(path)
+	first := &ProxyConfig{Name: "first"}
+	second := &ProxyConfig{Name: "second"}
+	for _, proxy := range []*ProxyConfig{first, second} {
+		if _, err := cm.AddProxy(proxy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Timeouts == nil || first.Timeouts.DialSeconds != 7 {
+		t.Fatalf("没有使用默认超时: %+v", first.Timeouts)
+	}
+
+	first.Timeouts.DialSeconds = 1
+	first.Limits.Allow[0] = "10.0.0.1"
+	if second.Timeouts.DialSeconds != 7 || second.Limits.Allow[0] != "127.0.0.1" {
+		t.Fatal("修改一个代理影响了其他代理")
+	}
+	defaults := cm.GetDefaults()
+	if defaults.Timeouts.DialSeconds != 7 || defaults.Limits.Allow[0] != "127.0.0.1" {
+		t.Fatal("修改代理影响了默认值")
+	}
+}
@@ -1,5 +1,14 @@
 package config
 
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
 // ProxyConfig 代表一个代理配置
 type ProxyConfig struct {
 	ID        string        `json:"id" yaml:"id"`
@@ -8,6 +17,13 @@ type ProxyConfig struct {
 	Local     LocalProxy    `json:"local" yaml:"local"`
 	Enabled   bool          `json:"enabled" yaml:"enabled"`
 	AutoStart bool          `json:"auto_start" yaml:"auto_start"`
+
+	HeaderRules []HeaderRule `json:"header_rules,omitempty" yaml:"header_rules,omitempty"` // 仅本地HTTP代理的普通HTTP转发生效
+
+	Quota     *TrafficQuota     `json:"quota,omitempty" yaml:"quota,omitempty"`         // 流量配额，为空不限制
+	Bandwidth *BandwidthLimit   `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"` // 带宽限制，为空不限速
+	Limits    *ConnectionLimits `json:"limits,omitempty" yaml:"limits,omitempty"`       // 连接数限制和客户端访问控制，为空不限制
+	Timeouts  *Timeouts         `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`   // 超时设置，为空或为0的项使用全局默认值
 }
 
 type UpstreamProxy struct {
@@ -16,10 +32,217 @@ type UpstreamProxy struct {
 	Username   string `json:"username" yaml:"username"`
 	Password   string `json:"password" yaml:"password"`
 	AuthMethod string `json:"auth_method,omitempty" yaml:"auth_method,omitempty"`
+	Cipher     string `json:"cipher,omitempty" yaml:"cipher,omitempty"` // 仅shadowsocks使用
+
+	Transport *UpstreamTransport `json:"transport,omitempty" yaml:"transport,omitempty"` // 为空时直接TCP连接
+}
+
+// UpstreamTransport 到上游代理的传输层配置，用于穿过CDN等只放行WebSocket/HTTP2的链路
+type UpstreamTransport struct {
+	Type       string            `json:"type" yaml:"type"`                                   // "tcp", "ws" 或 "h2"
+	TLS        bool              `json:"tls,omitempty" yaml:"tls,omitempty"`                 // ws使用wss，h2使用TLS而非h2c
+	ServerName string            `json:"server_name,omitempty" yaml:"server_name,omitempty"` // TLS SNI，默认取Host
+	SkipVerify bool              `json:"skip_verify,omitempty" yaml:"skip_verify,omitempty"`
+	Host       string            `json:"host,omitempty" yaml:"host,omitempty"` // HTTP Host / :authority，默认取上游地址
+	Path       string            `json:"path,omitempty" yaml:"path,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// H2Protocol 非空时h2使用扩展CONNECT(RFC 8441)并作为 :protocol 发送，否则使用普通CONNECT
+	H2Protocol string `json:"h2_protocol,omitempty" yaml:"h2_protocol,omitempty"`
 }
 
 type LocalProxy struct {
 	Protocol   string `json:"protocol" yaml:"protocol"`
 	ListenIP   string `json:"listen_ip" yaml:"listen_ip"`
 	ListenPort int    `json:"listen_port" yaml:"listen_port"`
+	Via        string `json:"via,omitempty" yaml:"via,omitempty"` // 非空时HTTP转发添加Via头部，值为代理名称
+}
+
+// ShadowsocksCiphers 支持的shadowsocks加密方式
+var ShadowsocksCiphers = []string{
+	"aes-128-gcm",
+	"aes-256-gcm",
+	"chacha20-ietf-poly1305",
+	"2022-blake3-aes-128-gcm",
+	"2022-blake3-aes-256-gcm",
+	"2022-blake3-chacha20-poly1305",
+}
+
+// IsShadowsocksCipher 判断是否为支持的shadowsocks加密方式
+func IsShadowsocksCipher(name string) bool {
+	for _, c := range ShadowsocksCiphers {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderRule HTTP头部改写规则
+type HeaderRule struct {
+	Host      string `json:"host" yaml:"host"`           // 目标主机通配符，如 "*.example.com"，为空匹配所有主机
+	Direction string `json:"direction" yaml:"direction"` // "request" 或 "response"
+	Action    string `json:"action" yaml:"action"`       // "add", "replace" 或 "remove"
+	Name      string `json:"name" yaml:"name"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// Validate 检查规则的方向、动作、头部名称和值
+func (r HeaderRule) Validate() error {
+	if r.Direction != "request" && r.Direction != "response" {
+		return fmt.Errorf("无效的规则方向: %q", r.Direction)
+	}
+	switch r.Action {
+	case "add", "replace", "remove":
+	default:
+		return fmt.Errorf("无效的规则动作: %q", r.Action)
+	}
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("头部名称不能为空")
+	}
+	if !httpguts.ValidHeaderFieldName(r.Name) {
+		return fmt.Errorf("无效的头部名称: %q", r.Name)
+	}
+	if r.Action != "remove" && !httpguts.ValidHeaderFieldValue(r.Value) {
+		return fmt.Errorf("头部 %s 的值包含无效字符", r.Name)
+	}
+	if _, err := path.Match(strings.ToLower(r.Host), ""); err != nil {
+		return fmt.Errorf("无效的主机通配符 %q: %w", r.Host, err)
+	}
+	return nil
+}
+
+// TrafficQuota 代理的流量配额，上下行字节合计计入用量
+type TrafficQuota struct {
+	Limit    int64  `json:"limit" yaml:"limit"`                             // 每个周期允许的字节数
+	Period   string `json:"period" yaml:"period"`                           // "day", "month" 或 "lifetime"
+	ResetDay int    `json:"reset_day,omitempty" yaml:"reset_day,omitempty"` // 按月配额的重置日(1-28)，默认每月1日
+	Action   string `json:"action" yaml:"action"`                           // 达到配额后 "stop" 停止代理(新周期或重置后自动重新启动)，或 "block" 继续监听但拒绝新连接
+	WarnAt   []int  `json:"warn_at,omitempty" yaml:"warn_at,omitempty"`     // 用量达到这些百分比时发出提醒，如 [80, 95]
+}
+
+// Validate 检查配额的周期、动作和提醒阈值
+func (q TrafficQuota) Validate() error {
+	if q.Limit <= 0 {
+		return fmt.Errorf("配额必须大于0")
+	}
+	switch q.Period {
+	case "day", "month", "lifetime":
+	default:
+		return fmt.Errorf("无效的配额周期: %q", q.Period)
+	}
+	if q.ResetDay < 0 || q.ResetDay > 28 {
+		return fmt.Errorf("重置日必须在1到28之间: %d", q.ResetDay)
+	}
+	if q.Action != "stop" && q.Action != "block" {
+		return fmt.Errorf("无效的配额动作: %q", q.Action)
+	}
+	for _, pct := range q.WarnAt {
+		if pct <= 0 || pct >= 100 {
+			return fmt.Errorf("提醒阈值必须在1到99之间: %d", pct)
+		}
+	}
+	return nil
+}
+
+// BandwidthLimit 带宽限制，单位为字节每秒，0表示不限制。
+// 上传指客户端发往目标的方向，下载指目标返回客户端的方向
+type BandwidthLimit struct {
+	UploadBps       int64 `json:"upload_bps,omitempty" yaml:"upload_bps,omitempty"`               // 整个代理的上传速率
+	DownloadBps     int64 `json:"download_bps,omitempty" yaml:"download_bps,omitempty"`           // 整个代理的下载速率
+	ConnUploadBps   int64 `json:"conn_upload_bps,omitempty" yaml:"conn_upload_bps,omitempty"`     // 每个连接的上传速率
+	ConnDownloadBps int64 `json:"conn_download_bps,omitempty" yaml:"conn_download_bps,omitempty"` // 每个连接的下载速率
+}
+
+// Validate 检查速率不为负数
+func (b BandwidthLimit) Validate() error {
+	if b.UploadBps < 0 || b.DownloadBps < 0 || b.ConnUploadBps < 0 || b.ConnDownloadBps < 0 {
+		return fmt.Errorf("限速不能为负数")
+	}
+	return nil
+}
+
+// ConnectionLimits 连接数限制和客户端IP访问控制，在接受连接时检查。
+// 列表项可以是CIDR或单个IP，先检查拒绝列表；允许列表非空时只接受其中的客户端
+type ConnectionLimits struct {
+	MaxConnections      int      `json:"max_connections,omitempty" yaml:"max_connections,omitempty"`               // 最大并发连接数，0不限制
+	MaxConnectionsPerIP int      `json:"max_connections_per_ip,omitempty" yaml:"max_connections_per_ip,omitempty"` // 每个客户端IP的最大并发连接数，0不限制
+	Allow               []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny                []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// Validate 检查连接数不为负数且地址列表可以解析
+func (l ConnectionLimits) Validate() error {
+	if l.MaxConnections < 0 || l.MaxConnectionsPerIP < 0 {
+		return fmt.Errorf("连接数限制不能为负数")
+	}
+	for _, entry := range append(append([]string(nil), l.Allow...), l.Deny...) {
+		if _, err := ParsePrefix(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParsePrefix 解析CIDR或单个IP，单个IP视为只包含自身的网段
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("无效的网段 %q: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("无效的IP地址 %q: %w", s, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IdleDisabled 代理的 IdleSeconds 设为此值时不启用空闲超时，即使全局默认值启用了
+const IdleDisabled = -1
+
+// Timeouts 超时和TCP保活设置，单位为秒，0表示使用默认值
+type Timeouts struct {
+	DialSeconds      int `json:"dial_seconds,omitempty" yaml:"dial_seconds,omitempty"`           // 连接上游代理的超时
+	HandshakeSeconds int `json:"handshake_seconds,omitempty" yaml:"handshake_seconds,omitempty"` // 客户端和上游协议握手的超时
+	IdleSeconds      int `json:"idle_seconds,omitempty" yaml:"idle_seconds,omitempty"`           // 隧道两个方向都没有数据时关闭的时长，IdleDisabled表示不启用
+	KeepAliveSeconds int `json:"keepalive_seconds,omitempty" yaml:"keepalive_seconds,omitempty"` // TCP保活探测间隔
+}
+
+// DefaultTimeouts 未设置全局默认值时使用的超时，空闲超时默认不启用
+var DefaultTimeouts = Timeouts{
+	DialSeconds:      30,
+	HandshakeSeconds: 30,
+	KeepAliveSeconds: 30,
+}
+
+// Validate 检查超时不为负数，空闲超时可以是IdleDisabled
+func (t Timeouts) Validate() error {
+	if t.DialSeconds < 0 || t.HandshakeSeconds < 0 || t.KeepAliveSeconds < 0 {
+		return fmt.Errorf("超时不能为负数")
+	}
+	if t.IdleSeconds < 0 && t.IdleSeconds != IdleDisabled {
+		return fmt.Errorf("空闲超时不能为负数，%d 表示不启用", IdleDisabled)
+	}
+	return nil
+}
+
+// WithDefaults 用defaults中的值补全为0的项，IdleDisabled不会被覆盖
+func (t Timeouts) WithDefaults(defaults Timeouts) Timeouts {
+	if t.DialSeconds == 0 {
+		t.DialSeconds = defaults.DialSeconds
+	}
+	if t.HandshakeSeconds == 0 {
+		t.HandshakeSeconds = defaults.HandshakeSeconds
+	}
+	if t.IdleSeconds == 0 {
+		t.IdleSeconds = defaults.IdleSeconds
+	}
+	if t.KeepAliveSeconds == 0 {
+		t.KeepAliveSeconds = defaults.KeepAliveSeconds
+	}
+	return t
 }
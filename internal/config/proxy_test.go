@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestHeaderRuleValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  HeaderRule
+		valid bool
+	}{
+		{name: "有效", rule: HeaderRule{Direction: "request", Action: "add", Name: "X-Api-Key", Value: "abc def"}, valid: true},
+		{name: "删除时不检查值", rule: HeaderRule{Direction: "response", Action: "remove", Name: "Server", Value: "a\nb"}, valid: true},
+		{name: "名称为空", rule: HeaderRule{Direction: "request", Action: "add", Name: " "}},
+		{name: "名称包含空格", rule: HeaderRule{Direction: "request", Action: "add", Name: "X Api"}},
+		{name: "名称包含冒号", rule: HeaderRule{Direction: "request", Action: "add", Name: "X-Api:"}},
+		{name: "值包含换行", rule: HeaderRule{Direction: "request", Action: "replace", Name: "X-Api", Value: "a\r\nX-Injected: 1"}},
+		{name: "值包含控制字符", rule: HeaderRule{Direction: "request", Action: "add", Name: "X-Api", Value: "a\x00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); (err == nil) != tt.valid {
+				t.Fatalf("Validate() = %v，期望有效 = %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// MasterPasswordEnv 无界面运行时提供主密码的环境变量
+const MasterPasswordEnv = "PROXYMGR_MASTER_PASSWORD"
+
+// secretPrefix 加密后的字段值以此开头，后面是base64编码的随机数和密文
+const secretPrefix = "enc:v1:"
+
+// argon2id 参数，派生一次密钥约需64MB内存
+const (
+	kdfArgon2id   = "argon2id"
+	kdfTime       = 3
+	kdfMemoryKiB  = 64 * 1024
+	kdfThreads    = 4
+	kdfSaltLength = 16
+	keyLength     = 32
+)
+
+// checkPlaintext 用密钥加密后保存在配置文件中，用于判断主密码是否正确
+const checkPlaintext = "proxy-manager-master-key"
+
+var (
+	// ErrLocked 配置文件已加密但还没有提供主密码
+	ErrLocked = errors.New("配置文件已加密，需要输入主密码")
+	// ErrWrongPassword 主密码与配置文件不匹配
+	ErrWrongPassword = errors.New("主密码错误")
+)
+
+// EncryptionInfo 配置文件中记录的加密参数，不包含密钥本身
+type EncryptionInfo struct {
+	KDF       string `json:"kdf" yaml:"kdf"`
+	Salt      string `json:"salt" yaml:"salt"` // base64
+	Time      uint32 `json:"time" yaml:"time"`
+	MemoryKiB uint32 `json:"memory_kib" yaml:"memory_kib"`
+	Threads   uint8  `json:"threads" yaml:"threads"`
+	Check     string `json:"check" yaml:"check"` // 加密的 checkPlaintext
+}
+
+// masterKey 由主密码派生的密钥和对应的加密参数
+type masterKey struct {
+	info *EncryptionInfo
+	aead cipher.AEAD
+}
+
+// newMasterKey 用新的随机盐从主密码派生密钥
+func newMasterKey(password string) (*masterKey, error) {
+	salt := make([]byte, kdfSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	info := &EncryptionInfo{
+		KDF:       kdfArgon2id,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		Time:      kdfTime,
+		MemoryKiB: kdfMemoryKiB,
+		Threads:   kdfThreads,
+	}
+	key, err := deriveKey(password, info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Check, err = key.seal(checkPlaintext); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// openMasterKey 按配置文件中的参数派生密钥，并检查主密码是否正确
+func openMasterKey(password string, info *EncryptionInfo) (*masterKey, error) {
+	key, err := deriveKey(password, info)
+	if err != nil {
+		return nil, err
+	}
+	check, err := key.open(info.Check)
+	if err != nil || subtle.ConstantTimeCompare([]byte(check), []byte(checkPlaintext)) != 1 {
+		return nil, ErrWrongPassword
+	}
+	return key, nil
+}
+
+func deriveKey(password string, info *EncryptionInfo) (*masterKey, error) {
+	if info.KDF != kdfArgon2id {
+		return nil, fmt.Errorf("不支持的密钥派生算法: %s", info.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(info.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("配置文件中的加密参数无效")
+	}
+	if info.Time == 0 || info.MemoryKiB == 0 || info.Threads == 0 {
+		return nil, fmt.Errorf("配置文件中的加密参数无效")
+	}
+	block, err := aes.NewCipher(argon2.IDKey([]byte(password), salt, info.Time, info.MemoryKiB, info.Threads, keyLength))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &masterKey{info: info, aead: aead}, nil
+}
+
+// matches 判断配置文件中的加密参数是否与密钥相同
+func (k *masterKey) matches(info *EncryptionInfo) bool {
+	return info != nil && *info == *k.info
+}
+
+// seal 加密一个字段值，每次使用新的随机数
+func (k *masterKey) seal(plaintext string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open 解密 seal 加密的字段值
+func (k *masterKey) open(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return "", fmt.Errorf("不是加密的字段")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", fmt.Errorf("加密字段格式错误")
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("无法解密字段: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncryptedSecret 判断字段值是否是加密后的形式
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// sealDocument 返回写入文件用的配置，上游密码和头部规则的值被加密。key 为空时原样返回。
+// 代理配置会被复制，内存中的配置仍是明文
+func sealDocument(doc *ConfigDocument, key *masterKey) (*ConfigDocument, error) {
+	if key == nil {
+		return doc, nil
+	}
+	sealed := *doc
+	sealed.Encryption = key.info
+	sealed.Proxies = make([]*ProxyConfig, len(doc.Proxies))
+	for i, proxy := range doc.Proxies {
+		copied := *proxy
+		if copied.Upstream.Password != "" {
+			password, err := key.seal(copied.Upstream.Password)
+			if err != nil {
+				return nil, fmt.Errorf("无法加密代理 %s 的密码: %w", proxy.Name, err)
+			}
+			copied.Upstream.Password = password
+		}
+		// 头部规则的值可能是API密钥等凭据
+		if len(proxy.HeaderRules) > 0 {
+			copied.HeaderRules = make([]HeaderRule, len(proxy.HeaderRules))
+			for j, rule := range proxy.HeaderRules {
+				if rule.Value != "" {
+					value, err := key.seal(rule.Value)
+					if err != nil {
+						return nil, fmt.Errorf("无法加密代理 %s 的头部规则: %w", proxy.Name, err)
+					}
+					rule.Value = value
+				}
+				copied.HeaderRules[j] = rule
+			}
+		}
+		sealed.Proxies[i] = &copied
+	}
+	return &sealed, nil
+}
+
+// openDocument 解密从文件读取的配置中 sealDocument 加密的字段。配置没有加密时不需要密钥；
+// 加密的配置没有密钥时返回 ErrLocked，密钥与配置的加密参数不同时返回 ErrWrongPassword
+func openDocument(doc *ConfigDocument, key *masterKey) error {
+	if doc.Encryption == nil {
+		for _, proxy := range doc.Proxies {
+			if IsEncryptedSecret(proxy.Upstream.Password) {
+				return fmt.Errorf("代理 %s 的密码已加密，但配置文件缺少 encryption 参数", proxy.Name)
+			}
+			for _, rule := range proxy.HeaderRules {
+				if IsEncryptedSecret(rule.Value) {
+					return fmt.Errorf("代理 %s 的头部规则已加密，但配置文件缺少 encryption 参数", proxy.Name)
+				}
+			}
+		}
+		return nil
+	}
+	if key == nil {
+		return ErrLocked
+	}
+	if !key.matches(doc.Encryption) {
+		return ErrWrongPassword
+	}
+	// 没有加密的值是手动编辑时填写的明文，下次保存时会被加密
+	for _, proxy := range doc.Proxies {
+		if IsEncryptedSecret(proxy.Upstream.Password) {
+			password, err := key.open(proxy.Upstream.Password)
+			if err != nil {
+				return fmt.Errorf("代理 %s 的密码无效: %w", proxy.Name, err)
+			}
+			proxy.Upstream.Password = password
+		}
+		for i, rule := range proxy.HeaderRules {
+			if !IsEncryptedSecret(rule.Value) {
+				continue
+			}
+			value, err := key.open(rule.Value)
+			if err != nil {
+				return fmt.Errorf("代理 %s 的头部规则 %s 无效: %w", proxy.Name, rule.Name, err)
+			}
+			proxy.HeaderRules[i].Value = value
+		}
+	}
+	return nil
+}
+
+// Locked 判断配置文件是否已加密但还没有用主密码解锁
+func (cm *ConfigManager) Locked() bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.locked != nil
+}
+
+// Encrypted 判断配置文件是否设置了主密码
+func (cm *ConfigManager) Encrypted() bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.key != nil || cm.locked != nil
+}
+
+// Unlock 用主密码解锁加密的配置文件并重新加载，配置没有锁定时不做任何事
+func (cm *ConfigManager) Unlock(password string) error {
+	cm.mu.RLock()
+	info := cm.locked
+	cm.mu.RUnlock()
+	if info == nil {
+		return nil
+	}
+
+	key, err := openMasterKey(password, info)
+	if err != nil {
+		return err
+	}
+	cm.mu.Lock()
+	cm.key = key
+	cm.mu.Unlock()
+	return cm.LoadConfig()
+}
+
+// ChangeMasterPassword 设置、更换或取消主密码。已设置主密码时需要提供当前主密码，
+// next 为空表示取消加密。配置文件和已有备份都会用新密钥重写
+func (cm *ConfigManager) ChangeMasterPassword(current, next string) error {
+	cm.mu.RLock()
+	oldKey, locked := cm.key, cm.locked != nil
+	cm.mu.RUnlock()
+	if locked {
+		return ErrLocked
+	}
+	if oldKey == nil && next == "" {
+		return fmt.Errorf("还没有设置主密码")
+	}
+	if oldKey != nil {
+		if _, err := openMasterKey(current, oldKey.info); err != nil {
+			return err
+		}
+	}
+
+	var newKey *masterKey
+	if next != "" {
+		var err error
+		if newKey, err = newMasterKey(next); err != nil {
+			return fmt.Errorf("无法生成密钥: %w", err)
+		}
+	}
+
+	cm.mu.Lock()
+	if cm.key != oldKey {
+		cm.mu.Unlock()
+		return fmt.Errorf("主密码已被其他操作修改，请重试")
+	}
+	cm.key = newKey
+	cm.mu.Unlock()
+
+	if err := cm.SaveConfig(); err != nil {
+		cm.mu.Lock()
+		cm.key = oldKey
+		cm.mu.Unlock()
+		return err
+	}
+	cm.saveMu.Lock()
+	defer cm.saveMu.Unlock()
+	cm.rekeyBackups(oldKey, newKey)
+	return nil
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDocument() *ConfigDocument {
+	return &ConfigDocument{
+		Version: CurrentConfigVersion,
+		Proxies: []*ProxyConfig{
+			{
+				ID: "a", Name: "a",
+				Upstream:    UpstreamProxy{Protocol: "socks5", Address: "127.0.0.1:1080", Username: "u", Password: "secret-a"},
+				HeaderRules: []HeaderRule{{Direction: "request", Action: "add", Name: "X-Api-Key", Value: "secret-h"}},
+			},
+			{ID: "b", Name: "b", Upstream: UpstreamProxy{Protocol: "http", Address: "127.0.0.1:8080"}},
+		},
+	}
+}
+
+func TestSealAndOpenDocument(t *testing.T) {
+	key, err := newMasterKey("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := testDocument()
+
+	sealed, err := sealDocument(doc, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Proxies[0].Upstream.Password != "secret-a" || doc.Proxies[0].HeaderRules[0].Value != "secret-h" || doc.Encryption != nil {
+		t.Fatal("sealDocument 修改了内存中的配置")
+	}
+	if !IsEncryptedSecret(sealed.Proxies[0].Upstream.Password) {
+		t.Fatalf("密码没有被加密: %q", sealed.Proxies[0].Upstream.Password)
+	}
+	if !IsEncryptedSecret(sealed.Proxies[0].HeaderRules[0].Value) {
+		t.Fatalf("头部规则的值没有被加密: %q", sealed.Proxies[0].HeaderRules[0].Value)
+	}
+	if sealed.Proxies[1].Upstream.Password != "" {
+		t.Fatal("空密码不应被加密")
+	}
+	if sealed.Encryption == nil {
+		t.Fatal("缺少加密参数")
+	}
+
+	// 经过一次序列化，与从文件读取时相同
+	data, err := marshalConfigDocument(sealed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-") {
+		t.Fatal("序列化后的配置包含明文密码")
+	}
+	loaded, _, err := parseConfigDocument(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openDocument(loaded, key); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Proxies[0].Upstream.Password != "secret-a" || loaded.Proxies[0].HeaderRules[0].Value != "secret-h" {
+		t.Fatalf("解密后的密码 = %q，头部规则的值 = %q", loaded.Proxies[0].Upstream.Password, loaded.Proxies[0].HeaderRules[0].Value)
+	}
+}
+
+func TestOpenDocumentErrors(t *testing.T) {
+	key, err := newMasterKey("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealDocument(testDocument(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := openDocument(sealed, nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("没有密钥时应返回 ErrLocked，实际 %v", err)
+	}
+	other, err := newMasterKey("master") // 同一密码、不同的盐
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openDocument(sealed, other); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("密钥不匹配时应返回 ErrWrongPassword，实际 %v", err)
+	}
+	if _, err := openMasterKey("wrong", sealed.Encryption); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("主密码错误时应返回 ErrWrongPassword，实际 %v", err)
+	}
+
+	// 加密的密码缺少加密参数
+	sealed.Encryption = nil
+	if err := openDocument(sealed, key); err == nil {
+		t.Fatal("缺少 encryption 参数时应报错")
+	}
+}
+
+func TestChangeMasterPassword(t *testing.T) {
+	t.Setenv(MasterPasswordEnv, "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	cm := NewConfigManager(path)
+	if _, err := cm.AddProxy(testDocument().Proxies[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.ChangeMasterPassword("", "one"); err != nil {
+		t.Fatal(err)
+	}
+	if !cm.Encrypted() {
+		t.Fatal("设置主密码后应为加密状态")
+	}
+	assertNoPlaintext(t, path)
+
+	// 重新打开时在解锁前不加载代理，也不允许保存
+	locked := NewConfigManager(path)
+	if !locked.Locked() || len(locked.GetAllProxies()) != 0 {
+		t.Fatal("加密的配置在解锁前不应被加载")
+	}
+	if err := locked.SaveConfig(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("锁定时保存应返回 ErrLocked，实际 %v", err)
+	}
+	if err := locked.ChangeMasterPassword("one", "two"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("锁定时修改主密码应返回 ErrLocked，实际 %v", err)
+	}
+	if err := locked.Unlock("wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("主密码错误时应返回 ErrWrongPassword，实际 %v", err)
+	}
+	if err := locked.Unlock("one"); err != nil {
+		t.Fatal(err)
+	}
+	if proxies := locked.GetAllProxies(); len(proxies) != 1 || proxies[0].Upstream.Password != "secret-a" {
+		t.Fatal("解锁后没有得到解密的配置")
+	}
+
+	// 更换主密码：当前主密码错误时不修改
+	if err := cm.ChangeMasterPassword("wrong", "two"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("当前主密码错误时应返回 ErrWrongPassword，实际 %v", err)
+	}
+	if err := cm.ChangeMasterPassword("one", "two"); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, path)
+	reopened := NewConfigManager(path)
+	if err := reopened.Unlock("one"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("旧主密码不应再能解锁，实际 %v", err)
+	}
+	if err := reopened.Unlock("two"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 备份用新密钥重写，可以直接恢复
+	backups, err := cm.ListBackups()
+	if err != nil || len(backups) == 0 {
+		t.Fatalf("应有备份: %v", err)
+	}
+	for _, backup := range backups {
+		assertNoPlaintext(t, filepath.Join(cm.backupDir(), backup.Name))
+	}
+	if err := cm.RestoreBackup(backups[len(backups)-1].Name); err != nil {
+		t.Fatal(err)
+	}
+
+	// 取消主密码后恢复为明文
+	if err := cm.ChangeMasterPassword("two", ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "secret-a") || cm.Encrypted() {
+		t.Fatal("取消主密码后配置应为明文")
+	}
+}
+
+func assertNoPlaintext(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-") {
+		t.Fatalf("%s 包含明文密码", filepath.Base(path))
+	}
+}
+
+func TestChangeMasterPasswordRekeysUpgradeBackup(t *testing.T) {
+	t.Setenv(MasterPasswordEnv, "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	v1 := `[{"id":"a","name":"a","upstream":{"protocol":"socks5","address":"127.0.0.1:1080","password":"secret-a"},` +
+		`"local":{"protocol":"http","listen_ip":"127.0.0.1","listen_port":8001}}]`
+	if err := os.WriteFile(path, []byte(v1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cm := NewConfigManager(path)
+	matches, err := filepath.Glob(filepath.Join(dir, "backups", "config.v1-*.json"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("应有一个升级前的备份: %v", matches)
+	}
+
+	if err := cm.ChangeMasterPassword("", "one"); err != nil {
+		t.Fatal(err)
+	}
+	assertNoPlaintext(t, matches[0])
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _, err := parseConfigDocument(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := openMasterKey("one", doc.Encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openDocument(doc, key); err != nil || doc.Proxies[0].Upstream.Password != "secret-a" {
+		t.Fatalf("升级前的备份无法用新主密码解密: %v", err)
+	}
+}
+
+func TestEncryptedSaveSkipsUnchangedBackup(t *testing.T) {
+	t.Setenv(MasterPasswordEnv, "")
+	cm := NewConfigManager(filepath.Join(t.TempDir(), "config.json"))
+	id, err := cm.AddProxy(testDocument().Proxies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.ChangeMasterPassword("", "one"); err != nil {
+		t.Fatal(err)
+	}
+	countBackups := func() int {
+		backups, err := cm.ListBackups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(backups)
+	}
+	before := countBackups()
+
+	// 每次加密使用新的随机数，文件内容不同但配置没有变化
+	if err := cm.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// 启停代理时只改变 auto_start
+	if err := cm.UpdateProxyAutoStart(id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBackups(); n != before {
+		t.Fatalf("配置内容没有变化，备份数 %d → %d", before, n)
+	}
+
+	if _, err := cm.AddProxy(testDocument().Proxies[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBackups(); n != before+1 {
+		t.Fatalf("修改配置后备份数 %d → %d", before, n)
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ParseUpstreamURL 解析上游代理链接，支持 http://、socks5:// 和 ss://
+// 返回上游配置以及链接中携带的名称（如 ss:// 的 #备注）
+func ParseUpstreamURL(raw string) (UpstreamProxy, string, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(strings.ToLower(raw), "ss://") {
+		return parseShadowsocksURL(raw[len("ss://"):])
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return UpstreamProxy{}, "", fmt.Errorf("无法解析代理链接: %w", err)
+	}
+
+	var upstream UpstreamProxy
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		upstream.Protocol = "http"
+	case "socks5", "socks5h":
+		upstream.Protocol = "socks5"
+	default:
+		return UpstreamProxy{}, "", fmt.Errorf("不支持的代理链接协议: %s", u.Scheme)
+	}
+
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return UpstreamProxy{}, "", fmt.Errorf("代理链接缺少端口: %s", u.Host)
+	}
+	upstream.Address = u.Host
+
+	if u.User != nil {
+		upstream.Username = u.User.Username()
+		upstream.Password, _ = u.User.Password()
+		upstream.AuthMethod = "basic"
+	}
+
+	return upstream, u.Fragment, nil
+}
+
+// parseShadowsocksURL 解析 SIP002 格式以及旧版整体base64编码的 ss:// 链接
+func parseShadowsocksURL(rest string) (UpstreamProxy, string, error) {
+	var name string
+	if i := strings.IndexByte(rest, '#'); i >= 0 {
+		tag, err := url.PathUnescape(rest[i+1:])
+		if err != nil {
+			tag = rest[i+1:]
+		}
+		name = tag
+		rest = rest[:i]
+	}
+
+	// 旧版格式: ss://base64(method:password@host:port)
+	if !strings.Contains(rest, "@") {
+		decoded, ok := decodeBase64Loose(rest)
+		if !ok {
+			return UpstreamProxy{}, "", fmt.Errorf("无法解码ss链接")
+		}
+		rest = decoded
+	}
+
+	at := strings.LastIndexByte(rest, '@')
+	if at < 0 {
+		return UpstreamProxy{}, "", fmt.Errorf("ss链接缺少服务器地址")
+	}
+	userInfo, hostPart := rest[:at], rest[at+1:]
+
+	// SIP002 中 host:port 后可能带有 /?plugin=... 参数
+	if i := strings.IndexAny(hostPart, "/?"); i >= 0 {
+		query := hostPart[i:]
+		hostPart = hostPart[:i]
+		if q := strings.IndexByte(query, '?'); q >= 0 {
+			values, err := url.ParseQuery(query[q+1:])
+			if err == nil && values.Get("plugin") != "" {
+				return UpstreamProxy{}, "", fmt.Errorf("不支持shadowsocks插件: %s", values.Get("plugin"))
+			}
+		}
+	}
+	if _, _, err := net.SplitHostPort(hostPart); err != nil {
+		return UpstreamProxy{}, "", fmt.Errorf("ss链接服务器地址无效: %s", hostPart)
+	}
+
+	// SIP002 的用户信息可能是 base64url(method:password)，
+	// 2022 系列加密方式则使用 URL 编码的明文 method:password
+	method, password, ok := strings.Cut(userInfo, ":")
+	if decoded, isBase64 := decodeBase64Loose(userInfo); isBase64 && strings.Contains(decoded, ":") {
+		method, password, ok = strings.Cut(decoded, ":")
+	} else if ok {
+		if m, err := url.PathUnescape(method); err == nil {
+			method = m
+		}
+		if p, err := url.PathUnescape(password); err == nil {
+			password = p
+		}
+	}
+	if !ok {
+		return UpstreamProxy{}, "", fmt.Errorf("ss链接缺少加密方式或密码")
+	}
+
+	method = strings.ToLower(method)
+	if !IsShadowsocksCipher(method) {
+		return UpstreamProxy{}, "", fmt.Errorf("不支持的shadowsocks加密方式: %s", method)
+	}
+
+	return UpstreamProxy{
+		Protocol: "shadowsocks",
+		Address:  hostPart,
+		Password: password,
+		Cipher:   method,
+	}, name, nil
+}
+
+// decodeBase64Loose 依次尝试带/不带填充的标准及URL安全base64编码
+func decodeBase64Loose(s string) (string, bool) {
+	encodings := []*base64.Encoding{
+		base64.RawURLEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.StdEncoding,
+	}
+	for _, enc := range encodings {
+		if b, err := enc.DecodeString(s); err == nil {
+			return string(b), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// 字段校验错误的类型
+const (
+	CodeRequired    = "required"     // 必填项为空
+	CodeInvalid     = "invalid"      // 格式错误
+	CodeOutOfRange  = "out_of_range" // 数值超出范围
+	CodeUnsupported = "unsupported"  // 不支持的取值
+	CodeDuplicate   = "duplicate"    // 与其他代理冲突
+	CodeInUse       = "in_use"       // 端口已被其他程序占用
+)
+
+// FieldError 一个字段的校验错误
+type FieldError struct {
+	Field   string `json:"field"` // 字段路径，与JSON字段名一致，如 upstream.address、header_rules[0]
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError 一个或多个字段的校验错误
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return "配置无效: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, code, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// addErr 把已有校验函数返回的错误记为字段错误
+func (e *ValidationError) addErr(field string, err error) {
+	if err != nil {
+		e.add(field, CodeInvalid, "%v", err)
+	}
+}
+
+// err 没有错误时返回nil，避免返回包含nil指针的error
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+var (
+	upstreamProtocols = []string{"http", "socks5", "shadowsocks"}
+	localProtocols    = []string{"http", "socks5"}
+	authMethods       = []string{"", "basic", "digest", "ntlm"}
+	transportTypes    = []string{"", "tcp", "ws", "h2"}
+)
+
+func oneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 检查代理的协议、地址、端口以及头部规则、配额、带宽限制、连接限制和超时设置，
+// 错误为 *ValidationError
+func (p *ProxyConfig) Validate() error {
+	v := &ValidationError{}
+	p.validate(v, "")
+	return v.err()
+}
+
+// validate 把错误记录到v中，字段路径加上prefix前缀
+func (p *ProxyConfig) validate(v *ValidationError, prefix string) {
+	up := p.Upstream
+	if up.Protocol == "" {
+		v.add(prefix+"upstream.protocol", CodeRequired, "上游协议不能为空")
+	} else if !oneOf(up.Protocol, upstreamProtocols) {
+		v.add(prefix+"upstream.protocol", CodeUnsupported, "不支持的上游协议 %q，可选 %s", up.Protocol, strings.Join(upstreamProtocols, "、"))
+	}
+	validateHostPort(v, prefix+"upstream.address", up.Address)
+	if !oneOf(up.AuthMethod, authMethods) {
+		v.add(prefix+"upstream.auth_method", CodeUnsupported, "不支持的认证方式 %q", up.AuthMethod)
+	}
+	if up.Protocol == "shadowsocks" {
+		if up.Cipher == "" {
+			v.add(prefix+"upstream.cipher", CodeRequired, "shadowsocks需要选择加密方式")
+		} else if !IsShadowsocksCipher(up.Cipher) {
+			v.add(prefix+"upstream.cipher", CodeUnsupported, "不支持的加密方式 %q", up.Cipher)
+		}
+		if up.Password == "" {
+			v.add(prefix+"upstream.password", CodeRequired, "shadowsocks需要密码")
+		}
+	}
+	if up.Transport != nil && !oneOf(up.Transport.Type, transportTypes) {
+		v.add(prefix+"upstream.transport.type", CodeUnsupported, "不支持的传输方式 %q，可选 tcp、ws、h2", up.Transport.Type)
+	}
+
+	local := p.Local
+	if local.Protocol == "" {
+		v.add(prefix+"local.protocol", CodeRequired, "本地协议不能为空")
+	} else if !oneOf(local.Protocol, localProtocols) {
+		v.add(prefix+"local.protocol", CodeUnsupported, "不支持的本地协议 %q，可选 http、socks5", local.Protocol)
+	}
+	if local.ListenIP == "" {
+		v.add(prefix+"local.listen_ip", CodeRequired, "监听IP不能为空")
+	} else if _, err := netip.ParseAddr(local.ListenIP); err != nil && local.ListenIP != "localhost" {
+		v.add(prefix+"local.listen_ip", CodeInvalid, "无效的监听IP %q", local.ListenIP)
+	}
+	if local.ListenPort < 1 || local.ListenPort > 65535 {
+		v.add(prefix+"local.listen_port", CodeOutOfRange, "端口必须在1到65535之间: %d", local.ListenPort)
+	}
+
+	for i, rule := range p.HeaderRules {
+		v.addErr(fmt.Sprintf("%sheader_rules[%d]", prefix, i), rule.Validate())
+	}
+	v.addErr(prefix+"quota", ValidateQuota(p.Quota))
+	v.addErr(prefix+"bandwidth", ValidateBandwidthLimit(p.Bandwidth))
+	v.addErr(prefix+"limits", ValidateConnectionLimits(p.Limits))
+	v.addErr(prefix+"timeouts", ValidateTimeouts(p.Timeouts))
+}
+
+// validateHostPort 检查 host:port 格式的地址
+func validateHostPort(v *ValidationError, field, address string) {
+	if address == "" {
+		v.add(field, CodeRequired, "地址不能为空")
+		return
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		v.add(field, CodeInvalid, "地址应为 主机:端口 格式，IPv6地址需要加方括号: %q", address)
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		v.add(field, CodeInvalid, "无效的端口 %q", portStr)
+	} else if port < 1 || port > 65535 {
+		v.add(field, CodeOutOfRange, "端口必须在1到65535之间: %d", port)
+	}
+}
+
+// listenConflicts 判断两个本地监听地址是否冲突，监听所有地址时与同端口的其他地址冲突
+func listenConflicts(a, b LocalProxy) bool {
+	if a.ListenPort != b.ListenPort {
+		return false
+	}
+	if a.ListenIP == b.ListenIP {
+		return true
+	}
+	ipA, errA := netip.ParseAddr(a.ListenIP)
+	ipB, errB := netip.ParseAddr(b.ListenIP)
+	return errA == nil && ipA.IsUnspecified() || errB == nil && ipB.IsUnspecified()
+}
+
+// CheckListenAddr 尝试监听本地地址，检查端口在本机是否可用
+func CheckListenAddr(ip string, port int) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// ValidateProxy 校验代理配置，并检查本地监听地址是否与其他代理重复。
+// checkPort 为 true 时还检查端口在本机是否可以监听，本地地址没有变化的运行中代理不应检查
+func (cm *ConfigManager) ValidateProxy(proxy *ProxyConfig, checkPort bool) error {
+	v := &ValidationError{}
+	proxy.validate(v, "")
+	cm.checkDuplicateListen(v, "", proxy, nil)
+	if checkPort && len(v.Fields) == 0 {
+		if err := CheckListenAddr(proxy.Local.ListenIP, proxy.Local.ListenPort); err != nil {
+			v.add("local.listen_port", CodeInUse, "端口 %d 无法监听，可能已被其他程序占用: %v", proxy.Local.ListenPort, err)
+		}
+	}
+	return v.err()
+}
+
+// checkDuplicateListen 检查本地监听地址是否与已有代理或 pending 中的代理冲突
+func (cm *ConfigManager) checkDuplicateListen(v *ValidationError, prefix string, proxy *ProxyConfig, pending []*ProxyConfig) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	cm.checkDuplicateListenLocked(v, prefix, proxy, pending)
+}
+
+// checkDuplicateListenLocked 同 checkDuplicateListen，调用方需持有锁
+func (cm *ConfigManager) checkDuplicateListenLocked(v *ValidationError, prefix string, proxy *ProxyConfig, pending []*ProxyConfig) {
+	others := append([]*ProxyConfig{}, pending...)
+	for _, id := range cm.proxyOrder {
+		others = append(others, cm.proxies[id])
+	}
+	for _, other := range others {
+		if other == proxy || other.ID != "" && other.ID == proxy.ID {
+			continue
+		}
+		if listenConflicts(proxy.Local, other.Local) {
+			v.add(prefix+"local.listen_port", CodeDuplicate, "本地地址 %s 已被代理 %q 使用",
+				net.JoinHostPort(proxy.Local.ListenIP, strconv.Itoa(proxy.Local.ListenPort)), other.Name)
+			return
+		}
+	}
+}
+
+// ValidateHeaderRules 校验头部改写规则
+func ValidateHeaderRules(rules []HeaderRule) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("第%d条头部规则无效: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ValidateQuota 校验流量配额，为空表示不限制
+func ValidateQuota(quota *TrafficQuota) error {
+	if quota == nil {
+		return nil
+	}
+	if err := quota.Validate(); err != nil {
+		return fmt.Errorf("流量配额无效: %w", err)
+	}
+	return nil
+}
+
+// ValidateBandwidthLimit 校验带宽限制，为空表示不限速
+func ValidateBandwidthLimit(limit *BandwidthLimit) error {
+	if limit == nil {
+		return nil
+	}
+	if err := limit.Validate(); err != nil {
+		return fmt.Errorf("带宽限制无效: %w", err)
+	}
+	return nil
+}
+
+// ValidateConnectionLimits 校验连接数限制和访问控制列表，为空表示不限制
+func ValidateConnectionLimits(limits *ConnectionLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if err := limits.Validate(); err != nil {
+		return fmt.Errorf("连接限制无效: %w", err)
+	}
+	return nil
+}
+
+// ValidateTimeouts 校验超时设置，为空表示使用全局默认值
+func ValidateTimeouts(timeouts *Timeouts) error {
+	if timeouts == nil {
+		return nil
+	}
+	if err := timeouts.Validate(); err != nil {
+		return fmt.Errorf("超时设置无效: %w", err)
+	}
+	return nil
+}
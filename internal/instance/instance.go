@@ -0,0 +1,220 @@
+// Package instance 保证同一配置目录只有一个实例在运行。
+// 先启动的实例持有锁文件并在本机端口上接收命令，后启动的实例把命令行参数转发给它后退出。
+package instance
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	lockFileName = "instance.lock"
+	infoFileName = "instance.json"
+)
+
+// ErrRunning 已有实例持有同一配置目录的锁
+var ErrRunning = errors.New("已有实例正在运行")
+
+// Handler 处理转发来的命令行参数，返回的错误会回复给转发的实例
+type Handler func(args []string) error
+
+// Instance 持有锁的实例
+type Instance struct {
+	dir      string
+	lockFile *os.File
+	listener net.Listener
+	token    string
+
+	mu      sync.Mutex
+	handler Handler
+	ready   chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// info 写在配置目录中，供后启动的实例连接
+type info struct {
+	PID   int    `json:"pid"`
+	Addr  string `json:"addr"`
+	Token string `json:"token"`
+}
+
+type request struct {
+	Token string   `json:"token"`
+	Args  []string `json:"args"`
+}
+
+type response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Lock 获取配置目录的实例锁并开始监听，已有实例运行时返回 ErrRunning。
+// 在调用 Serve 之前收到的命令会等待处理
+func Lock(dir string) (*Instance, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("无法创建配置目录: %w", err)
+	}
+	lockFile, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开锁文件: %w", err)
+	}
+	if err := lockFileExclusive(lockFile); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("无法监听实例端口: %w", err)
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		listener.Close()
+		lockFile.Close()
+		return nil, fmt.Errorf("无法生成实例令牌: %w", err)
+	}
+
+	inst := &Instance{
+		dir:      dir,
+		lockFile: lockFile,
+		listener: listener,
+		token:    hex.EncodeToString(token),
+		ready:    make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	data, _ := json.Marshal(info{PID: os.Getpid(), Addr: listener.Addr().String(), Token: inst.token})
+	if err := os.WriteFile(filepath.Join(dir, infoFileName), data, 0600); err != nil {
+		inst.Close()
+		return nil, fmt.Errorf("无法写入实例信息: %w", err)
+	}
+
+	go inst.acceptLoop()
+	return inst, nil
+}
+
+// Serve 设置命令处理函数，开始处理转发来的命令
+func (i *Instance) Serve(handler Handler) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.handler != nil {
+		return
+	}
+	i.handler = handler
+	close(i.ready)
+}
+
+// Close 停止接收命令并释放锁
+func (i *Instance) Close() {
+	if i == nil {
+		return
+	}
+	i.once.Do(func() {
+		close(i.closed)
+		i.listener.Close()
+		os.Remove(filepath.Join(i.dir, infoFileName))
+		unlockFile(i.lockFile)
+		i.lockFile.Close()
+	})
+}
+
+func (i *Instance) acceptLoop() {
+	for {
+		conn, err := i.listener.Accept()
+		if err != nil {
+			return
+		}
+		go i.handle(conn)
+	}
+}
+
+func (i *Instance) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var req request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(i.token)) != 1 {
+		json.NewEncoder(conn).Encode(response{Error: "无效的实例令牌"})
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// 应用启动完成前等待，退出时直接断开
+	select {
+	case <-i.ready:
+	case <-i.closed:
+		return
+	}
+	i.mu.Lock()
+	handler := i.handler
+	i.mu.Unlock()
+
+	var resp response
+	if err := handler(req.Args); err != nil {
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// Forward 把命令行参数发送给持有锁的实例并等待处理结果。
+// 对方刚启动、还没有写入实例信息时会重试一段时间
+func Forward(dir string, args []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for {
+		lastErr = forward(dir, args, deadline)
+		if lastErr == nil || !errors.Is(lastErr, errUnreachable) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return lastErr
+}
+
+var errUnreachable = errors.New("无法连接正在运行的实例")
+
+func forward(dir string, args []string, deadline time.Time) error {
+	data, err := os.ReadFile(filepath.Join(dir, infoFileName))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	var inf info
+	if err := json.Unmarshal(data, &inf); err != nil {
+		return fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+
+	conn, err := net.DialTimeout("tcp", inf.Addr, time.Until(deadline))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if args == nil {
+		args = []string{}
+	}
+	if err := json.NewEncoder(conn).Encode(request{Token: inf.Token, Args: args}); err != nil {
+		return fmt.Errorf("发送命令失败: %w", err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("读取处理结果失败: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
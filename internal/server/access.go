@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"proxy-manager-desktop/internal/accesslog"
+	"proxy-manager-desktop/internal/config"
+)
+
+// accessRules 解析后的连接数限制和访问控制列表
+type accessRules struct {
+	maxConns      int
+	maxConnsPerIP int
+	allow         []netip.Prefix
+	deny          []netip.Prefix
+}
+
+func compileAccessRules(limits *config.ConnectionLimits) *accessRules {
+	rules := &accessRules{}
+	if limits == nil {
+		return rules
+	}
+	rules.maxConns = limits.MaxConnections
+	rules.maxConnsPerIP = limits.MaxConnectionsPerIP
+	for _, entry := range limits.Allow {
+		// 配置在保存时已校验，这里跳过无法解析的项
+		if prefix, err := config.ParsePrefix(entry); err == nil {
+			rules.allow = append(rules.allow, prefix)
+		}
+	}
+	for _, entry := range limits.Deny {
+		if prefix, err := config.ParsePrefix(entry); err == nil {
+			rules.deny = append(rules.deny, prefix)
+		}
+	}
+	return rules
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessControl 在接受连接时检查客户端地址和并发连接数。
+// 规则可以在运行时替换，只影响之后接受的连接
+type accessControl struct {
+	rules atomic.Pointer[accessRules]
+
+	mu     sync.Mutex
+	active int
+	perIP  map[netip.Addr]int
+}
+
+func (a *accessControl) set(limits *config.ConnectionLimits) {
+	a.rules.Store(compileAccessRules(limits))
+}
+
+// admit 检查是否接受来自addr的连接，接受时占用一个连接名额，拒绝时返回原因
+func (a *accessControl) admit(addr net.Addr) (netip.Addr, string) {
+	var ip netip.Addr
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		ip = tcpAddr.AddrPort().Addr().Unmap()
+	}
+
+	rules := a.rules.Load()
+	if rules == nil {
+		rules = &accessRules{}
+	}
+	if ip.IsValid() {
+		if prefixesContain(rules.deny, ip) {
+			return ip, "客户端地址在拒绝列表中"
+		}
+		if len(rules.allow) > 0 && !prefixesContain(rules.allow, ip) {
+			return ip, "客户端地址不在允许列表中"
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rules.maxConns > 0 && a.active >= rules.maxConns {
+		return ip, fmt.Sprintf("已达到最大连接数 %d", rules.maxConns)
+	}
+	if rules.maxConnsPerIP > 0 && a.perIP[ip] >= rules.maxConnsPerIP {
+		return ip, fmt.Sprintf("该客户端已达到最大连接数 %d", rules.maxConnsPerIP)
+	}
+	if a.perIP == nil {
+		a.perIP = make(map[netip.Addr]int)
+	}
+	a.active++
+	a.perIP[ip]++
+	return ip, ""
+}
+
+// release 归还admit占用的连接名额
+func (a *accessControl) release(ip netip.Addr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.active--
+	if a.perIP[ip]--; a.perIP[ip] <= 0 {
+		delete(a.perIP, ip)
+	}
+}
+
+// limitListener 在Accept时执行访问控制并设置TCP保活，被拒绝的连接直接关闭，不交给代理处理
+type limitListener struct {
+	net.Listener
+	name     string
+	registry *connRegistry
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	r := l.registry
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, reason := r.access.admit(conn.RemoteAddr())
+		if reason == "" {
+			r.currentTimeouts().setKeepAlive(conn)
+			return &limitedConn{Conn: conn, ip: ip, access: &r.access}, nil
+		}
+		r.counters.connsRejected.Add(1)
+		fmt.Printf("%s 拒绝来自 %s 的连接: %s\n", l.name, conn.RemoteAddr(), reason)
+		r.logAccess(accesslog.Entry{Client: conn.RemoteAddr().String(), Outcome: accesslog.OutcomeRejected, Error: reason}, nil)
+		conn.Close()
+	}
+}
+
+// limitedConn 关闭时归还连接名额，重复关闭只归还一次
+type limitedConn struct {
+	net.Conn
+	ip      netip.Addr
+	access  *accessControl
+	release sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.release.Do(func() { c.access.release(c.ip) })
+	return c.Conn.Close()
+}
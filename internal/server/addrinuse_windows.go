@@ -0,0 +1,15 @@
+//go:build windows
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+// wsaeaddrinuse Windows套接字的 WSAEADDRINUSE 错误码
+const wsaeaddrinuse = syscall.Errno(10048)
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, wsaeaddrinuse) || errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultConfigWatchInterval 检查配置文件是否被修改的间隔
+const DefaultConfigWatchInterval = 2 * time.Second
+
+// ConfigReloadObserver 配置文件被其他程序修改并重新加载后调用，errs 为重新加载中的错误
+type ConfigReloadObserver func(errs []error)
+
+// WatchConfig 定期检查配置文件，被手动编辑或其他程序修改后重新加载并调整运行中的代理。
+// 本程序保存配置不会触发重新加载
+func (m *ProxyManager) WatchConfig(interval time.Duration, observer ConfigReloadObserver) error {
+	m.mu.Lock()
+	if m.watchStop != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("已在监视配置文件")
+	}
+	m.watchStop = make(chan struct{})
+	m.watchDone = make(chan struct{})
+	stop, done := m.watchStop, m.watchDone
+	m.mu.Unlock()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// 文件暂时不存在（如编辑器先删除再写入）时等下次检查
+				changed, err := m.configManager.FileChanged()
+				if err != nil || !changed {
+					continue
+				}
+				fmt.Println("配置文件已被修改，重新加载")
+				errs := m.ReloadConfig()
+				for _, err := range errs {
+					fmt.Printf("重新加载配置: %v\n", err)
+				}
+				if observer != nil {
+					observer(errs)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopWatchingConfig 停止监视配置文件
+func (m *ProxyManager) StopWatchingConfig() {
+	m.mu.Lock()
+	stop, done := m.watchStop, m.watchDone
+	m.watchStop, m.watchDone = nil, nil
+	m.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
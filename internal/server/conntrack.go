@@ -0,0 +1,433 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy-manager-desktop/internal/accesslog"
+	"proxy-manager-desktop/internal/config"
+)
+
+// connSeq 连接编号，在所有代理之间唯一
+var connSeq atomic.Uint64
+
+// ConnectionInfo 活动连接的快照
+type ConnectionInfo struct {
+	ID        string    `json:"id"`
+	ProxyID   string    `json:"proxy_id"`
+	Client    string    `json:"client"`   // 客户端地址
+	Target    string    `json:"target"`   // 目标 host:port，握手完成前为空
+	Upstream  string    `json:"upstream"` // 使用的上游，如 socks5://1.2.3.4:1080
+	StartedAt time.Time `json:"started_at"`
+	BytesUp   int64     `json:"bytes_up"`   // 客户端发往目标的字节数
+	BytesDown int64     `json:"bytes_down"` // 目标返回客户端的字节数
+}
+
+// ConnectionObserver 接收连接打开(opened)和关闭(closed)事件
+type ConnectionObserver func(event string, info ConnectionInfo)
+
+// trackedConn 一条被跟踪的客户端连接，字节计数在转发时实时更新
+type trackedConn struct {
+	id         string
+	conn       net.Conn
+	client     string
+	target     string
+	startedAt  time.Time
+	bytesUp    atomic.Int64
+	bytesDown  atomic.Int64
+	counters   *TrafficCounters  // 所属代理的累计计数
+	bandwidth  *bandwidthLimiter // 所属代理的带宽限制
+	upBucket   tokenBucket       // 单个连接的上传令牌桶
+	downBucket tokenBucket       // 单个连接的下载令牌桶
+	lastActive atomic.Int64      // 最后一次转发数据的时间(UnixNano)，用于空闲超时
+	done       chan struct{}     // 连接关闭时关闭，结束限速等待
+	closeOnce  sync.Once
+
+	resultMu sync.Mutex
+	outcome  string // 访问日志中的结果，为空表示正常结束
+	err      error
+}
+
+// finish 记录连接的结果，只有第一次调用生效，
+// 这样主动关闭连接的原因不会被随后转发时读到的错误覆盖
+func (tc *trackedConn) finish(outcome string, err error) {
+	tc.resultMu.Lock()
+	defer tc.resultMu.Unlock()
+	if tc.outcome == "" {
+		tc.outcome = outcome
+		tc.err = err
+	}
+}
+
+// close 关闭客户端连接，并让正在限速等待的转发立即返回
+func (tc *trackedConn) close() {
+	tc.closeOnce.Do(func() { close(tc.done) })
+	tc.conn.Close()
+}
+
+// finishRelay 按转发返回的错误记录结果，连接被关闭引起的错误视为正常结束
+func (tc *trackedConn) finishRelay(err error) {
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		tc.finish(accesslog.OutcomeOK, nil)
+		return
+	}
+	tc.finish(accesslog.OutcomeRelayError, err)
+}
+
+// connTracker 记录代理正在处理的客户端连接，用于连接列表、断开单个连接，
+// 以及停止时等待连接结束或强制关闭
+type connTracker struct {
+	proxyID   string
+	proxyName string
+	upstream  string
+	observer  ConnectionObserver
+	counters  *TrafficCounters
+	bandwidth *bandwidthLimiter
+	accessLog *accesslog.Logger
+
+	// ctx 在停止结束或强制关闭时取消，正在进行的上游拨号和握手随之中断
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	conns  map[string]*trackedConn
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newConnTracker(proxy *config.ProxyConfig, observer ConnectionObserver, counters *TrafficCounters, bandwidth *bandwidthLimiter, accessLog *accesslog.Logger) *connTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connTracker{
+		proxyID:   proxy.ID,
+		proxyName: proxy.Name,
+		upstream:  proxy.Upstream.Protocol + "://" + proxy.Upstream.Address,
+		observer:  observer,
+		counters:  counters,
+		bandwidth: bandwidth,
+		accessLog: accessLog,
+		ctx:       ctx,
+		cancel:    cancel,
+		conns:     make(map[string]*trackedConn),
+	}
+}
+
+// add 开始跟踪一个连接，已强制关闭的跟踪器会立即关闭新加入的连接并返回nil
+func (t *connTracker) add(conn net.Conn) *trackedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		conn.Close()
+		return nil
+	}
+	tc := &trackedConn{
+		id:        strconv.FormatUint(connSeq.Add(1), 10),
+		conn:      conn,
+		client:    conn.RemoteAddr().String(),
+		startedAt: time.Now(),
+		counters:  t.counters,
+		bandwidth: t.bandwidth,
+		done:      make(chan struct{}),
+	}
+	t.conns[tc.id] = tc
+	t.counters.connsTotal.Add(1)
+	t.counters.connsActive.Add(1)
+	return tc
+}
+
+// setTarget 记录连接的目标地址，此时才通知连接已打开
+func (t *connTracker) setTarget(tc *trackedConn, target string) {
+	t.mu.Lock()
+	tc.target = target
+	info := t.infoLocked(tc)
+	t.mu.Unlock()
+
+	t.notify("opened", info)
+}
+
+// countUp 返回统计并限制客户端发往目标字节数的Reader，同时计入连接和代理
+func (tc *trackedConn) countUp(r io.Reader) *meteredReader {
+	return &meteredReader{r: r, tc: tc, upload: true}
+}
+
+// countDown 返回统计并限制目标返回客户端字节数的Reader
+func (tc *trackedConn) countDown(r io.Reader) *meteredReader {
+	return &meteredReader{r: r, tc: tc}
+}
+
+func (tc *trackedConn) touch() {
+	tc.lastActive.Store(time.Now().UnixNano())
+}
+
+func (tc *trackedConn) lastActiveTime() time.Time {
+	return time.Unix(0, tc.lastActive.Load())
+}
+
+func (t *connTracker) remove(tc *trackedConn) {
+	t.mu.Lock()
+	_, exists := t.conns[tc.id]
+	delete(t.conns, tc.id)
+	info := t.infoLocked(tc)
+	t.mu.Unlock()
+
+	if exists {
+		t.counters.connsActive.Add(-1)
+	}
+	if exists && info.Target != "" {
+		t.notify("closed", info)
+	}
+	if exists {
+		tc.resultMu.Lock()
+		outcome, err := tc.outcome, tc.err
+		tc.resultMu.Unlock()
+		if outcome == "" {
+			outcome = accesslog.OutcomeOK
+		}
+		t.logAccess(accesslog.Entry{
+			Client:     info.Client,
+			Target:     info.Target,
+			BytesUp:    info.BytesUp,
+			BytesDown:  info.BytesDown,
+			DurationMs: time.Since(info.StartedAt).Milliseconds(),
+			Outcome:    outcome,
+		}, err)
+	}
+}
+
+// logAccess 补全代理信息和错误后写入访问日志
+func (t *connTracker) logAccess(entry accesslog.Entry, err error) {
+	if t.accessLog == nil {
+		return
+	}
+	entry.ProxyID = t.proxyID
+	entry.ProxyName = t.proxyName
+	entry.Upstream = t.upstream
+	if err != nil {
+		entry.ErrorClass = accesslog.ClassifyError(err)
+		entry.Error = err.Error()
+	}
+	t.accessLog.Log(entry)
+}
+
+// fail 记录一次未能建立到目标的连接
+func (t *connTracker) fail() {
+	t.counters.connsFailed.Add(1)
+}
+
+func (t *connTracker) notify(event string, info ConnectionInfo) {
+	if t.observer != nil {
+		t.observer(event, info)
+	}
+}
+
+func (t *connTracker) infoLocked(tc *trackedConn) ConnectionInfo {
+	return ConnectionInfo{
+		ID:        tc.id,
+		ProxyID:   t.proxyID,
+		Client:    tc.client,
+		Target:    tc.target,
+		Upstream:  t.upstream,
+		StartedAt: tc.startedAt,
+		BytesUp:   tc.bytesUp.Load(),
+		BytesDown: tc.bytesDown.Load(),
+	}
+}
+
+// list 返回所有活动连接的快照
+func (t *connTracker) list() []ConnectionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]ConnectionInfo, 0, len(t.conns))
+	for _, tc := range t.conns {
+		infos = append(infos, t.infoLocked(tc))
+	}
+	return infos
+}
+
+// closeConn 断开指定连接，连接的处理goroutine随后会自行清理
+func (t *connTracker) closeConn(id string) bool {
+	t.mu.Lock()
+	tc, exists := t.conns[id]
+	t.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+	tc.finish(accesslog.OutcomeClosed, nil)
+	tc.close()
+	return true
+}
+
+// closeAll 强制关闭所有连接，之后加入的连接也会被立即关闭
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	t.cancel()
+	for _, tc := range t.conns {
+		tc.finish(accesslog.OutcomeClosed, nil)
+		tc.close()
+	}
+	return len(t.conns)
+}
+
+// wait 等待所有处理连接的goroutine结束，ctx到期时返回ctx的错误
+func (t *connTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain 等待连接在ctx到期前自然结束，超时后强制关闭剩余连接，返回被强制关闭的连接数
+func (t *connTracker) drain(ctx context.Context) (int, error) {
+	defer t.cancel()
+	if err := t.wait(ctx); err != nil {
+		return t.closeAll(), err
+	}
+	return 0, nil
+}
+
+// connRegistry 嵌入到代理中，持有当前这次运行的连接跟踪器
+type connRegistry struct {
+	tracker   atomic.Pointer[connTracker]
+	observer  ConnectionObserver
+	counters  *TrafficCounters
+	blocked   atomic.Bool
+	bandwidth bandwidthLimiter
+	access    accessControl
+	accessLog *accesslog.Logger
+	h2        h2Pool
+	timeoutHolder
+}
+
+// SetBandwidthLimit 替换带宽限制，运行中立即生效
+func (r *connRegistry) SetBandwidthLimit(limit *config.BandwidthLimit) {
+	r.bandwidth.set(limit)
+}
+
+// SetConnectionLimits 替换连接数限制和访问控制，运行中对之后的新连接生效
+func (r *connRegistry) SetConnectionLimits(limits *config.ConnectionLimits) {
+	r.access.set(limits)
+}
+
+// SetBlocked 设置后代理继续监听，但拒绝所有新连接
+func (r *connRegistry) SetBlocked(blocked bool) {
+	r.blocked.Store(blocked)
+}
+
+// SetConnectionObserver 设置连接事件的接收者，需要在启动前调用
+func (r *connRegistry) SetConnectionObserver(observer ConnectionObserver) {
+	r.observer = observer
+}
+
+// SetAccessLog 设置访问日志，需要在启动前调用，为nil时不记录
+func (r *connRegistry) SetAccessLog(accessLog *accesslog.Logger) {
+	r.accessLog = accessLog
+}
+
+// SetTrafficCounters 设置累计流量的计数器，需要在启动前调用，未设置时使用代理自己的计数器
+func (r *connRegistry) SetTrafficCounters(counters *TrafficCounters) {
+	r.counters = counters
+}
+
+// resetTracker 每次启动时创建新的跟踪器
+func (r *connRegistry) resetTracker(proxy *config.ProxyConfig) *connTracker {
+	if r.counters == nil {
+		r.counters = &TrafficCounters{}
+	}
+	t := newConnTracker(proxy, r.observer, r.counters, &r.bandwidth, r.accessLog)
+	r.tracker.Store(t)
+	return t
+}
+
+// dialContext 返回连接上游使用的ctx，调用方的ctx取消或代理停止时都会取消
+func (r *connRegistry) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	t := r.tracker.Load()
+	if t == nil {
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(t.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// limitListener 包装监听器，在接受连接时执行连接数限制和访问控制
+func (r *connRegistry) limitListener(name string, listener net.Listener) net.Listener {
+	return &limitListener{Listener: listener, name: name, registry: r}
+}
+
+// logAccess 记录没有被跟踪的连接，如被拒绝或未能建立隧道的连接
+func (r *connRegistry) logAccess(entry accesslog.Entry, err error) {
+	if t := r.tracker.Load(); t != nil {
+		t.logAccess(entry, err)
+	}
+}
+
+// Connections 返回代理当前的活动连接
+func (r *connRegistry) Connections() []ConnectionInfo {
+	if t := r.tracker.Load(); t != nil {
+		return t.list()
+	}
+	return nil
+}
+
+// CloseConnection 断开指定连接，连接不存在时返回false
+func (r *connRegistry) CloseConnection(id string) bool {
+	if t := r.tracker.Load(); t != nil {
+		return t.closeConn(id)
+	}
+	return false
+}
+
+// meteredReader 统计读出的字节数并按带宽限制等待，字节数同时计入单个连接和整个代理
+type meteredReader struct {
+	r      io.Reader
+	tc     *trackedConn
+	upload bool
+}
+
+func (m *meteredReader) Read(b []byte) (int, error) {
+	if size := m.tc.bandwidth.readSize(m.upload); size > 0 && len(b) > size {
+		b = b[:size]
+	}
+	n, err := m.r.Read(b)
+	if n > 0 {
+		m.tc.touch()
+		if m.upload {
+			m.tc.bytesUp.Add(int64(n))
+			m.tc.counters.bytesUp.Add(int64(n))
+			m.tc.bandwidth.wait(true, n, &m.tc.upBucket, m.tc.done)
+		} else {
+			m.tc.bytesDown.Add(int64(n))
+			m.tc.counters.bytesDown.Add(int64(n))
+			m.tc.bandwidth.wait(false, n, &m.tc.downBucket, m.tc.done)
+		}
+	}
+	return n, err
+}
+
+// meteredReadCloser 用于请求体和响应体
+type meteredReadCloser struct {
+	*meteredReader
+	io.Closer
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// h2Pool 一个代理到上游的HTTP/2连接，多个隧道共享同一条连接。
+// 上游地址、TLS参数或超时变化时换用新的Transport，代理停止时关闭所有连接
+type h2Pool struct {
+	mu        sync.Mutex
+	key       string
+	transport *http2.Transport
+	conns     map[*h2PoolConn]struct{}
+}
+
+// transportFor 返回与上游设置对应的Transport
+func (p *h2Pool) transportFor(address string, transport *config.UpstreamTransport, timeouts *timeoutSettings) *http2.Transport {
+	tlsConfig := transportTLSConfig(address, transport, http2.NextProtoTLS)
+	key := fmt.Sprintf("%s|%t|%s|%t|%s|%s", address, transport.TLS, tlsConfig.ServerName, transport.SkipVerify, timeouts.dial, timeouts.keepAlive)
+
+	p.mu.Lock()
+	if p.transport != nil && p.key == key {
+		t := p.transport
+		p.mu.Unlock()
+		return t
+	}
+	old := p.transport
+	t := &http2.Transport{
+		AllowHTTP:       !transport.TLS,
+		ReadIdleTimeout: 30 * time.Second,
+		IdleConnTimeout: forwardIdleConnTimeout,
+		DialTLSContext: func(ctx context.Context, network, _ string, _ *tls.Config) (net.Conn, error) {
+			conn, err := timeouts.dialer().DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			conn = p.track(conn)
+			if !transport.TLS {
+				return conn, nil
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+	p.key = key
+	p.transport = t
+	p.mu.Unlock()
+
+	// 旧Transport上还有流的连接在流结束后按空闲超时关闭
+	if old != nil {
+		old.CloseIdleConnections()
+	}
+	return t
+}
+
+func (p *h2Pool) track(conn net.Conn) net.Conn {
+	c := &h2PoolConn{Conn: conn, pool: p}
+	p.mu.Lock()
+	if p.conns == nil {
+		p.conns = make(map[*h2PoolConn]struct{})
+	}
+	p.conns[c] = struct{}{}
+	p.mu.Unlock()
+	return c
+}
+
+// close 关闭所有到上游的HTTP/2连接，之后的隧道会重新建立连接
+func (p *h2Pool) close() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.key = ""
+	p.transport = nil
+	p.mu.Unlock()
+
+	for c := range conns {
+		c.Conn.Close()
+	}
+}
+
+// h2PoolConn 记录在h2Pool中的底层连接，关闭时从池中移除
+type h2PoolConn struct {
+	net.Conn
+	pool *h2Pool
+}
+
+func (c *h2PoolConn) Close() error {
+	c.pool.mu.Lock()
+	delete(c.pool.conns, c)
+	c.pool.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// dialH2Connect 通过HTTP/2 CONNECT(或扩展CONNECT)建立一条承载字节流的流
+func dialH2Connect(ctx context.Context, pool *h2Pool, address string, transport *config.UpstreamTransport, timeouts *timeoutSettings) (net.Conn, error) {
+	scheme := "https"
+	if !transport.TLS {
+		scheme = "http"
+	}
+	host := transportHost(address, transport)
+
+	// 请求的ctx决定整条流的生命周期，不能继承只约束握手的ctx；
+	// 握手超时或ctx取消只能在收到响应前中断请求
+	streamCtx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodConnect, scheme+"://"+host, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Host = host
+	if transport.H2Protocol != "" {
+		req.URL.Path = transportPath(transport)
+		req.Header.Set(":protocol", transport.H2Protocol)
+	}
+	setTransportHeaders(req.Header, transport)
+
+	timer := time.AfterFunc(timeouts.handshake, cancel)
+	stop := context.AfterFunc(ctx, cancel)
+	resp, err := pool.transportFor(address, transport, timeouts).RoundTrip(req)
+	// 定时器或ctx已经触发时流已被取消，即使刚好收到了响应也不能使用
+	timedOut, cancelled := !timer.Stop(), !stop()
+	if err == nil && (timedOut || cancelled) {
+		resp.Body.Close()
+		err = ctx.Err()
+		if timedOut {
+			err = fmt.Errorf("握手超过 %s", timeouts.handshake)
+		}
+	}
+	if err != nil {
+		pw.Close()
+		cancel()
+		return nil, fmt.Errorf("HTTP/2 CONNECT到 %s 失败: %w", address, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		pw.Close()
+		cancel()
+		return nil, fmt.Errorf("HTTP/2 CONNECT被拒绝: %s", resp.Status)
+	}
+
+	return &h2StreamConn{
+		body:   resp.Body,
+		pw:     pw,
+		cancel: cancel,
+		remote: h2Addr(address),
+	}, nil
+}
+
+// h2StreamConn 把一个HTTP/2请求流包装为net.Conn
+type h2StreamConn struct {
+	body   io.ReadCloser
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	remote h2Addr
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+	timer     *time.Timer
+}
+
+func (c *h2StreamConn) Read(b []byte) (int, error)  { return c.body.Read(b) }
+func (c *h2StreamConn) Write(b []byte) (int, error) { return c.pw.Write(b) }
+
+func (c *h2StreamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.pw.Close()
+		c.body.Close()
+		c.cancel()
+	})
+	return nil
+}
+
+func (c *h2StreamConn) LocalAddr() net.Addr  { return h2Addr("") }
+func (c *h2StreamConn) RemoteAddr() net.Addr { return c.remote }
+
+// SetDeadline 流上无法单独设置读写超时，到期后直接关闭整个流
+func (c *h2StreamConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if !t.IsZero() {
+		c.timer = time.AfterFunc(time.Until(t), func() { c.Close() })
+	}
+	return nil
+}
+
+func (c *h2StreamConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *h2StreamConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+type h2Addr string
+
+func (a h2Addr) Network() string { return "h2" }
+func (a h2Addr) String() string  { return string(a) }
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// applyHeaderRules 对匹配目标主机的规则按顺序改写头部
+func applyHeaderRules(rules []config.HeaderRule, direction, targetHost string, h http.Header) {
+	if len(rules) == 0 {
+		return
+	}
+
+	host := strings.ToLower(targetHost)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	for _, rule := range rules {
+		if rule.Direction != direction || !matchHostGlob(rule.Host, host) {
+			continue
+		}
+		switch rule.Action {
+		case "add":
+			h.Add(rule.Name, rule.Value)
+		case "replace":
+			h.Set(rule.Name, rule.Value)
+		case "remove":
+			h.Del(rule.Name)
+		}
+	}
+}
+
+func matchHostGlob(pattern, host string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	matched, err := path.Match(strings.ToLower(pattern), host)
+	return err == nil && matched
+}
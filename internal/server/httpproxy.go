@@ -1,24 +1,38 @@
 package server
 
 import (
-	"bufio"
 	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"proxy-manager-desktop/internal/accesslog"
 	"proxy-manager-desktop/internal/config"
 )
 
+// 普通HTTP转发复用上游连接的连接池参数
+const (
+	forwardMaxIdleConns          = 100
+	forwardMaxIdleConnsPerHost   = 16
+	forwardIdleConnTimeout       = 90 * time.Second
+	forwardResponseHeaderTimeout = 60 * time.Second
+)
+
 type HTTPProxy struct {
+	lifecycle
+	connRegistry
+
 	config      *config.ProxyConfig
 	server      *http.Server
-	isRunning   bool
-	stopChannel chan struct{}
+	conns       *connTracker // 被劫持的CONNECT隧道不再由http.Server跟踪，停止时由这里等待
+	transport   *http.Transport
+	headerRules atomic.Pointer[[]config.HeaderRule]
 }
 
 func NewHTTPProxy(proxyConfig *config.ProxyConfig) (*HTTPProxy, error) {
@@ -27,59 +41,126 @@ func NewHTTPProxy(proxyConfig *config.ProxyConfig) (*HTTPProxy, error) {
 	}
 
 	proxy := &HTTPProxy{
-		config:      proxyConfig,
-		stopChannel: make(chan struct{}),
+		config: proxyConfig,
 	}
+	proxy.transport = proxy.newForwardTransport()
+	proxy.SetHeaderRules(proxyConfig.HeaderRules)
 
 	return proxy, nil
 }
 
+// SetHeaderRules 替换头部改写规则，对之后的请求立即生效
+func (p *HTTPProxy) SetHeaderRules(rules []config.HeaderRule) {
+	rules = append([]config.HeaderRule(nil), rules...)
+	p.headerRules.Store(&rules)
+}
+
+// newForwardTransport 创建普通HTTP转发使用的连接池。
+// 上游为HTTP代理时直接把绝对URI形式的请求发给上游，连接按上游复用；
+// 其他上游经隧道连接目标主机，发送origin形式的请求，空闲连接按目标主机复用
+func (p *HTTPProxy) newForwardTransport() *http.Transport {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return p.connectUpstream(ctx, addr)
+		},
+		MaxIdleConns:          forwardMaxIdleConns,
+		MaxIdleConnsPerHost:   forwardMaxIdleConnsPerHost,
+		IdleConnTimeout:       forwardIdleConnTimeout,
+		ResponseHeaderTimeout: forwardResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+
+	if p.config.Upstream.Protocol == "http" {
+		upstreamURL := &url.URL{Scheme: "http", Host: p.config.Upstream.Address}
+		if p.config.Upstream.Username != "" && p.config.Upstream.Password != "" {
+			upstreamURL.User = url.UserPassword(p.config.Upstream.Username, p.config.Upstream.Password)
+		}
+		transport.Proxy = http.ProxyURL(upstreamURL)
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := p.dialContext(ctx)
+			defer cancel()
+			return dialUpstream(ctx, &p.h2, p.config.Upstream, p.currentTimeouts())
+		}
+	}
+
+	return transport
+}
+
 func (p *HTTPProxy) Start() error {
-	if p.isRunning {
-		return fmt.Errorf("代理已在运行")
+	if err := p.beginStart(); err != nil {
+		return err
 	}
 
 	listenAddr := fmt.Sprintf("%s:%d", p.config.Local.ListenIP, p.config.Local.ListenPort)
+	listener, err := listen(listenAddr)
+	if err != nil {
+		err = fmt.Errorf("无法创建HTTP监听器: %w", err)
+		p.markFailed(err)
+		return err
+	}
+
+	p.conns = p.resetTracker(p.config)
+	listener = p.limitListener("HTTP代理 "+listenAddr, listener)
+	// 请求头读取和长连接空闲的超时在启动时确定
+	timeouts := p.currentTimeouts()
 	p.server = &http.Server{
-		Addr:    listenAddr,
-		Handler: http.HandlerFunc(p.handleHTTPRequest),
+		Addr:              listenAddr,
+		Handler:           http.HandlerFunc(p.handleHTTPRequest),
+		ReadHeaderTimeout: timeouts.handshake,
+		IdleTimeout:       timeouts.idle,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, clientConnKey{}, c)
+		},
 	}
 
-	go func() {
-		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("HTTP代理服务器错误: %v\n", err)
-		}
-	}()
+	p.markRunning()
+	go p.serve(p.server, listener)
 
-	p.isRunning = true
 	fmt.Printf("HTTP代理开始监听 %s\n", listenAddr)
 	return nil
 }
 
-func (p *HTTPProxy) Stop() error {
-	if !p.isRunning {
-		return fmt.Errorf("代理未运行")
+func (p *HTTPProxy) serve(server *http.Server, listener net.Listener) {
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("HTTP代理服务器错误: %v\n", err)
+		p.markFailed(err)
 	}
+}
 
-	p.isRunning = false
-
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		if err := p.server.Shutdown(ctx); err != nil {
-			fmt.Printf("HTTP代理优雅关闭失败，强制关闭: %v\n", err)
-			p.server.Close()
-		}
-		fmt.Printf("HTTP代理已完全停止\n")
-	}()
+// Stop 停止接受新连接并等待处理中的请求和隧道结束，ctx到期后强制关闭剩余连接
+func (p *HTTPProxy) Stop(ctx context.Context) error {
+	if err := p.beginStop(); err != nil {
+		return err
+	}
+	defer p.markStopped()
 
 	fmt.Printf("HTTP代理正在停止\n")
+	shutdownErr := p.server.Shutdown(ctx)
+	if shutdownErr != nil {
+		p.server.Close()
+	}
+	forced, drainErr := p.conns.drain(ctx)
+	p.transport.CloseIdleConnections()
+	p.h2.close()
+
+	if shutdownErr != nil || drainErr != nil {
+		fmt.Printf("HTTP代理等待连接结束超时，强制关闭 %d 个隧道\n", forced)
+		return fmt.Errorf("等待HTTP连接结束超时: %w", ctx.Err())
+	}
+	fmt.Printf("HTTP代理已完全停止\n")
 	return nil
 }
 
-func (p *HTTPProxy) IsRunning() bool {
-	return p.isRunning
+func (p *HTTPProxy) release() {
+	if p.server != nil {
+		p.server.Close()
+	}
+	if p.conns != nil {
+		p.conns.closeAll()
+	}
+	p.transport.CloseIdleConnections()
+	p.h2.close()
 }
 
 func (p *HTTPProxy) GetConfig() *config.ProxyConfig {
@@ -87,6 +168,11 @@ func (p *HTTPProxy) GetConfig() *config.ProxyConfig {
 }
 
 func (p *HTTPProxy) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
+	if p.blocked.Load() {
+		p.logAccess(accesslog.Entry{Client: r.RemoteAddr, Target: r.Host, Outcome: accesslog.OutcomeBlocked, Error: "代理已达到流量配额"}, nil)
+		http.Error(w, "代理已达到流量配额，拒绝新连接", http.StatusForbidden)
+		return
+	}
 	if r.Method == "CONNECT" {
 		p.handleHTTPSConnect(w, r)
 	} else {
@@ -95,8 +181,21 @@ func (p *HTTPProxy) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *HTTPProxy) handleHTTPSConnect(w http.ResponseWriter, r *http.Request) {
-	upstreamConn, err := p.connectUpstream(r.Host)
+	// 劫持前就计入，避免停止时漏掉正在建立的隧道
+	conns := p.conns
+	conns.wg.Add(1)
+	defer conns.wg.Done()
+
+	start := time.Now()
+	upstreamConn, err := p.connectUpstream(r.Context(), r.Host)
 	if err != nil {
+		conns.fail()
+		p.logAccess(accesslog.Entry{
+			Client:     r.RemoteAddr,
+			Target:     r.Host,
+			DurationMs: time.Since(start).Milliseconds(),
+			Outcome:    accesslog.OutcomeUpstreamError,
+		}, err)
 		http.Error(w, fmt.Sprintf("连接上游代理失败: %v", err), http.StatusBadGateway)
 		return
 	}
@@ -114,58 +213,220 @@ func (p *HTTPProxy) handleHTTPSConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer clientConn.Close()
+	tc := conns.add(clientConn)
+	if tc == nil {
+		return
+	}
+	defer conns.remove(tc)
+	conns.setTarget(tc, r.Host)
 
 	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 	if err != nil {
 		return
 	}
 
-	p.relay(clientConn, upstreamConn)
+	p.relay(clientConn, upstreamConn, tc)
 }
 
 func (p *HTTPProxy) handleHTTPForward(w http.ResponseWriter, r *http.Request) {
-	upstreamConn, err := p.connectUpstream(r.Host)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("连接上游代理失败: %v", err), http.StatusBadGateway)
+	// 普通请求按请求记录，断开时关闭所在的客户端连接
+	conns := p.conns
+	clientConn, _ := r.Context().Value(clientConnKey{}).(net.Conn)
+	if clientConn == nil {
+		http.Error(w, "无法获取客户端连接", http.StatusInternalServerError)
 		return
 	}
-	defer upstreamConn.Close()
-	if err := r.Write(upstreamConn); err != nil {
-		http.Error(w, fmt.Sprintf("发送请求失败: %v", err), http.StatusBadGateway)
+	tc := conns.add(clientConn)
+	if tc == nil {
 		return
 	}
+	defer conns.remove(tc)
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	outReq.Close = false
+	if outReq.URL.Scheme == "" {
+		outReq.URL.Scheme = "http"
+	}
+	if outReq.URL.Host == "" {
+		outReq.URL.Host = r.Host
+	}
+	conns.setTarget(tc, canonicalAddr(outReq.URL))
+	if outReq.Body != nil && outReq.Body != http.NoBody {
+		outReq.Body = meteredReadCloser{tc.countUp(outReq.Body), outReq.Body}
+	}
+
+	// 客户端声明支持trailer时需要保留，其余逐跳头部一律不转发
+	acceptsTrailers := headerHasToken(r.Header, "Te", "trailers")
+	removeHopByHopHeaders(outReq.Header)
+	if acceptsTrailers {
+		outReq.Header.Set("Te", "trailers")
+	}
+	rules := *p.headerRules.Load()
+	applyHeaderRules(rules, "request", outReq.URL.Host, outReq.Header)
+	if via := p.config.Local.Via; via != "" {
+		addViaHeader(outReq.Header, r.ProtoMajor, r.ProtoMinor, via)
+	}
 
-	resp, err := http.ReadResponse(bufio.NewReader(upstreamConn), r)
+	resp, err := p.transport.RoundTrip(outReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("读取响应失败: %v", err), http.StatusBadGateway)
+		conns.fail()
+		tc.finish(accesslog.OutcomeUpstreamError, err)
+		http.Error(w, fmt.Sprintf("转发请求失败: %v", err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	resp.Body = meteredReadCloser{tc.countDown(resp.Body), resp.Body}
+
+	removeHopByHopHeaders(resp.Header)
+	applyHeaderRules(rules, "response", outReq.URL.Host, resp.Header)
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	if via := p.config.Local.Via; via != "" {
+		addViaHeader(w.Header(), resp.ProtoMajor, resp.ProtoMinor, via)
+	}
+	for key := range resp.Trailer {
+		w.Header().Add("Trailer", key)
+	}
+
 	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	if err := copyResponseBody(w, resp); err != nil {
+		tc.finishRelay(err)
+		// 响应头已经发出，只能中断连接让客户端感知
+		panic(http.ErrAbortHandler)
+	}
+
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+key, value)
+		}
+	}
+}
+
+// copyResponseBody 转发响应体；长度未知的流式响应每次写入后立即刷新
+func copyResponseBody(w http.ResponseWriter, resp *http.Response) error {
+	if resp.ContentLength != -1 {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			rc.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
-func (p *HTTPProxy) connectUpstream(targetAddr string) (net.Conn, error) {
-	upstreamAddr := p.config.Upstream.Address
-	upstreamConn, err := net.Dial("tcp", upstreamAddr)
+// clientConnKey 请求上下文中保存客户端连接的键
+type clientConnKey struct{}
+
+// canonicalAddr 返回URL对应的 host:port，缺省端口按协议补全
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// hopByHopHeaders RFC 7230 6.1 规定的逐跳头部，以及常见的非标准 Proxy-Connection
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders 删除逐跳头部以及 Connection 中列出的头部
+func removeHopByHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addViaHeader 按 RFC 7230 5.7.1 追加 Via 头部，如 "1.1 proxy-manager"
+func addViaHeader(h http.Header, protoMajor, protoMinor int, pseudonym string) {
+	h.Add("Via", fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, pseudonym))
+}
+
+// connectUpstream 连接上游并完成到 targetAddr 的隧道握手，ctx 取消或代理停止时中断拨号和握手
+func (p *HTTPProxy) connectUpstream(ctx context.Context, targetAddr string) (net.Conn, error) {
+	ctx, cancel := p.dialContext(ctx)
+	defer cancel()
+
+	timeouts := p.currentTimeouts()
+	start := time.Now()
+	upstreamConn, err := dialUpstream(ctx, &p.h2, p.config.Upstream, timeouts)
 	if err != nil {
-		return nil, fmt.Errorf("无法连接到上游代理 %s: %w", upstreamAddr, err)
+		p.counters.recordUpstreamFailure("dial", err)
+		return nil, err
 	}
 
+	upstreamConn.SetDeadline(time.Now().Add(timeouts.handshake))
+	stop := abortOnCancel(ctx, upstreamConn)
+	var tunnel net.Conn
 	switch p.config.Upstream.Protocol {
 	case "http":
-		return p.setupHTTPTunnel(upstreamConn, targetAddr)
+		tunnel, err = p.setupHTTPTunnel(upstreamConn, targetAddr)
 	case "socks5":
-		return p.setupSOCKS5Tunnel(upstreamConn, targetAddr)
+		tunnel, err = p.setupSOCKS5Tunnel(upstreamConn, targetAddr)
+	case "shadowsocks":
+		tunnel, err = setupShadowsocksTunnel(upstreamConn, p.config.Upstream, targetAddr)
 	default:
+		err = fmt.Errorf("不支持的上游代理类型: %s", p.config.Upstream.Protocol)
+	}
+	if !stop() && err == nil {
+		err = fmt.Errorf("握手被取消: %w", ctx.Err())
+	}
+	if err != nil {
+		p.counters.recordUpstreamFailure("handshake", err)
 		upstreamConn.Close()
-		return nil, fmt.Errorf("不支持的上游代理类型: %s", p.config.Upstream.Protocol)
+		return nil, err
 	}
+	upstreamConn.SetDeadline(time.Time{})
+	p.counters.observeHandshake(time.Since(start))
+	return tunnel, nil
 }
 
 func (p *HTTPProxy) setupHTTPTunnel(conn net.Conn, targetAddr string) (net.Conn, error) {
@@ -198,8 +459,6 @@ func (p *HTTPProxy) setupSOCKS5Tunnel(conn net.Conn, targetAddr string) (net.Con
 	return p.doSOCKS5Handshake(conn, targetAddr)
 }
 func (p *HTTPProxy) doSOCKS5Handshake(conn net.Conn, targetAddr string) (net.Conn, error) {
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
-	defer conn.SetDeadline(time.Time{})
 	authMethod := byte(0x00)
 	if p.config.Upstream.Username != "" && p.config.Upstream.Password != "" {
 		authMethod = byte(0x02)
@@ -335,29 +594,34 @@ func (p *HTTPProxy) sendSOCKS5ConnectRequest(conn net.Conn, targetAddr string) e
 }
 
 // 双向数据转发
-func (p *HTTPProxy) relay(client, upstream net.Conn) error {
+func (p *HTTPProxy) relay(client, upstream net.Conn, tc *trackedConn) error {
+	defer tc.closeWhenIdle(p.currentTimeouts().idle, client, upstream)()
+
 	errc := make(chan error, 2)
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
 				fmt.Printf("客户端到上游转发时panic: %v\n", r)
+				errc <- fmt.Errorf("客户端到上游转发时panic: %v", r)
 			}
 		}()
-		_, err := io.Copy(upstream, client)
+		_, err := io.Copy(upstream, tc.countUp(client))
 		errc <- err
 	}()
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
 				fmt.Printf("上游到客户端转发时panic: %v\n", r)
+				errc <- fmt.Errorf("上游到客户端转发时panic: %v", r)
 			}
 		}()
-		_, err := io.Copy(client, upstream)
+		_, err := io.Copy(client, tc.countDown(upstream))
 		errc <- err
 	}()
 	err := <-errc
-	client.Close()
+	tc.close() // 同时结束另一个方向上的限速等待
 	upstream.Close()
 	<-errc
+	tc.finishRelay(err)
 	return err
 }
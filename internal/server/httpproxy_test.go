@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// socks5StandIn 只支持无认证CONNECT的SOCKS5上游，记录完成的握手次数
+type socks5StandIn struct {
+	addr       string
+	handshakes atomic.Int64
+}
+
+func startSOCKS5StandIn(tb testing.TB) *socks5StandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+
+	s := &socks5StandIn{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5StandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{socks5Version, authNone})
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 0x01:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 0x03:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{socks5Version, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	s.handshakes.Add(1)
+	conn.Write([]byte{socks5Version, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func freePort(tb testing.TB) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// BenchmarkHTTPForwardSOCKS5 经SOCKS5上游转发普通HTTP请求，
+// 比较复用隧道连接与每个请求新建隧道时的耗时和上游握手次数
+func BenchmarkHTTPForwardSOCKS5(b *testing.B) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			upstream := startSOCKS5StandIn(b)
+			port := freePort(b)
+			proxy, err := NewHTTPProxy(&config.ProxyConfig{
+				ID:       "bench",
+				Name:     "bench",
+				Upstream: config.UpstreamProxy{Protocol: "socks5", Address: upstream.addr},
+				Local:    config.LocalProxy{Protocol: "http", ListenIP: "127.0.0.1", ListenPort: port},
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			proxy.transport.DisableKeepAlives = !pooled
+			if err := proxy.Start(); err != nil {
+				b.Fatal(err)
+			}
+			defer proxy.Stop(context.Background())
+
+			proxyURL := &url.URL{Scheme: "http", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			defer client.CloseIdleConnections()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(origin.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			b.StopTimer()
+
+			handshakes := upstream.handshakes.Load()
+			b.ReportMetric(float64(handshakes), "handshakes")
+			b.ReportMetric(float64(handshakes)/float64(b.N), "handshakes/op")
+		})
+	}
+}
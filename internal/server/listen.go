@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// AddrInUseError 监听地址已被其他程序占用
+type AddrInUseError struct {
+	Addr string
+	Err  error
+}
+
+func (e *AddrInUseError) Error() string {
+	return fmt.Sprintf("端口已被占用: %s", e.Addr)
+}
+
+func (e *AddrInUseError) Unwrap() error {
+	return e.Err
+}
+
+// listen 同步绑定监听地址，地址被占用时返回 *AddrInUseError
+func listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		if isAddrInUse(err) {
+			return nil, &AddrInUseError{Addr: addr, Err: err}
+		}
+		return nil, err
+	}
+	return listener, nil
+}
+
+// isTemporaryAcceptError 判断Accept错误是否可以重试，与 http.Server 的处理方式一致
+func isTemporaryAcceptError(err error) bool {
+	ne, ok := err.(interface{ Temporary() bool })
+	return ok && ne.Temporary()
+}
@@ -1,43 +1,149 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"sync"
+	"time"
 
+	"proxy-manager-desktop/internal/accesslog"
 	"proxy-manager-desktop/internal/config"
 )
 
+// DefaultDrainTimeout 停止代理时等待处理中连接结束的默认时长，超时后强制关闭
+const DefaultDrainTimeout = 10 * time.Second
+
 type Proxy interface {
 	Start() error
-	Stop() error
+	// Stop 停止代理并等待处理中的连接结束，ctx到期后不再等待
+	Stop(ctx context.Context) error
+	State() ProxyState
+	Status() ProxyStatus
 	IsRunning() bool
+	// Err 返回导致代理失败的错误，正常运行或已停止时为nil
+	Err() error
 	GetConfig() *config.ProxyConfig
+	// SetConnectionObserver 设置连接事件的接收者，需要在启动前调用
+	SetConnectionObserver(observer ConnectionObserver)
+	// SetTrafficCounters 设置累计流量的计数器，需要在启动前调用
+	SetTrafficCounters(counters *TrafficCounters)
+	// SetBlocked 设置后代理继续监听，但拒绝所有新连接
+	SetBlocked(blocked bool)
+	// SetBandwidthLimit 替换带宽限制，运行中立即生效
+	SetBandwidthLimit(limit *config.BandwidthLimit)
+	// SetConnectionLimits 替换连接数限制和访问控制，运行中对新连接生效
+	SetConnectionLimits(limits *config.ConnectionLimits)
+	// SetTimeouts 替换超时设置，运行中对之后的连接生效
+	SetTimeouts(timeouts config.Timeouts)
+	// SetAccessLog 设置访问日志，需要在启动前调用
+	SetAccessLog(accessLog *accesslog.Logger)
+	Connections() []ConnectionInfo
+	CloseConnection(id string) bool
+	// release 关闭已失败的代理遗留的连接和上游连接池
+	release()
 }
 
 type ProxyManager struct {
 	configManager *config.ConfigManager
 	proxies       map[string]Proxy
 	mu            sync.RWMutex
+	drainTimeout  time.Duration
+	connObserver  ConnectionObserver
+	timeouts      config.Timeouts // 代理未单独设置时使用的超时
+	accessLog     *accesslog.Logger
+
+	counters    map[string]*TrafficCounters // 按代理ID保存，代理重启后继续累计
+	traffic     *trafficStore
+	trafficStop chan struct{}
+	trafficDone chan struct{}
+
+	quotas        *quotaTracker
+	quotaObserver QuotaObserver
+	quotaStop     chan struct{}
+	quotaDone     chan struct{}
+
+	metrics *metricsServer
+
+	reloadMu  sync.Mutex // 信号和文件监视可能同时触发重新加载
+	watchStop chan struct{}
+	watchDone chan struct{}
 }
 
 func NewProxyManager(configManager *config.ConfigManager) *ProxyManager {
 	return &ProxyManager{
 		configManager: configManager,
 		proxies:       make(map[string]Proxy),
+		drainTimeout:  DefaultDrainTimeout,
+		counters:      make(map[string]*TrafficCounters),
 	}
 }
 
+// SetDrainTimeout 设置停止代理时等待连接结束的时长，小于等于0表示立即强制关闭
+func (m *ProxyManager) SetDrainTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drainTimeout = timeout
+}
+
+// SetDefaultTimeouts 设置全局默认超时，运行中的代理立即按新的默认值合并
+func (m *ProxyManager) SetDefaultTimeouts(timeouts config.Timeouts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeouts = timeouts
+
+	for id, proxy := range m.proxies {
+		if proxyConfig, err := m.configManager.GetProxy(id); err == nil {
+			proxy.SetTimeouts(m.timeoutsLocked(proxyConfig.Timeouts))
+		}
+	}
+}
+
+// timeoutsLocked 合并代理自己的超时设置和全局默认值
+func (m *ProxyManager) timeoutsLocked(timeouts *config.Timeouts) config.Timeouts {
+	var t config.Timeouts
+	if timeouts != nil {
+		t = *timeouts
+	}
+	return t.WithDefaults(m.timeouts)
+}
+
+// SetAccessLog 设置访问日志，对之后启动的代理生效
+func (m *ProxyManager) SetAccessLog(accessLog *accesslog.Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accessLog = accessLog
+}
+
+// SetConnectionObserver 设置连接打开和关闭事件的接收者，对之后启动的代理生效
+func (m *ProxyManager) SetConnectionObserver(observer ConnectionObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connObserver = observer
+}
+
 func (m *ProxyManager) StartProxy(id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if proxy, exists := m.proxies[id]; exists && proxy.IsRunning() {
-		return fmt.Errorf("代理 %s 已在运行", id)
+	if proxy, exists := m.proxies[id]; exists {
+		switch proxy.State() {
+		case StateStarting, StateRunning:
+			return fmt.Errorf("代理 %s 已在运行", id)
+		case StateStopping:
+			return fmt.Errorf("代理 %s 正在停止", id)
+		case StateFailed:
+			proxy.release()
+		}
 	}
 	proxyConfig, err := m.configManager.GetProxy(id)
 	if err != nil {
 		return fmt.Errorf("无法获取代理配置: %w", err)
 	}
+	quotaExceeded := m.quotas != nil && proxyConfig.Quota != nil && m.quotas.exceeded(id)
+	if quotaExceeded && proxyConfig.Quota.Action == "stop" {
+		return fmt.Errorf("代理 %s 已达到流量配额", id)
+	}
 	var proxy Proxy
 	switch proxyConfig.Local.Protocol {
 	case "http":
@@ -50,30 +156,54 @@ func (m *ProxyManager) StartProxy(id string) error {
 	if err != nil {
 		return fmt.Errorf("创建代理失败: %w", err)
 	}
+	proxy.SetConnectionObserver(m.connObserver)
+	proxy.SetAccessLog(m.accessLog)
+	proxy.SetTrafficCounters(m.countersLocked(id))
+	proxy.SetBlocked(quotaExceeded)
+	proxy.SetBandwidthLimit(proxyConfig.Bandwidth)
+	proxy.SetConnectionLimits(proxyConfig.Limits)
+	proxy.SetTimeouts(m.timeoutsLocked(proxyConfig.Timeouts))
+	// 启动失败的代理也保留下来，以便查询失败原因
+	m.proxies[id] = proxy
 	if err := proxy.Start(); err != nil {
 		return fmt.Errorf("启动代理失败: %w", err)
 	}
-	m.proxies[id] = proxy
 	return nil
 }
 
 func (m *ProxyManager) StopProxy(id string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	proxy, exists := m.proxies[id]
 	if !exists {
+		m.mu.Unlock()
 		return fmt.Errorf("代理 %s 不存在", id)
 	}
 
-	if !proxy.IsRunning() {
-		return fmt.Errorf("代理 %s 未在运行", id)
+	// 已失败的代理没有需要停止的监听，关闭遗留的连接后移除
+	if proxy.State() == StateFailed {
+		delete(m.proxies, id)
+		m.mu.Unlock()
+		proxy.release()
+		return nil
+	}
+	drainTimeout := m.drainTimeout
+	m.mu.Unlock()
+
+	// 等待连接结束期间不持有锁，避免阻塞状态查询
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := proxy.Stop(ctx); err != nil {
+		if proxy.State() != StateStopped {
+			return fmt.Errorf("停止代理失败: %w", err)
+		}
+		fmt.Printf("停止代理 %s: %v\n", id, err)
 	}
 
-	if err := proxy.Stop(); err != nil {
-		return fmt.Errorf("停止代理失败: %w", err)
+	m.mu.Lock()
+	if m.proxies[id] == proxy {
+		delete(m.proxies, id)
 	}
-	delete(m.proxies, id)
+	m.mu.Unlock()
 	return nil
 }
 
@@ -85,6 +215,53 @@ func (m *ProxyManager) IsProxyRunning(id string) bool {
 	return exists && proxy.IsRunning()
 }
 
+// GetStatus 返回代理的状态快照，未启动过的代理视为已停止
+func (m *ProxyManager) GetStatus(id string) ProxyStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if proxy, exists := m.proxies[id]; exists {
+		return proxy.Status()
+	}
+	return ProxyStatus{State: StateStopped}
+}
+
+// GetConnections 返回代理的活动连接
+func (m *ProxyManager) GetConnections(id string) []ConnectionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if proxy, exists := m.proxies[id]; exists {
+		return proxy.Connections()
+	}
+	return nil
+}
+
+// GetAllConnections 返回所有代理的活动连接
+func (m *ProxyManager) GetAllConnections() []ConnectionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var connections []ConnectionInfo
+	for _, proxy := range m.proxies {
+		connections = append(connections, proxy.Connections()...)
+	}
+	return connections
+}
+
+// CloseConnection 断开指定的连接
+func (m *ProxyManager) CloseConnection(connID string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, proxy := range m.proxies {
+		if proxy.CloseConnection(connID) {
+			return nil
+		}
+	}
+	return fmt.Errorf("连接 %s 不存在", connID)
+}
+
 func (m *ProxyManager) GetRunningProxies() []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -114,6 +291,93 @@ func (m *ProxyManager) StartAllEnabledProxies() []error {
 	return errors
 }
 
+// StartAutoStartProxies 启动所有已启用且标记为自动启动的代理
+func (m *ProxyManager) StartAutoStartProxies() []error {
+	var errors []error
+	for _, proxyConfig := range m.configManager.GetAllProxies() {
+		if !proxyConfig.Enabled || !proxyConfig.AutoStart {
+			continue
+		}
+
+		if err := m.StartProxy(proxyConfig.ID); err != nil {
+			errors = append(errors, fmt.Errorf("启动代理 %s 失败: %w", proxyConfig.ID, err))
+		}
+	}
+	return errors
+}
+
+// SaveRunningState 把代理当前是否在运行记录为自动启动标记并保存配置，下次启动时恢复
+func (m *ProxyManager) SaveRunningState() error {
+	for _, proxy := range m.configManager.GetAllProxies() {
+		m.configManager.UpdateProxyAutoStart(proxy.ID, m.IsProxyRunning(proxy.ID))
+	}
+	return m.configManager.SaveConfig()
+}
+
+// ReloadConfig 重新读取配置文件并调整运行中的代理：停止已删除或已禁用的代理，
+// 只有上游或本地监听设置变化时才重启代理，其余设置直接应用到运行中的代理；
+// 启动新添加的已启用代理和标记为自动启动的代理。配置文件有错误时保留当前配置和代理
+func (m *ProxyManager) ReloadConfig() []error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	previous := make(map[string]bool)
+	for _, proxyConfig := range m.configManager.GetAllProxies() {
+		previous[proxyConfig.ID] = true
+	}
+	if err := m.configManager.LoadConfig(); err != nil {
+		return []error{fmt.Errorf("配置文件有错误，保持当前配置: %w", err)}
+	}
+
+	m.mu.RLock()
+	running := make(map[string]*config.ProxyConfig, len(m.proxies))
+	for id, proxy := range m.proxies {
+		if proxy.IsRunning() {
+			running[id] = proxy.GetConfig()
+		}
+	}
+	m.mu.RUnlock()
+
+	var errors []error
+	for id, old := range running {
+		proxyConfig, err := m.configManager.GetProxy(id)
+		switch {
+		case err != nil || !proxyConfig.Enabled:
+			if err := m.StopProxy(id); err != nil {
+				errors = append(errors, fmt.Errorf("停止代理 %s 失败: %w", id, err))
+			}
+		case needsRestart(old, proxyConfig):
+			if err := m.StopProxy(id); err != nil {
+				errors = append(errors, fmt.Errorf("停止代理 %s 失败: %w", id, err))
+			} else if err := m.StartProxy(id); err != nil {
+				errors = append(errors, fmt.Errorf("重新启动代理 %s 失败: %w", id, err))
+			}
+		default:
+			m.UpdateHeaderRules(id, proxyConfig.HeaderRules)
+			m.UpdateBandwidthLimit(id, proxyConfig.Bandwidth)
+			m.UpdateConnectionLimits(id, proxyConfig.Limits)
+			m.UpdateTimeouts(id, proxyConfig.Timeouts)
+		}
+	}
+	for _, proxyConfig := range m.configManager.GetAllProxies() {
+		if _, ok := running[proxyConfig.ID]; ok || !proxyConfig.Enabled {
+			continue
+		}
+		if previous[proxyConfig.ID] && !proxyConfig.AutoStart {
+			continue
+		}
+		if err := m.StartProxy(proxyConfig.ID); err != nil {
+			errors = append(errors, fmt.Errorf("启动代理 %s 失败: %w", proxyConfig.ID, err))
+		}
+	}
+	return errors
+}
+
+// needsRestart 上游或本地监听设置变化时需要重启代理，其他设置可以在运行中更新
+func needsRestart(old, updated *config.ProxyConfig) bool {
+	return !reflect.DeepEqual(old.Upstream, updated.Upstream) || !reflect.DeepEqual(old.Local, updated.Local)
+}
+
 func (m *ProxyManager) StopAllProxies() []error {
 	m.mu.Lock()
 	proxyIDs := make([]string, 0, len(m.proxies))
@@ -121,12 +385,25 @@ func (m *ProxyManager) StopAllProxies() []error {
 		proxyIDs = append(proxyIDs, id)
 	}
 	m.mu.Unlock()
-	var errors []error
+
+	// 各代理并行等待连接结束，总耗时不超过一个排空时长
+	var (
+		errors []error
+		errMu  sync.Mutex
+		wg     sync.WaitGroup
+	)
 	for _, id := range proxyIDs {
-		if err := m.StopProxy(id); err != nil {
-			errors = append(errors, fmt.Errorf("停止代理 %s 失败: %w", id, err))
-		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := m.StopProxy(id); err != nil {
+				errMu.Lock()
+				errors = append(errors, fmt.Errorf("停止代理 %s 失败: %w", id, err))
+				errMu.Unlock()
+			}
+		}(id)
 	}
+	wg.Wait()
 
 	return errors
 }
@@ -138,17 +415,62 @@ func (m *ProxyManager) GetProxyStatus(id string) (map[string]interface{}, error)
 	if !exists {
 		return nil, fmt.Errorf("代理 %s 不存在", id)
 	}
+	proxyStatus := proxy.Status()
 	status := map[string]interface{}{
-		"id":        id,
-		"running":   proxy.IsRunning(),
-		"config":    proxy.GetConfig(),
-		"protocol":  proxy.GetConfig().Local.Protocol,
-		"listen_ip": proxy.GetConfig().Local.ListenIP,
-		"port":      proxy.GetConfig().Local.ListenPort,
+		"id":         id,
+		"running":    proxyStatus.State == StateRunning,
+		"state":      proxyStatus.State.String(),
+		"error":      proxyStatus.LastError,
+		"started_at": proxyStatus.StartedAt,
+		"stopped_at": proxyStatus.StoppedAt,
+		"config":     proxy.GetConfig(),
+		"protocol":   proxy.GetConfig().Local.Protocol,
+		"listen_ip":  proxy.GetConfig().Local.ListenIP,
+		"port":       proxy.GetConfig().Local.ListenPort,
 	}
 	return status, nil
 }
 
+// UpdateHeaderRules 更新运行中HTTP代理的头部改写规则，无需重启代理
+func (m *ProxyManager) UpdateHeaderRules(id string, rules []config.HeaderRule) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if proxy, ok := m.proxies[id].(*HTTPProxy); ok {
+		proxy.SetHeaderRules(rules)
+	}
+}
+
+// UpdateBandwidthLimit 更新运行中代理的带宽限制，无需重启代理
+func (m *ProxyManager) UpdateBandwidthLimit(id string, limit *config.BandwidthLimit) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if proxy, ok := m.proxies[id]; ok {
+		proxy.SetBandwidthLimit(limit)
+	}
+}
+
+// UpdateConnectionLimits 更新运行中代理的连接数限制和访问控制，无需重启代理
+func (m *ProxyManager) UpdateConnectionLimits(id string, limits *config.ConnectionLimits) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if proxy, ok := m.proxies[id]; ok {
+		proxy.SetConnectionLimits(limits)
+	}
+}
+
+// UpdateTimeouts 更新运行中代理的超时设置，无需重启代理
+func (m *ProxyManager) UpdateTimeouts(id string, timeouts *config.Timeouts) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if proxy, ok := m.proxies[id]; ok {
+		proxy.SetTimeouts(m.timeoutsLocked(timeouts))
+	}
+}
+
 func (m *ProxyManager) RefreshProxy(id string) error {
 	isRunning := m.IsProxyRunning(id)
 	if isRunning {
@@ -161,3 +483,107 @@ func (m *ProxyManager) RefreshProxy(id string) error {
 	}
 	return nil
 }
+
+// countersLocked 返回代理的流量计数器，不存在时创建，调用方需持有写锁
+func (m *ProxyManager) countersLocked(id string) *TrafficCounters {
+	counters, ok := m.counters[id]
+	if !ok {
+		counters = &TrafficCounters{}
+		m.counters[id] = counters
+	}
+	return counters
+}
+
+// GetTrafficStats 返回代理自程序启动以来的累计流量和连接数
+func (m *ProxyManager) GetTrafficStats(id string) TrafficStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if counters, ok := m.counters[id]; ok {
+		return counters.Snapshot()
+	}
+	return TrafficStats{}
+}
+
+func (m *ProxyManager) trafficSnapshots() map[string]TrafficStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshots := make(map[string]TrafficStats, len(m.counters))
+	for id, counters := range m.counters {
+		snapshots[id] = counters.Snapshot()
+	}
+	return snapshots
+}
+
+// EnableTrafficHistory 加载流量历史文件，并开始每分钟把流量增量追加到文件中
+func (m *ProxyManager) EnableTrafficHistory(path string) error {
+	store, err := newTrafficStore(path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.traffic != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("流量历史已启用")
+	}
+	m.traffic = store
+	m.trafficStop = make(chan struct{})
+	m.trafficDone = make(chan struct{})
+	m.mu.Unlock()
+
+	go m.recordTraffic(store, m.trafficStop, m.trafficDone)
+	return nil
+}
+
+// recordTraffic 在每分钟开始时写入上一分钟的流量
+func (m *ProxyManager) recordTraffic(store *trafficStore, stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			if err := store.flush(time.Now(), m.trafficSnapshots()); err != nil {
+				fmt.Printf("保存流量历史失败: %v\n", err)
+			}
+			return
+		case now := <-timer.C:
+			if err := store.flush(now, m.trafficSnapshots()); err != nil {
+				fmt.Printf("保存流量历史失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// CloseTrafficHistory 写入尚未保存的流量并停止记录
+func (m *ProxyManager) CloseTrafficHistory() {
+	m.mu.Lock()
+	stop, done := m.trafficStop, m.trafficDone
+	m.traffic, m.trafficStop, m.trafficDone = nil, nil, nil
+	m.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// GetTrafficHistory 返回代理在指定范围(hour/day/week/month)内的流量和最近12个月的用量
+func (m *ProxyManager) GetTrafficHistory(id, rangeName string) (*TrafficHistory, error) {
+	m.mu.RLock()
+	store := m.traffic
+	var current TrafficStats
+	if counters, ok := m.counters[id]; ok {
+		current = counters.Snapshot()
+	}
+	m.mu.RUnlock()
+
+	if store == nil {
+		return nil, fmt.Errorf("流量历史未启用")
+	}
+	return store.query(id, rangeName, time.Now(), current)
+}
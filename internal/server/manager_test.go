@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+func TestStopFailedProxyClosesConnections(t *testing.T) {
+	t.Setenv(config.MasterPasswordEnv, "")
+	cm := config.NewConfigManager(filepath.Join(t.TempDir(), "config.json"))
+	port := freePort(t)
+	id, err := cm.AddProxy(&config.ProxyConfig{
+		Name:     "failed",
+		Upstream: config.UpstreamProxy{Protocol: "socks5", Address: "127.0.0.1:1"},
+		Local:    config.LocalProxy{Protocol: "socks5", ListenIP: "127.0.0.1", ListenPort: port},
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewProxyManager(cm)
+	if err := m.StartProxy(id); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	proxy := m.proxies[id].(*SOCKS5Proxy)
+	for len(proxy.Connections()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 监听器意外关闭，代理进入失败状态，已接受的连接仍然存在
+	proxy.listener.Close()
+	for proxy.State() != StateFailed {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := m.StopProxy(id); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("不应读到数据")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("移除失败的代理后遗留的连接没有被关闭")
+	}
+}
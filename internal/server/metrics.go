@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy-manager-desktop/internal/accesslog"
+)
+
+// handshakeBuckets 上游握手耗时直方图的桶上界(秒)，包含连接上游和上游协议握手
+var handshakeBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyHistogram 累积式直方图，每个桶记录落在该桶内的次数，输出时再累加，
+// 总次数取 +Inf 桶的累计值，不单独计数，保证与各个桶一致
+type latencyHistogram struct {
+	buckets  [len(handshakeBuckets) + 1]atomic.Int64 // 最后一个桶对应 +Inf
+	sumNanos atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(handshakeBuckets[:], seconds)
+	h.buckets[i].Add(1)
+	h.sumNanos.Add(int64(d))
+}
+
+// failureKey 上游失败按阶段和原因分类
+type failureKey struct {
+	stage  string // "dial" 或 "handshake"
+	reason string // accesslog.ClassifyError 的分类
+}
+
+type failureCounters struct {
+	mu     sync.Mutex
+	counts map[failureKey]int64
+}
+
+func (f *failureCounters) add(stage string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts == nil {
+		f.counts = make(map[failureKey]int64)
+	}
+	f.counts[failureKey{stage, accesslog.ClassifyError(err)}]++
+}
+
+func (f *failureCounters) snapshot() map[failureKey]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := make(map[failureKey]int64, len(f.counts))
+	for key, n := range f.counts {
+		counts[key] = n
+	}
+	return counts
+}
+
+// recordUpstreamFailure 记录一次连接上游(dial)或上游握手(handshake)失败
+func (c *TrafficCounters) recordUpstreamFailure(stage string, err error) {
+	c.failures.add(stage, err)
+}
+
+// observeHandshake 记录一次成功建立上游隧道的耗时
+func (c *TrafficCounters) observeHandshake(d time.Duration) {
+	c.handshake.observe(d)
+}
+
+// metricsServer 本地的指标HTTP监听器
+type metricsServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// StartMetrics 在addr上提供Prometheus文本格式的指标，已在运行时先停止旧的监听器
+func (m *ProxyManager) StartMetrics(addr string) error {
+	m.StopMetrics()
+
+	listener, err := listen(addr)
+	if err != nil {
+		return fmt.Errorf("无法创建指标监听器: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := m.WriteMetrics(w); err != nil {
+			fmt.Printf("输出指标失败: %v\n", err)
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	m.mu.Lock()
+	m.metrics = &metricsServer{server: server, listener: listener}
+	m.mu.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("指标服务器错误: %v\n", err)
+		}
+	}()
+	fmt.Printf("指标服务开始监听 %s\n", addr)
+	return nil
+}
+
+// StopMetrics 关闭指标监听器，未启用时什么也不做
+func (m *ProxyManager) StopMetrics() {
+	m.mu.Lock()
+	metrics := m.metrics
+	m.metrics = nil
+	m.mu.Unlock()
+
+	if metrics == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metrics.server.Shutdown(ctx); err != nil {
+		metrics.server.Close()
+	}
+	// Serve可能还没开始，监听器不一定已被Shutdown关闭
+	metrics.listener.Close()
+}
+
+// metricsProxy 一个代理在输出指标时的快照
+type metricsProxy struct {
+	labels   string
+	running  bool
+	stats    TrafficStats
+	failures map[failureKey]int64
+	buckets  [len(handshakeBuckets) + 1]int64
+	sumNanos int64
+}
+
+// WriteMetrics 以Prometheus文本格式输出所有已配置代理的指标
+func (m *ProxyManager) WriteMetrics(w io.Writer) error {
+	var proxies []metricsProxy
+	m.mu.RLock()
+	for _, cfg := range m.configManager.GetAllProxies() {
+		p := metricsProxy{
+			labels: fmt.Sprintf(`proxy_id="%s",proxy_name="%s",protocol="%s"`,
+				escapeLabel(cfg.ID), escapeLabel(cfg.Name), escapeLabel(cfg.Local.Protocol)),
+		}
+		if proxy, ok := m.proxies[cfg.ID]; ok {
+			p.running = proxy.IsRunning()
+		}
+		if counters, ok := m.counters[cfg.ID]; ok {
+			p.stats = counters.Snapshot()
+			p.failures = counters.failures.snapshot()
+			for i := range p.buckets {
+				p.buckets[i] = counters.handshake.buckets[i].Load()
+			}
+			p.sumNanos = counters.handshake.sumNanos.Load()
+		}
+		proxies = append(proxies, p)
+	}
+	m.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	family := func(name, typ, help string, value func(p metricsProxy) string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, p := range proxies {
+			fmt.Fprintf(bw, "%s{%s} %s\n", name, p.labels, value(p))
+		}
+	}
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	family("proxymgr_proxy_up", "gauge", "代理是否正在运行", func(p metricsProxy) string {
+		if p.running {
+			return "1"
+		}
+		return "0"
+	})
+	family("proxymgr_connections_active", "gauge", "当前活动连接数", func(p metricsProxy) string {
+		return count(p.stats.ConnectionsActive)
+	})
+	family("proxymgr_connections_total", "counter", "接受的连接总数", func(p metricsProxy) string {
+		return count(p.stats.ConnectionsTotal)
+	})
+	family("proxymgr_connections_failed_total", "counter", "未能建立到目标的连接数", func(p metricsProxy) string {
+		return count(p.stats.ConnectionsFailed)
+	})
+	family("proxymgr_connections_rejected_total", "counter", "被连接数限制或访问控制拒绝的连接数", func(p metricsProxy) string {
+		return count(p.stats.ConnectionsRejected)
+	})
+	family("proxymgr_bytes_sent_total", "counter", "客户端发往目标的字节数", func(p metricsProxy) string {
+		return count(p.stats.BytesUp)
+	})
+	family("proxymgr_bytes_received_total", "counter", "目标返回客户端的字节数", func(p metricsProxy) string {
+		return count(p.stats.BytesDown)
+	})
+
+	fmt.Fprintf(bw, "# HELP proxymgr_upstream_failures_total 连接上游或上游握手失败的次数，按阶段和原因分类\n")
+	fmt.Fprintf(bw, "# TYPE proxymgr_upstream_failures_total counter\n")
+	for _, p := range proxies {
+		keys := make([]failureKey, 0, len(p.failures))
+		for key := range p.failures {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].stage != keys[j].stage {
+				return keys[i].stage < keys[j].stage
+			}
+			return keys[i].reason < keys[j].reason
+		})
+		for _, key := range keys {
+			fmt.Fprintf(bw, "proxymgr_upstream_failures_total{%s,stage=\"%s\",reason=\"%s\"} %d\n",
+				p.labels, key.stage, key.reason, p.failures[key])
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP proxymgr_upstream_handshake_seconds 建立上游隧道的耗时，包含连接上游和上游协议握手\n")
+	fmt.Fprintf(bw, "# TYPE proxymgr_upstream_handshake_seconds histogram\n")
+	for _, p := range proxies {
+		var cumulative int64
+		for i, le := range handshakeBuckets {
+			cumulative += p.buckets[i]
+			fmt.Fprintf(bw, "proxymgr_upstream_handshake_seconds_bucket{%s,le=\"%s\"} %d\n",
+				p.labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		cumulative += p.buckets[len(handshakeBuckets)]
+		fmt.Fprintf(bw, "proxymgr_upstream_handshake_seconds_bucket{%s,le=\"+Inf\"} %d\n", p.labels, cumulative)
+		fmt.Fprintf(bw, "proxymgr_upstream_handshake_seconds_sum{%s} %s\n",
+			p.labels, strconv.FormatFloat(time.Duration(p.sumNanos).Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "proxymgr_upstream_handshake_seconds_count{%s} %d\n", p.labels, cumulative)
+	}
+	return bw.Flush()
+}
+
+// escapeLabel 按Prometheus文本格式转义标签值
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// quotaCheckInterval 检查配额用量的间隔
+const quotaCheckInterval = 5 * time.Second
+
+// QuotaStatus 代理当前周期的配额用量
+type QuotaStatus struct {
+	ProxyID     string    `json:"proxy_id"`
+	Limit       int64     `json:"limit"`
+	Used        int64     `json:"used"`
+	Period      string    `json:"period"`
+	Action      string    `json:"action"`
+	PeriodStart time.Time `json:"period_start"`
+	ResetAt     time.Time `json:"reset_at"` // 下次重置时间，lifetime 配额为零值
+	Exceeded    bool      `json:"exceeded"`
+	Threshold   int       `json:"threshold,omitempty"` // warning 事件对应的百分比
+}
+
+// QuotaObserver 接收配额事件: warning(达到提醒阈值)、exceeded(达到配额)、reset(进入新周期或手动重置)
+type QuotaObserver func(event string, status QuotaStatus)
+
+// quotaState 持久化的配额状态
+type quotaState struct {
+	PeriodStart time.Time `json:"period_start"`
+	Used        int64     `json:"used"`
+	Warned      int       `json:"warned,omitempty"` // 已提醒过的最高百分比
+	Exceeded    bool      `json:"exceeded,omitempty"`
+	Stopped     bool      `json:"stopped,omitempty"` // 代理因超额被停止，恢复可用时重新启动
+}
+
+type quotaEvent struct {
+	name   string
+	status QuotaStatus
+}
+
+// quotaTracker 按代理累计配额用量并保存到文件，程序重启后从文件恢复
+type quotaTracker struct {
+	mu       sync.Mutex
+	path     string
+	states   map[string]*quotaState
+	baseline map[string]int64 // 上次检查时计数器中的字节数
+}
+
+func loadQuotaTracker(path string) (*quotaTracker, error) {
+	q := &quotaTracker{
+		path:     path,
+		states:   make(map[string]*quotaState),
+		baseline: make(map[string]int64),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("无法读取配额状态: %w", err)
+	}
+	if err := json.Unmarshal(data, &q.states); err != nil {
+		return nil, fmt.Errorf("无法解析配额状态: %w", err)
+	}
+	return q, nil
+}
+
+func (q *quotaTracker) saveLocked() error {
+	data, err := json.MarshalIndent(q.states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法序列化配额状态: %w", err)
+	}
+	if err := config.WriteFileAtomic(q.path, data, 0600); err != nil {
+		return fmt.Errorf("无法写入配额状态: %w", err)
+	}
+	return nil
+}
+
+// update 计入自上次检查以来的流量，处理周期重置、提醒和超额，返回需要通知的事件
+func (q *quotaTracker) update(now time.Time, proxy *config.ProxyConfig, totalBytes int64) ([]quotaEvent, bool) {
+	quota := proxy.Quota
+	delta := totalBytes - q.baseline[proxy.ID]
+	q.baseline[proxy.ID] = totalBytes
+
+	periodStart := quotaPeriodStart(now, quota)
+	state, exists := q.states[proxy.ID]
+	if !exists {
+		state = &quotaState{PeriodStart: periodStart}
+		q.states[proxy.ID] = state
+	}
+
+	var events []quotaEvent
+	changed := !exists || delta > 0
+	if periodStart.After(state.PeriodStart) {
+		*state = quotaState{PeriodStart: periodStart, Stopped: state.Stopped}
+		events = append(events, quotaEvent{"reset", quotaStatus(proxy, state)})
+		changed = true
+	}
+	state.Used += delta
+
+	// 提高配额后允许恢复使用
+	if state.Exceeded && state.Used < quota.Limit {
+		state.Exceeded = false
+		changed = true
+	}
+
+	thresholds := append([]int(nil), quota.WarnAt...)
+	sort.Ints(thresholds)
+	for _, pct := range thresholds {
+		if pct > state.Warned && state.Used*100 >= quota.Limit*int64(pct) && state.Used < quota.Limit {
+			state.Warned = pct
+			status := quotaStatus(proxy, state)
+			status.Threshold = pct
+			events = append(events, quotaEvent{"warning", status})
+			changed = true
+		}
+	}
+
+	if !state.Exceeded && state.Used >= quota.Limit {
+		state.Exceeded = true
+		events = append(events, quotaEvent{"exceeded", quotaStatus(proxy, state)})
+		changed = true
+	}
+	return events, changed
+}
+
+func (q *quotaTracker) exceeded(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state, ok := q.states[id]
+	return ok && state.Exceeded
+}
+
+// quotaPeriodStart 返回当前周期的起点，lifetime 配额没有周期
+func quotaPeriodStart(now time.Time, quota *config.TrafficQuota) time.Time {
+	switch quota.Period {
+	case "day":
+		return localDayStart(now)
+	case "month":
+		resetDay := quota.ResetDay
+		if resetDay == 0 {
+			resetDay = 1
+		}
+		now = now.Local()
+		start := time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, time.Local)
+		if now.Before(start) {
+			start = start.AddDate(0, -1, 0)
+		}
+		return start
+	default:
+		return time.Time{}
+	}
+}
+
+func quotaStatus(proxy *config.ProxyConfig, state *quotaState) QuotaStatus {
+	quota := proxy.Quota
+	status := QuotaStatus{
+		ProxyID:     proxy.ID,
+		Limit:       quota.Limit,
+		Used:        state.Used,
+		Period:      quota.Period,
+		Action:      quota.Action,
+		PeriodStart: state.PeriodStart,
+		Exceeded:    state.Exceeded,
+	}
+	switch quota.Period {
+	case "day":
+		status.ResetAt = state.PeriodStart.AddDate(0, 0, 1)
+	case "month":
+		status.ResetAt = state.PeriodStart.AddDate(0, 1, 0)
+	}
+	return status
+}
+
+// SetQuotaObserver 设置配额事件的接收者
+func (m *ProxyManager) SetQuotaObserver(observer QuotaObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotaObserver = observer
+}
+
+// EnableQuotas 从文件恢复配额状态，并开始定期检查各代理的用量
+func (m *ProxyManager) EnableQuotas(path string) error {
+	quotas, err := loadQuotaTracker(path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.quotas != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("流量配额已启用")
+	}
+	m.quotas = quotas
+	m.quotaStop = make(chan struct{})
+	m.quotaDone = make(chan struct{})
+	stop, done := m.quotaStop, m.quotaDone
+	m.mu.Unlock()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(quotaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				m.checkQuotas(quotas, time.Now())
+				return
+			case now := <-ticker.C:
+				m.checkQuotas(quotas, now)
+			}
+		}
+	}()
+	return nil
+}
+
+// CloseQuotas 保存最新用量并停止检查
+func (m *ProxyManager) CloseQuotas() {
+	m.mu.Lock()
+	stop, done := m.quotaStop, m.quotaDone
+	m.quotaStop, m.quotaDone = nil, nil
+	m.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// checkQuotas 更新所有设置了配额的代理的用量，并对超额的代理执行停止或拒绝新连接。
+// 因超额停止的代理在进入新周期或提高配额后重新启动
+func (m *ProxyManager) checkQuotas(quotas *quotaTracker, now time.Time) {
+	snapshots := m.trafficSnapshots()
+
+	quotas.mu.Lock()
+	var (
+		events  []quotaEvent
+		changed bool
+		toStart []string
+	)
+	exceeded := make(map[string]bool)
+	withQuota := make(map[string]*config.ProxyConfig)
+	for _, proxy := range m.configManager.GetAllProxies() {
+		if proxy.Quota == nil || proxy.Quota.Limit <= 0 {
+			continue
+		}
+		stats := snapshots[proxy.ID]
+		proxyEvents, proxyChanged := quotas.update(now, proxy, stats.BytesUp+stats.BytesDown)
+		events = append(events, proxyEvents...)
+		changed = changed || proxyChanged
+		state := quotas.states[proxy.ID]
+		exceeded[proxy.ID] = state.Exceeded
+		withQuota[proxy.ID] = proxy
+		if state.Stopped && !state.Exceeded {
+			state.Stopped = false
+			changed = true
+			if proxy.Enabled {
+				toStart = append(toStart, proxy.ID)
+			}
+		}
+	}
+	// 配额被删除的代理不再保留状态
+	for id := range quotas.states {
+		if _, ok := withQuota[id]; !ok {
+			delete(quotas.states, id)
+			changed = true
+		}
+	}
+	if changed {
+		if err := quotas.saveLocked(); err != nil {
+			fmt.Printf("保存配额状态失败: %v\n", err)
+		}
+	}
+	quotas.mu.Unlock()
+
+	m.mu.RLock()
+	observer := m.quotaObserver
+	var toStop []string
+	for id, proxy := range m.proxies {
+		blocked := exceeded[id]
+		if blocked && withQuota[id].Quota.Action == "stop" {
+			if proxy.IsRunning() {
+				toStop = append(toStop, id)
+			}
+			continue
+		}
+		proxy.SetBlocked(blocked)
+	}
+	m.mu.RUnlock()
+
+	if len(toStop) > 0 {
+		quotas.mu.Lock()
+		for _, id := range toStop {
+			if state, ok := quotas.states[id]; ok {
+				state.Stopped = true
+			}
+		}
+		if err := quotas.saveLocked(); err != nil {
+			fmt.Printf("保存配额状态失败: %v\n", err)
+		}
+		quotas.mu.Unlock()
+	}
+
+	for _, event := range events {
+		fmt.Printf("代理 %s 流量配额事件 %s: 已用 %d / %d 字节\n", event.status.ProxyID, event.name, event.status.Used, event.status.Limit)
+		if observer != nil {
+			observer(event.name, event.status)
+		}
+	}
+	for _, id := range toStop {
+		go func(id string) {
+			if err := m.StopProxy(id); err != nil {
+				fmt.Printf("达到流量配额后停止代理 %s 失败: %v\n", id, err)
+			}
+		}(id)
+	}
+	for _, id := range toStart {
+		if err := m.StartProxy(id); err != nil {
+			fmt.Printf("流量配额恢复后重新启动代理 %s 失败: %v\n", id, err)
+		}
+	}
+}
+
+// GetQuotaStatus 返回代理当前周期的配额用量
+func (m *ProxyManager) GetQuotaStatus(id string) (*QuotaStatus, error) {
+	proxy, err := m.configManager.GetProxy(id)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Quota == nil || proxy.Quota.Limit <= 0 {
+		return nil, fmt.Errorf("代理 %s 未设置流量配额", id)
+	}
+
+	m.mu.RLock()
+	quotas := m.quotas
+	m.mu.RUnlock()
+	if quotas == nil {
+		return nil, fmt.Errorf("流量配额未启用")
+	}
+
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	state, ok := quotas.states[id]
+	if !ok {
+		state = &quotaState{PeriodStart: quotaPeriodStart(time.Now(), proxy.Quota)}
+	}
+	status := quotaStatus(proxy, state)
+	return &status, nil
+}
+
+// ResetQuota 清零代理当前周期的用量，超额被拦截的代理恢复接受连接，被停止的代理重新启动
+func (m *ProxyManager) ResetQuota(id string) error {
+	proxy, err := m.configManager.GetProxy(id)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	quotas := m.quotas
+	m.mu.RUnlock()
+	if quotas == nil {
+		return fmt.Errorf("流量配额未启用")
+	}
+
+	quotas.mu.Lock()
+	state, ok := quotas.states[id]
+	restart := ok && state.Stopped && proxy.Enabled
+	if ok {
+		state.Used = 0
+		state.Warned = 0
+		state.Exceeded = false
+		state.Stopped = false
+	}
+	err = quotas.saveLocked()
+	var status QuotaStatus
+	if ok && proxy.Quota != nil {
+		status = quotaStatus(proxy, state)
+	}
+	quotas.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	observer := m.quotaObserver
+	if p, exists := m.proxies[id]; exists {
+		p.SetBlocked(false)
+	}
+	m.mu.RUnlock()
+
+	if ok && observer != nil && proxy.Quota != nil {
+		observer("reset", status)
+	}
+	if restart {
+		if err := m.StartProxy(id); err != nil {
+			return fmt.Errorf("重置配额后重新启动代理失败: %w", err)
+		}
+	}
+	return nil
+}
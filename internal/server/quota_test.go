@@ -0,0 +1,74 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+func TestQuotaStopRestartsOnReset(t *testing.T) {
+	t.Setenv(config.MasterPasswordEnv, "")
+	dir := t.TempDir()
+	cm := config.NewConfigManager(filepath.Join(dir, "config.json"))
+	id, err := cm.AddProxy(&config.ProxyConfig{
+		Name:     "quota",
+		Enabled:  true,
+		Upstream: config.UpstreamProxy{Protocol: "socks5", Address: "127.0.0.1:1"},
+		Local:    config.LocalProxy{Protocol: "socks5", ListenIP: "127.0.0.1", ListenPort: freePort(t)},
+		Quota:    &config.TrafficQuota{Limit: 10, Period: "day", Action: "stop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewProxyManager(cm)
+	quotas, err := loadQuotaTracker(filepath.Join(dir, "quota_state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.quotas = quotas
+	if err := m.StartProxy(id); err != nil {
+		t.Fatal(err)
+	}
+	defer m.StopAllProxies()
+
+	exceed := func(now time.Time) {
+		t.Helper()
+		m.mu.Lock()
+		m.countersLocked(id).bytesUp.Add(20)
+		m.mu.Unlock()
+		m.checkQuotas(quotas, now)
+		deadline := time.Now().Add(5 * time.Second)
+		for hasProxy(m, id) {
+			if time.Now().After(deadline) {
+				t.Fatal("超额后代理没有停止")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 进入新周期后自动重新启动
+	now := time.Now()
+	exceed(now)
+	m.checkQuotas(quotas, now.AddDate(0, 0, 1))
+	if !m.IsProxyRunning(id) {
+		t.Fatal("进入新周期后代理没有重新启动")
+	}
+
+	// 手动重置后重新启动
+	exceed(now.AddDate(0, 0, 1))
+	if err := m.ResetQuota(id); err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsProxyRunning(id) {
+		t.Fatal("重置配额后代理没有重新启动")
+	}
+}
+
+func hasProxy(m *ProxyManager, id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, exists := m.proxies[id]
+	return exists
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// tokenBucket 令牌桶，桶容量为一秒的速率。允许令牌透支，
+// 透支部分换算成调用方需要等待的时间，这样单次读取的大小不必小于桶容量
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve 取走n个令牌，返回需要等待的时间；rate为每秒字节数，小于等于0表示不限速。
+// 单次最多取走一个桶容量的令牌
+func (b *tokenBucket) reserve(n int, rate int64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(rate)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+		if b.tokens > float64(rate) {
+			b.tokens = float64(rate)
+		}
+	}
+	b.last = now
+
+	b.tokens -= float64(min(int64(n), rate))
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// bandwidthLimiter 代理的带宽限制，整个代理共用一组令牌桶，每个连接另有自己的令牌桶。
+// 限制可以在运行时替换，对已有连接的下一次读取立即生效
+type bandwidthLimiter struct {
+	limits atomic.Pointer[config.BandwidthLimit]
+	up     tokenBucket
+	down   tokenBucket
+}
+
+func (l *bandwidthLimiter) set(limits *config.BandwidthLimit) {
+	if limits == nil {
+		limits = &config.BandwidthLimit{}
+	}
+	l.limits.Store(limits)
+}
+
+// rates 返回指定方向上整个代理和单个连接的限速
+func (l *bandwidthLimiter) rates(upload bool) (proxyRate, connRate int64) {
+	limits := l.limits.Load()
+	if limits == nil {
+		return 0, 0
+	}
+	if upload {
+		return limits.UploadBps, limits.ConnUploadBps
+	}
+	return limits.DownloadBps, limits.ConnDownloadBps
+}
+
+// readSize 限速时每次最多读取约十分之一秒的数据，避免一次读取后长时间停顿
+func (l *bandwidthLimiter) readSize(upload bool) int {
+	proxyRate, connRate := l.rates(upload)
+	rate := proxyRate
+	if rate <= 0 || (connRate > 0 && connRate < rate) {
+		rate = connRate
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return int(max(rate/10, 512))
+}
+
+// wait 为读到的n个字节等待令牌，取代理和连接两个令牌桶中较长的等待时间。
+// done 关闭时立即返回，这样强制关闭连接时不必等到令牌补足
+func (l *bandwidthLimiter) wait(upload bool, n int, connBucket *tokenBucket, done <-chan struct{}) {
+	proxyRate, connRate := l.rates(upload)
+	if proxyRate <= 0 && connRate <= 0 {
+		return
+	}
+
+	proxyBucket := &l.down
+	if upload {
+		proxyBucket = &l.up
+	}
+	delay := max(proxyBucket.reserve(n, proxyRate), connBucket.reserve(n, connRate))
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-done:
+		}
+	}
+}
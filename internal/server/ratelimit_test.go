@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+func TestBandwidthWaitInterrupted(t *testing.T) {
+	var l bandwidthLimiter
+	l.set(&config.BandwidthLimit{DownloadBps: 1024})
+	var bucket tokenBucket
+
+	// 先用完桶中的令牌，再透支约十秒的令牌
+	l.down.reserve(1024, 1024)
+	for i := 0; i < 10; i++ {
+		l.down.reserve(1024, 1024)
+	}
+
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		l.wait(false, 1024, &bucket, done)
+		close(returned)
+	}()
+	close(done)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("连接关闭后限速等待没有返回")
+	}
+}
+
+func TestTokenBucketReservationCapped(t *testing.T) {
+	var b tokenBucket
+	b.reserve(1, 1000)
+	if delay := b.reserve(1_000_000, 1000); delay > 1100*time.Millisecond {
+		t.Fatalf("单次读取的等待时间 = %v，不应超过一个桶容量", delay)
+	}
+}
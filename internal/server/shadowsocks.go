@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"lukechampine.com/blake3"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+const (
+	ssMaxPayload     = 0x3FFF // AEAD 单个分块最大载荷
+	ss2022MaxPayload = 0xFFFF // SIP022 单个分块最大载荷
+	ss2022MaxPadding = 900
+	ss2022TimeWindow = 30 * time.Second
+
+	ss2022TypeRequest  = byte(0)
+	ss2022TypeResponse = byte(1)
+)
+
+type ssCipher struct {
+	keySize  int
+	saltSize int
+	is2022   bool
+	newAEAD  func(key []byte) (cipher.AEAD, error)
+}
+
+var ssCiphers = map[string]*ssCipher{
+	"aes-128-gcm":                   {keySize: 16, saltSize: 16, newAEAD: newAESGCM},
+	"aes-256-gcm":                   {keySize: 32, saltSize: 32, newAEAD: newAESGCM},
+	"chacha20-ietf-poly1305":        {keySize: 32, saltSize: 32, newAEAD: chacha20poly1305.New},
+	"2022-blake3-aes-128-gcm":       {keySize: 16, saltSize: 16, newAEAD: newAESGCM, is2022: true},
+	"2022-blake3-aes-256-gcm":       {keySize: 32, saltSize: 32, newAEAD: newAESGCM, is2022: true},
+	"2022-blake3-chacha20-poly1305": {keySize: 32, saltSize: 32, newAEAD: chacha20poly1305.New, is2022: true},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ssConn 在底层连接上实现 shadowsocks AEAD / SIP022 的分块加解密
+type ssConn struct {
+	net.Conn
+	cipher *ssCipher
+	key    []byte
+
+	wAEAD   cipher.AEAD
+	wNonce  []byte
+	reqSalt []byte
+
+	rAEAD  cipher.AEAD
+	rNonce []byte
+	rBuf   []byte
+}
+
+// setupShadowsocksTunnel 在已建立的连接上完成shadowsocks握手，返回加密后的连接
+func setupShadowsocksTunnel(conn net.Conn, upstream config.UpstreamProxy, targetAddr string) (net.Conn, error) {
+	c, err := newSSConn(conn, upstream.Cipher, upstream.Password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	addr, err := encodeSocksAddr(targetAddr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if c.cipher.is2022 {
+		err = c.writeRequestHeader2022(addr)
+	} else {
+		err = c.writeRequestHeader(addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("发送shadowsocks请求头失败: %w", err)
+	}
+
+	return c, nil
+}
+
+func newSSConn(conn net.Conn, method, password string) (*ssConn, error) {
+	method = strings.ToLower(method)
+	sc, ok := ssCiphers[method]
+	if !ok {
+		return nil, fmt.Errorf("不支持的shadowsocks加密方式: %s", method)
+	}
+	if password == "" {
+		return nil, fmt.Errorf("shadowsocks密码不能为空")
+	}
+
+	var key []byte
+	if sc.is2022 {
+		if strings.Contains(password, ":") {
+			return nil, fmt.Errorf("暂不支持多用户身份头(EIH)格式的密钥")
+		}
+		psk, err := base64.StdEncoding.DecodeString(password)
+		if err != nil {
+			return nil, fmt.Errorf("2022系列加密方式的密码必须是base64编码的密钥: %w", err)
+		}
+		if len(psk) != sc.keySize {
+			return nil, fmt.Errorf("密钥长度错误: 需要%d字节，实际%d字节", sc.keySize, len(psk))
+		}
+		key = psk
+	} else {
+		key = evpBytesToKey(password, sc.keySize)
+	}
+
+	return &ssConn{Conn: conn, cipher: sc, key: key}, nil
+}
+
+// sessionAEAD 根据盐值派生会话子密钥并创建AEAD
+func (c *ssConn) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, c.cipher.keySize)
+	if c.cipher.is2022 {
+		material := make([]byte, 0, len(c.key)+len(salt))
+		material = append(material, c.key...)
+		material = append(material, salt...)
+		blake3.DeriveKey(subkey, "shadowsocks 2022 session subkey", material)
+	} else {
+		r := hkdf.New(sha1.New, c.key, salt, []byte("ss-subkey"))
+		if _, err := io.ReadFull(r, subkey); err != nil {
+			return nil, err
+		}
+	}
+	return c.cipher.newAEAD(subkey)
+}
+
+func (c *ssConn) initWriter() ([]byte, error) {
+	salt := make([]byte, c.cipher.saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.sessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	c.wAEAD = aead
+	c.wNonce = make([]byte, aead.NonceSize())
+	c.reqSalt = salt
+	return salt, nil
+}
+
+// writeRequestHeader 发送 AEAD 请求头: salt + 目标地址分块
+func (c *ssConn) writeRequestHeader(addr []byte) error {
+	salt, err := c.initWriter()
+	if err != nil {
+		return err
+	}
+	buf := append(salt, c.sealChunk(addr)...)
+	_, err = c.Conn.Write(buf)
+	return err
+}
+
+// writeRequestHeader2022 发送 SIP022 请求头:
+// salt + 固定长度头(类型, 时间戳, 可变头长度) + 可变头(目标地址, 填充)
+func (c *ssConn) writeRequestHeader2022(addr []byte) error {
+	salt, err := c.initWriter()
+	if err != nil {
+		return err
+	}
+
+	// 没有初始载荷时必须携带非零长度的填充
+	n, err := rand.Int(rand.Reader, big.NewInt(ss2022MaxPadding))
+	if err != nil {
+		return err
+	}
+	paddingLen := int(n.Int64()) + 1
+
+	variable := make([]byte, 0, len(addr)+2+paddingLen)
+	variable = append(variable, addr...)
+	variable = binary.BigEndian.AppendUint16(variable, uint16(paddingLen))
+	variable = append(variable, make([]byte, paddingLen)...)
+
+	fixed := make([]byte, 0, 11)
+	fixed = append(fixed, ss2022TypeRequest)
+	fixed = binary.BigEndian.AppendUint64(fixed, uint64(time.Now().Unix()))
+	fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(variable)))
+
+	buf := append(salt, c.seal(fixed)...)
+	buf = append(buf, c.seal(variable)...)
+	_, err = c.Conn.Write(buf)
+	return err
+}
+
+func (c *ssConn) seal(plain []byte) []byte {
+	out := c.wAEAD.Seal(nil, c.wNonce, plain, nil)
+	incrementNonce(c.wNonce)
+	return out
+}
+
+// sealChunk 加密一个数据分块: 加密的长度 + 加密的载荷
+func (c *ssConn) sealChunk(payload []byte) []byte {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(payload)))
+	out := c.seal(size[:])
+	return append(out, c.seal(payload)...)
+}
+
+func (c *ssConn) maxPayload() int {
+	if c.cipher.is2022 {
+		return ss2022MaxPayload
+	}
+	return ssMaxPayload
+}
+
+func (c *ssConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), c.maxPayload())
+		if _, err := c.Conn.Write(c.sealChunk(b[:n])); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *ssConn) Read(b []byte) (int, error) {
+	if c.rAEAD == nil {
+		if err := c.readResponseHeader(); err != nil {
+			return 0, err
+		}
+	}
+	for len(c.rBuf) == 0 {
+		payload, err := c.readChunk()
+		if err != nil {
+			return 0, err
+		}
+		c.rBuf = payload
+	}
+	n := copy(b, c.rBuf)
+	c.rBuf = c.rBuf[n:]
+	return n, nil
+}
+
+func (c *ssConn) open(sealed []byte) ([]byte, error) {
+	plain, err := c.rAEAD.Open(sealed[:0], c.rNonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("shadowsocks解密失败: %w", err)
+	}
+	incrementNonce(c.rNonce)
+	return plain, nil
+}
+
+func (c *ssConn) readSealed(size int) ([]byte, error) {
+	buf := make([]byte, size+c.rAEAD.Overhead())
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return nil, err
+	}
+	return c.open(buf)
+}
+
+func (c *ssConn) readChunk() ([]byte, error) {
+	sizeBuf, err := c.readSealed(2)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(sizeBuf))
+	if !c.cipher.is2022 {
+		size &= ssMaxPayload
+	}
+	return c.readSealed(size)
+}
+
+// readResponseHeader 读取服务端响应的盐值；SIP022 还需校验响应头
+func (c *ssConn) readResponseHeader() error {
+	salt := make([]byte, c.cipher.saltSize)
+	if _, err := io.ReadFull(c.Conn, salt); err != nil {
+		return err
+	}
+	aead, err := c.sessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+	c.rAEAD = aead
+	c.rNonce = make([]byte, aead.NonceSize())
+
+	if !c.cipher.is2022 {
+		return nil
+	}
+
+	fixed, err := c.readSealed(1 + 8 + c.cipher.saltSize + 2)
+	if err != nil {
+		return err
+	}
+	if fixed[0] != ss2022TypeResponse {
+		return fmt.Errorf("无效的shadowsocks响应类型: %d", fixed[0])
+	}
+	ts := time.Unix(int64(binary.BigEndian.Uint64(fixed[1:9])), 0)
+	if diff := time.Since(ts); diff > ss2022TimeWindow || diff < -ss2022TimeWindow {
+		return fmt.Errorf("shadowsocks响应时间戳偏差过大: %v", diff)
+	}
+	if !bytes.Equal(fixed[9:9+c.cipher.saltSize], c.reqSalt) {
+		return fmt.Errorf("shadowsocks响应的请求盐值不匹配")
+	}
+	size := int(binary.BigEndian.Uint16(fixed[9+c.cipher.saltSize:]))
+
+	payload, err := c.readSealed(size)
+	if err != nil {
+		return err
+	}
+	c.rBuf = payload
+	return nil
+}
+
+// evpBytesToKey 与 OpenSSL EVP_BytesToKey(MD5) 相同的密码派生方式
+func evpBytesToKey(password string, keyLen int) []byte {
+	var key, prev []byte
+	for len(key) < keyLen {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:keyLen]
+}
+
+// incrementNonce 以小端序递增nonce
+func incrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// encodeSocksAddr 将 host:port 编码为 SOCKS5 地址格式(ATYP + 地址 + 端口)
+func encodeSocksAddr(targetAddr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标地址失败: %w", err)
+	}
+
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil {
+		return nil, fmt.Errorf("解析端口失败: %w", err)
+	}
+
+	var addr []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			addr = append(addr, addrTypeIPv4)
+			addr = append(addr, ip4...)
+		} else {
+			addr = append(addr, addrTypeIPv6)
+			addr = append(addr, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("域名过长: %s", host)
+		}
+		addr = append(addr, addrTypeDomain, byte(len(host)))
+		addr = append(addr, host...)
+	}
+	return binary.BigEndian.AppendUint16(addr, uint16(port)), nil
+}
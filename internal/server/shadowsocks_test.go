@@ -0,0 +1,401 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"lukechampine.com/blake3"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// ssSpecMethod 按 shadowsocks AEAD(SIP004) 和 SIP022 规范独立实现的服务端加密方式，
+// 不使用被测代码中的密钥派生、分块和nonce处理，用于交叉验证。盐值长度与密钥长度相同
+type ssSpecMethod struct {
+	keySize int
+	is2022  bool
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+var ssSpecMethods = map[string]ssSpecMethod{
+	"aes-128-gcm":                   {keySize: 16, newAEAD: specAESGCM},
+	"aes-256-gcm":                   {keySize: 32, newAEAD: specAESGCM},
+	"chacha20-ietf-poly1305":        {keySize: 32, newAEAD: chacha20poly1305.New},
+	"2022-blake3-aes-128-gcm":       {keySize: 16, is2022: true, newAEAD: specAESGCM},
+	"2022-blake3-aes-256-gcm":       {keySize: 32, is2022: true, newAEAD: specAESGCM},
+	"2022-blake3-chacha20-poly1305": {keySize: 32, is2022: true, newAEAD: chacha20poly1305.New},
+}
+
+func specAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// key 由密码得到主密钥：AEAD 使用 EVP_BytesToKey(MD5, 无盐, 1轮)，SIP022 的密码是base64编码的密钥
+func (m ssSpecMethod) key(password string) ([]byte, error) {
+	if m.is2022 {
+		return base64.StdEncoding.DecodeString(password)
+	}
+	var key []byte
+	var d []byte // D_i = MD5(D_(i-1) || password)
+	for len(key) < m.keySize {
+		sum := md5.Sum(append(d, password...))
+		d = sum[:]
+		key = append(key, d...)
+	}
+	return key[:m.keySize], nil
+}
+
+// session 派生会话子密钥：AEAD 为 HKDF-SHA1(key, salt, "ss-subkey")，
+// SIP022 为 BLAKE3 derive_key("shadowsocks 2022 session subkey", key || salt)
+func (m ssSpecMethod) session(key, salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, m.keySize)
+	if m.is2022 {
+		blake3.DeriveKey(subkey, "shadowsocks 2022 session subkey", append(append([]byte{}, key...), salt...))
+	} else if _, err := io.ReadFull(hkdf.New(sha1.New, key, salt, []byte("ss-subkey")), subkey); err != nil {
+		return nil, err
+	}
+	return m.newAEAD(subkey)
+}
+
+func (m ssSpecMethod) maxPayload() int {
+	if m.is2022 {
+		return 0xFFFF
+	}
+	return 0x3FFF
+}
+
+// ssSpecStream 一个方向的会话，nonce是从0开始、每次加解密后加1的小端序计数器
+type ssSpecStream struct {
+	aead       cipher.AEAD
+	counter    uint64
+	maxPayload int
+	buf        []byte // 已解密尚未读取的载荷
+}
+
+func (s *ssSpecStream) nonce() []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.LittleEndian.PutUint64(nonce, s.counter)
+	s.counter++
+	return nonce
+}
+
+func (s *ssSpecStream) open(r io.Reader, size int) ([]byte, error) {
+	sealed := make([]byte, size+s.aead.Overhead())
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, err
+	}
+	return s.aead.Open(nil, s.nonce(), sealed, nil)
+}
+
+func (s *ssSpecStream) seal(plain []byte) []byte {
+	return s.aead.Seal(nil, s.nonce(), plain, nil)
+}
+
+// readChunk 读取一个分块: 加密的2字节长度 + 加密的载荷
+func (s *ssSpecStream) readChunk(r io.Reader) ([]byte, error) {
+	size, err := s.open(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(size))
+	if n > s.maxPayload {
+		return nil, fmt.Errorf("分块长度 %d 超过上限 %d", n, s.maxPayload)
+	}
+	return s.open(r, n)
+}
+
+func (s *ssSpecStream) sealChunk(payload []byte) []byte {
+	return append(s.seal(binary.BigEndian.AppendUint16(nil, uint16(len(payload)))), s.seal(payload)...)
+}
+
+// ssSpecReader 把解密后的分块作为字节流读取
+type ssSpecReader struct {
+	r io.Reader
+	*ssSpecStream
+}
+
+func (r ssSpecReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.readChunk(r.r)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// ssStandIn 把收到的数据原样发回的shadowsocks服务端，记录客户端请求的目标地址和服务端出错的原因
+type ssStandIn struct {
+	addr    string
+	targets chan string
+	errs    chan error
+}
+
+func startSSStandIn(t *testing.T, method, password string) *ssStandIn {
+	m, ok := ssSpecMethods[method]
+	if !ok {
+		t.Fatalf("未知的加密方式: %s", method)
+	}
+	key, err := m.key(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &ssStandIn{addr: ln.Addr().String(), targets: make(chan string, 1), errs: make(chan error, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := s.serve(conn, m, key); err != nil && err != io.EOF {
+					select {
+					case s.errs <- err:
+					default:
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *ssStandIn) serve(conn net.Conn, m ssSpecMethod, key []byte) error {
+	requestSalt := make([]byte, m.keySize)
+	if _, err := io.ReadFull(conn, requestSalt); err != nil {
+		return err
+	}
+	aead, err := m.session(key, requestSalt)
+	if err != nil {
+		return err
+	}
+	in := ssSpecReader{r: conn, ssSpecStream: &ssSpecStream{aead: aead, maxPayload: m.maxPayload()}}
+
+	var target string
+	if m.is2022 {
+		// 固定长度头: 类型(1) + 时间戳(8) + 可变头长度(2)
+		fixed, err := in.open(conn, 1+8+2)
+		if err != nil {
+			return err
+		}
+		if fixed[0] != 0 {
+			return fmt.Errorf("请求类型错误: %d", fixed[0])
+		}
+		if diff := time.Since(time.Unix(int64(binary.BigEndian.Uint64(fixed[1:9])), 0)); diff.Abs() > 30*time.Second {
+			return fmt.Errorf("请求时间戳偏差过大: %v", diff)
+		}
+		// 可变长度头: 目标地址 + 填充长度(2) + 填充 + 初始载荷
+		variable, err := in.open(conn, int(binary.BigEndian.Uint16(fixed[9:])))
+		if err != nil {
+			return err
+		}
+		r := bytes.NewReader(variable)
+		if target, err = readSpecSocksAddr(r); err != nil {
+			return err
+		}
+		var padding uint16
+		if err := binary.Read(r, binary.BigEndian, &padding); err != nil {
+			return err
+		}
+		if _, err := r.Seek(int64(padding), io.SeekCurrent); err != nil {
+			return err
+		}
+		if padding == 0 && r.Len() == 0 {
+			return fmt.Errorf("没有初始载荷时填充不能为空")
+		}
+		in.buf, _ = io.ReadAll(r)
+	} else if target, err = readSpecSocksAddr(in); err != nil {
+		return err
+	}
+	s.targets <- target
+
+	// 第一段数据随响应头一起发回
+	first := make([]byte, m.maxPayload())
+	n, err := in.Read(first)
+	if err != nil {
+		return err
+	}
+	first = first[:n]
+
+	responseSalt := make([]byte, m.keySize)
+	if _, err := rand.Read(responseSalt); err != nil {
+		return err
+	}
+	if aead, err = m.session(key, responseSalt); err != nil {
+		return err
+	}
+	out := &ssSpecStream{aead: aead, maxPayload: m.maxPayload()}
+	header := responseSalt
+	if m.is2022 {
+		// 固定长度头: 类型(1) + 时间戳(8) + 请求盐值 + 初始载荷长度(2)，之后是加密的初始载荷
+		fixed := []byte{1}
+		fixed = binary.BigEndian.AppendUint64(fixed, uint64(time.Now().Unix()))
+		fixed = append(fixed, requestSalt...)
+		fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(first)))
+		header = append(header, out.seal(fixed)...)
+		header = append(header, out.seal(first)...)
+	} else {
+		header = append(header, out.sealChunk(first)...)
+	}
+	if _, err := conn.Write(header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, m.maxPayload())
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(out.sealChunk(buf[:n])); err != nil {
+			return err
+		}
+	}
+}
+
+// readSpecSocksAddr 读取SOCKS5格式的地址: ATYP + 地址 + 端口
+func readSpecSocksAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host []byte
+	switch atyp[0] {
+	case 1:
+		host = make([]byte, net.IPv4len)
+	case 4:
+		host = make([]byte, net.IPv6len)
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		host = make([]byte, n[0])
+	default:
+		return "", fmt.Errorf("未知的地址类型: %d", atyp[0])
+	}
+	if _, err := io.ReadFull(r, host); err != nil {
+		return "", err
+	}
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return "", err
+	}
+	hostname := string(host)
+	if atyp[0] != 3 {
+		hostname = net.IP(host).String()
+	}
+	return net.JoinHostPort(hostname, strconv.Itoa(int(port))), nil
+}
+
+func TestShadowsocksRoundTrip(t *testing.T) {
+	methods := make([]string, 0, len(ssCiphers))
+	for method := range ssCiphers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	for _, method := range methods {
+		t.Run(method, func(t *testing.T) {
+			password := "test-password"
+			if sc := ssCiphers[method]; sc.is2022 {
+				password = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, sc.keySize))
+			}
+			standIn := startSSStandIn(t, method, password)
+
+			conn, err := net.Dial("tcp", standIn.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+			upstream := config.UpstreamProxy{Protocol: "shadowsocks", Address: standIn.addr, Cipher: method, Password: password}
+			tunnel, err := setupShadowsocksTunnel(conn, upstream, "example.com:443")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 超过单个分块的最大载荷，覆盖分块和响应头中的初始载荷
+			payload := make([]byte, 3*ss2022MaxPayload+17)
+			rand.Read(payload)
+			writeErr := make(chan error, 1)
+			go func() {
+				_, err := tunnel.Write(payload)
+				writeErr <- err
+			}()
+
+			got := make([]byte, len(payload))
+			if _, err := io.ReadFull(tunnel, got); err != nil {
+				select {
+				case serverErr := <-standIn.errs:
+					t.Fatalf("读取回显失败: %v，服务端: %v", err, serverErr)
+				default:
+					t.Fatalf("读取回显失败: %v", err)
+				}
+			}
+			if err := <-writeErr; err != nil {
+				t.Fatalf("写入失败: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatal("回显的数据与发送的不同")
+			}
+			if target := <-standIn.targets; target != "example.com:443" {
+				t.Fatalf("目标地址 = %q，期望 example.com:443", target)
+			}
+		})
+	}
+}
+
+func TestShadowsocksWrongPassword(t *testing.T) {
+	standIn := startSSStandIn(t, "aes-256-gcm", "right")
+
+	conn, err := net.Dial("tcp", standIn.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	upstream := config.UpstreamProxy{Protocol: "shadowsocks", Address: standIn.addr, Cipher: "aes-256-gcm", Password: "wrong"}
+	tunnel, err := setupShadowsocksTunnel(conn, upstream, "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel.Write([]byte("hello"))
+	if _, err := tunnel.Read(make([]byte, 5)); err == nil {
+		t.Fatal("密码错误时不应读到数据")
+	}
+	if err := <-standIn.errs; err == nil {
+		t.Fatal("服务端应当解密失败")
+	}
+}
@@ -1,14 +1,16 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"sync"
 	"time"
 
+	"proxy-manager-desktop/internal/accesslog"
 	"proxy-manager-desktop/internal/config"
 )
 
@@ -23,11 +25,16 @@ const (
 	addrTypeIPv6   = byte(0x04)
 )
 
+// errQuotaBlocked 代理达到流量配额后拒绝新连接
+var errQuotaBlocked = errors.New("代理已达到流量配额")
+
 type SOCKS5Proxy struct {
+	lifecycle
+	connRegistry
+
 	config      *config.ProxyConfig
 	listener    net.Listener
-	isRunning   bool
-	wg          sync.WaitGroup
+	conns       *connTracker
 	stopChannel chan struct{}
 }
 
@@ -37,86 +44,108 @@ func NewSOCKS5Proxy(proxyConfig *config.ProxyConfig) (*SOCKS5Proxy, error) {
 	}
 
 	proxy := &SOCKS5Proxy{
-		config:      proxyConfig,
-		stopChannel: make(chan struct{}),
+		config: proxyConfig,
 	}
 
 	return proxy, nil
 }
 func (p *SOCKS5Proxy) Start() error {
-	if p.isRunning {
-		return fmt.Errorf("代理已在运行")
+	if err := p.beginStart(); err != nil {
+		return err
 	}
 
 	listenAddr := fmt.Sprintf("%s:%d", p.config.Local.ListenIP, p.config.Local.ListenPort)
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := listen(listenAddr)
 	if err != nil {
-		return fmt.Errorf("无法创建SOCKS5监听器: %v", err)
+		err = fmt.Errorf("无法创建SOCKS5监听器: %w", err)
+		p.markFailed(err)
+		return err
 	}
-	p.listener = listener
 
-	p.isRunning = true
-	p.wg.Add(1)
-	go p.serve()
+	// 每次启动使用新的停止信号和连接跟踪器，停止后可以再次启动
+	p.stopChannel = make(chan struct{})
+	p.conns = p.resetTracker(p.config)
+	p.listener = p.limitListener("SOCKS5代理 "+listenAddr, listener)
+
+	p.markRunning()
+	p.conns.wg.Add(1)
+	go p.serve(p.listener, p.stopChannel, p.conns)
 
 	fmt.Printf("SOCKS5代理开始监听 %s\n", listenAddr)
 	return nil
 }
 
-func (p *SOCKS5Proxy) Stop() error {
-	if !p.isRunning {
-		return fmt.Errorf("代理未运行")
+// Stop 停止接受新连接并等待处理中的连接结束，ctx到期后强制关闭剩余连接
+func (p *SOCKS5Proxy) Stop(ctx context.Context) error {
+	if err := p.beginStop(); err != nil {
+		return err
 	}
+	defer p.markStopped()
 
-	p.isRunning = false
-
+	fmt.Printf("SOCKS5代理正在停止\n")
 	close(p.stopChannel)
 	if err := p.listener.Close(); err != nil {
-		return fmt.Errorf("无法关闭SOCKS5监听器: %v", err)
+		fmt.Printf("关闭SOCKS5监听器失败: %v\n", err)
 	}
 
-	go func() {
-		p.wg.Wait()
-		fmt.Printf("SOCKS5代理已完全停止\n")
-	}()
-
-	fmt.Printf("SOCKS5代理正在停止\n")
+	forced, err := p.conns.drain(ctx)
+	p.h2.close()
+	if err != nil {
+		fmt.Printf("SOCKS5代理等待连接结束超时，强制关闭 %d 个连接\n", forced)
+		return fmt.Errorf("等待SOCKS5连接结束超时: %w", err)
+	}
+	fmt.Printf("SOCKS5代理已完全停止\n")
 	return nil
 }
 
-func (p *SOCKS5Proxy) IsRunning() bool {
-	return p.isRunning
+func (p *SOCKS5Proxy) release() {
+	if p.conns != nil {
+		p.conns.closeAll()
+	}
+	p.h2.close()
 }
 
 func (p *SOCKS5Proxy) GetConfig() *config.ProxyConfig {
 	return p.config
 }
 
-func (p *SOCKS5Proxy) serve() {
-	defer p.wg.Done()
+func (p *SOCKS5Proxy) serve(listener net.Listener, stopChannel chan struct{}, conns *connTracker) {
+	defer conns.wg.Done()
 
 	for {
 		select {
-		case <-p.stopChannel:
+		case <-stopChannel:
 			return
 		default:
-			conn, err := p.listener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
 				select {
-				case <-p.stopChannel:
+				case <-stopChannel:
 					return
 				default:
+				}
+				if isTemporaryAcceptError(err) {
 					fmt.Printf("接受SOCKS5连接时出错: %v\n", err)
+					time.Sleep(100 * time.Millisecond)
 					continue
 				}
+				fmt.Printf("SOCKS5代理监听失败: %v\n", err)
+				p.markFailed(err)
+				listener.Close()
+				return
 			}
 
-			p.wg.Add(1)
+			tc := conns.add(conn)
+			if tc == nil {
+				continue
+			}
+			conns.wg.Add(1)
 			go func(c net.Conn) {
-				defer p.wg.Done()
+				defer conns.wg.Done()
+				defer conns.remove(tc)
 				defer c.Close()
 
-				if err := p.handleConnection(c); err != nil {
+				if err := p.handleConnection(c, conns, tc); err != nil {
 					fmt.Printf("处理SOCKS5连接时出错: %v\n", err)
 				}
 			}(conn)
@@ -124,28 +153,37 @@ func (p *SOCKS5Proxy) serve() {
 	}
 }
 
-func (p *SOCKS5Proxy) handleConnection(conn net.Conn) error {
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
+func (p *SOCKS5Proxy) handleConnection(conn net.Conn, conns *connTracker, tc *trackedConn) error {
+	conn.SetDeadline(time.Now().Add(p.currentTimeouts().handshake))
 	defer conn.Close()
 
 	if err := p.handleAuth(conn); err != nil {
+		tc.finish(accesslog.OutcomeClientError, err)
 		return fmt.Errorf("认证失败: %w", err)
 	}
 
 	targetAddr, err := p.handleRequest(conn)
 	if err != nil {
+		if errors.Is(err, errQuotaBlocked) {
+			tc.finish(accesslog.OutcomeBlocked, err)
+		} else {
+			tc.finish(accesslog.OutcomeClientError, err)
+		}
 		return fmt.Errorf("处理请求失败: %w", err)
 	}
 
 	conn.SetDeadline(time.Time{})
+	conns.setTarget(tc, targetAddr)
 
-	upstreamConn, err := p.connectUpstream(targetAddr)
+	upstreamConn, err := p.connectUpstream(context.Background(), targetAddr)
 	if err != nil {
+		conns.fail()
+		tc.finish(accesslog.OutcomeUpstreamError, err)
 		return fmt.Errorf("连接上游失败: %w", err)
 	}
 	defer upstreamConn.Close()
 
-	return p.relay(conn, upstreamConn)
+	return p.relay(conn, upstreamConn, tc)
 }
 
 func (p *SOCKS5Proxy) handleAuth(conn net.Conn) error {
@@ -207,6 +245,12 @@ func (p *SOCKS5Proxy) handleRequest(conn net.Conn) (string, error) {
 		return "", fmt.Errorf("不支持的地址类型")
 	}
 
+	// 0x02: 规则不允许的连接
+	if p.blocked.Load() {
+		conn.Write([]byte{socks5Version, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return "", fmt.Errorf("%w，拒绝连接到 %s", errQuotaBlocked, targetAddr)
+	}
+
 	response := []byte{socks5Version, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
 	_, err = conn.Write(response)
 	if err != nil {
@@ -216,22 +260,43 @@ func (p *SOCKS5Proxy) handleRequest(conn net.Conn) (string, error) {
 	return targetAddr, nil
 }
 
-func (p *SOCKS5Proxy) connectUpstream(targetAddr string) (net.Conn, error) {
-	upstreamAddr := p.config.Upstream.Address
-	upstreamConn, err := net.Dial("tcp", upstreamAddr)
+// connectUpstream 连接上游并完成到 targetAddr 的隧道握手，ctx 取消或代理停止时中断拨号和握手
+func (p *SOCKS5Proxy) connectUpstream(ctx context.Context, targetAddr string) (net.Conn, error) {
+	ctx, cancel := p.dialContext(ctx)
+	defer cancel()
+
+	timeouts := p.currentTimeouts()
+	start := time.Now()
+	upstreamConn, err := dialUpstream(ctx, &p.h2, p.config.Upstream, timeouts)
 	if err != nil {
-		return nil, fmt.Errorf("无法连接到上游代理 %s: %w", upstreamAddr, err)
+		p.counters.recordUpstreamFailure("dial", err)
+		return nil, err
 	}
 
+	upstreamConn.SetDeadline(time.Now().Add(timeouts.handshake))
+	stop := abortOnCancel(ctx, upstreamConn)
+	var tunnel net.Conn
 	switch p.config.Upstream.Protocol {
 	case "http":
-		return p.setupHTTPTunnel(upstreamConn, targetAddr)
+		tunnel, err = p.setupHTTPTunnel(upstreamConn, targetAddr)
 	case "socks5":
-		return p.setupSOCKS5Tunnel(upstreamConn, targetAddr)
+		tunnel, err = p.setupSOCKS5Tunnel(upstreamConn, targetAddr)
+	case "shadowsocks":
+		tunnel, err = setupShadowsocksTunnel(upstreamConn, p.config.Upstream, targetAddr)
 	default:
+		err = fmt.Errorf("不支持的上游代理类型: %s", p.config.Upstream.Protocol)
+	}
+	if !stop() && err == nil {
+		err = fmt.Errorf("握手被取消: %w", ctx.Err())
+	}
+	if err != nil {
+		p.counters.recordUpstreamFailure("handshake", err)
 		upstreamConn.Close()
-		return nil, fmt.Errorf("不支持的上游代理类型: %s", p.config.Upstream.Protocol)
+		return nil, err
 	}
+	upstreamConn.SetDeadline(time.Time{})
+	p.counters.observeHandshake(time.Since(start))
+	return tunnel, nil
 }
 
 func (p *SOCKS5Proxy) setupHTTPTunnel(conn net.Conn, targetAddr string) (net.Conn, error) {
@@ -264,9 +329,6 @@ func (p *SOCKS5Proxy) setupHTTPTunnel(conn net.Conn, targetAddr string) (net.Con
 }
 
 func (p *SOCKS5Proxy) setupSOCKS5Tunnel(conn net.Conn, targetAddr string) (net.Conn, error) {
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
-	defer conn.SetDeadline(time.Time{})
-
 	authMethod := authNone
 	if p.config.Upstream.Username != "" && p.config.Upstream.Password != "" {
 		authMethod = authUserPass
@@ -412,16 +474,19 @@ func (p *SOCKS5Proxy) sendConnectRequest(conn net.Conn, targetAddr string) error
 	return nil
 }
 
-func (p *SOCKS5Proxy) relay(client, upstream net.Conn) error {
+func (p *SOCKS5Proxy) relay(client, upstream net.Conn, tc *trackedConn) error {
+	defer tc.closeWhenIdle(p.currentTimeouts().idle, client, upstream)()
+
 	errc := make(chan error, 2)
 
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
 				fmt.Printf("客户端到上游转发时panic: %v\n", r)
+				errc <- fmt.Errorf("客户端到上游转发时panic: %v", r)
 			}
 		}()
-		_, err := io.Copy(upstream, client)
+		_, err := io.Copy(upstream, tc.countUp(client))
 		if err != nil {
 			fmt.Printf("客户端到上游转发错误: %v\n", err)
 		}
@@ -432,9 +497,10 @@ func (p *SOCKS5Proxy) relay(client, upstream net.Conn) error {
 		defer func() {
 			if r := recover(); r != nil {
 				fmt.Printf("上游到客户端转发时panic: %v\n", r)
+				errc <- fmt.Errorf("上游到客户端转发时panic: %v", r)
 			}
 		}()
-		_, err := io.Copy(client, upstream)
+		_, err := io.Copy(client, tc.countDown(upstream))
 		if err != nil {
 			fmt.Printf("上游到客户端转发错误: %v\n", err)
 		}
@@ -442,9 +508,10 @@ func (p *SOCKS5Proxy) relay(client, upstream net.Conn) error {
 	}()
 
 	err := <-errc
-	client.Close()
+	tc.close() // 同时结束另一个方向上的限速等待
 	upstream.Close()
 	<-errc
+	tc.finishRelay(err)
 	return err
 }
 
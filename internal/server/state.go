@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ProxyState 代理的生命周期状态
+type ProxyState int32
+
+const (
+	StateStopped ProxyState = iota
+	StateStarting
+	StateRunning
+	StateStopping
+	StateFailed
+)
+
+func (s ProxyState) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int32(s))
+	}
+}
+
+func (s ProxyState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ProxyStatus 代理状态快照
+type ProxyStatus struct {
+	State     ProxyState `json:"state"`
+	LastError string     `json:"last_error,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	StoppedAt time.Time  `json:"stopped_at"`
+}
+
+// lifecycle 维护代理的状态转换，所有方法都可以并发调用。
+//
+//	Stopped/Failed -> Starting -> Running -> Stopping -> Stopped
+//	Starting -> Failed (绑定失败)
+//	Running  -> Failed (运行期间监听出错)
+type lifecycle struct {
+	mu        sync.RWMutex
+	state     ProxyState
+	lastErr   error
+	startedAt time.Time
+	stoppedAt time.Time
+}
+
+// beginStart 进入 Starting 状态，只有已停止或已失败的代理可以启动
+func (l *lifecycle) beginStart() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch l.state {
+	case StateStopped, StateFailed:
+		l.state = StateStarting
+		l.lastErr = nil
+		return nil
+	case StateRunning, StateStarting:
+		return fmt.Errorf("代理已在运行")
+	default:
+		return fmt.Errorf("代理正在停止")
+	}
+}
+
+// markRunning 启动完成
+func (l *lifecycle) markRunning() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.state = StateRunning
+	l.startedAt = time.Now()
+}
+
+// beginStop 进入 Stopping 状态，只有运行中的代理可以停止
+func (l *lifecycle) beginStop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.state != StateRunning {
+		return fmt.Errorf("代理未运行")
+	}
+	l.state = StateStopping
+	return nil
+}
+
+// markStopped 停止完成
+func (l *lifecycle) markStopped() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.state = StateStopped
+	l.stoppedAt = time.Now()
+}
+
+// markFailed 启动失败或运行期间出错，正在停止时的错误不视为失败
+func (l *lifecycle) markFailed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.state == StateStopping || l.state == StateStopped {
+		return
+	}
+	l.state = StateFailed
+	l.lastErr = err
+	l.stoppedAt = time.Now()
+}
+
+func (l *lifecycle) State() ProxyState {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state
+}
+
+func (l *lifecycle) IsRunning() bool {
+	return l.State() == StateRunning
+}
+
+// Err 返回导致代理失败的错误，未失败时为nil
+func (l *lifecycle) Err() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.lastErr
+}
+
+func (l *lifecycle) Status() ProxyStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	status := ProxyStatus{
+		State:     l.state,
+		StartedAt: l.startedAt,
+		StoppedAt: l.stoppedAt,
+	}
+	if l.lastErr != nil {
+		status.LastError = l.lastErr.Error()
+	}
+	return status
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy-manager-desktop/internal/accesslog"
+	"proxy-manager-desktop/internal/config"
+)
+
+// timeoutSettings 代理当前生效的超时，由代理设置、全局默认值和内置默认值依次合并而来
+type timeoutSettings struct {
+	dial      time.Duration
+	handshake time.Duration
+	idle      time.Duration // 0表示不启用空闲超时
+	keepAlive time.Duration
+}
+
+func newTimeoutSettings(t config.Timeouts) *timeoutSettings {
+	t = t.WithDefaults(config.DefaultTimeouts)
+	settings := &timeoutSettings{
+		dial:      time.Duration(t.DialSeconds) * time.Second,
+		handshake: time.Duration(t.HandshakeSeconds) * time.Second,
+		idle:      time.Duration(t.IdleSeconds) * time.Second,
+		keepAlive: time.Duration(t.KeepAliveSeconds) * time.Second,
+	}
+	if t.IdleSeconds == config.IdleDisabled {
+		settings.idle = 0
+	}
+	return settings
+}
+
+// dialer 返回连接上游代理使用的Dialer
+func (t *timeoutSettings) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: t.dial, KeepAlive: t.keepAlive}
+}
+
+// setKeepAlive 为接受的客户端连接设置TCP保活间隔
+func (t *timeoutSettings) setKeepAlive(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(t.keepAlive)
+	}
+}
+
+// timeoutHolder 嵌入到connRegistry中，超时可以在运行时替换，对之后的连接和握手生效
+type timeoutHolder struct {
+	timeouts atomic.Pointer[timeoutSettings]
+}
+
+// SetTimeouts 替换代理的超时设置，t应已合并全局默认值
+func (h *timeoutHolder) SetTimeouts(t config.Timeouts) {
+	h.timeouts.Store(newTimeoutSettings(t))
+}
+
+func (h *timeoutHolder) currentTimeouts() *timeoutSettings {
+	if t := h.timeouts.Load(); t != nil {
+		return t
+	}
+	return newTimeoutSettings(config.Timeouts{})
+}
+
+// idleWatcher 隧道两个方向都没有数据超过idle时关闭连接
+type idleWatcher struct {
+	tc    *trackedConn
+	idle  time.Duration
+	conns []net.Conn
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+// closeWhenIdle 开始监视隧道的空闲时间，返回的函数用于在隧道结束时停止监视
+func (tc *trackedConn) closeWhenIdle(idle time.Duration, conns ...net.Conn) func() {
+	if idle <= 0 {
+		return func() {}
+	}
+	tc.touch()
+	w := &idleWatcher{tc: tc, idle: idle, conns: conns}
+	w.mu.Lock()
+	w.timer = time.AfterFunc(idle, w.check)
+	w.mu.Unlock()
+	return w.stop
+}
+
+func (w *idleWatcher) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+	// 期间有数据时按最后一次活动时间重新计时
+	if remaining := w.idle - time.Since(w.tc.lastActiveTime()); remaining > 0 {
+		w.timer.Reset(remaining)
+		return
+	}
+	fmt.Printf("连接 %s 空闲超过 %s，关闭隧道\n", w.tc.client, w.idle)
+	w.tc.finish(accesslog.OutcomeIdleTimeout, nil)
+	for _, conn := range w.conns {
+		conn.Close()
+	}
+}
+
+func (w *idleWatcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	w.timer.Stop()
+}
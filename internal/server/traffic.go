@@ -0,0 +1,50 @@
+package server
+
+import "sync/atomic"
+
+// TrafficCounters 代理的累计流量和连接计数，转发路径上只做原子加法。
+// 由ProxyManager按代理ID持有，代理重启后继续累计
+type TrafficCounters struct {
+	bytesUp       atomic.Int64
+	bytesDown     atomic.Int64
+	connsTotal    atomic.Int64
+	connsActive   atomic.Int64
+	connsFailed   atomic.Int64
+	connsRejected atomic.Int64
+
+	failures  failureCounters  // 连接上游失败，按阶段和原因分类
+	handshake latencyHistogram // 建立上游隧道的耗时
+}
+
+// TrafficStats 流量计数的快照
+type TrafficStats struct {
+	BytesUp             int64 `json:"bytes_up"`   // 客户端发往目标
+	BytesDown           int64 `json:"bytes_down"` // 目标返回客户端
+	ConnectionsTotal    int64 `json:"connections_total"`
+	ConnectionsActive   int64 `json:"connections_active"`
+	ConnectionsFailed   int64 `json:"connections_failed"`
+	ConnectionsRejected int64 `json:"connections_rejected"` // 被连接数限制或访问控制拒绝
+}
+
+func (c *TrafficCounters) Snapshot() TrafficStats {
+	return TrafficStats{
+		BytesUp:             c.bytesUp.Load(),
+		BytesDown:           c.bytesDown.Load(),
+		ConnectionsTotal:    c.connsTotal.Load(),
+		ConnectionsActive:   c.connsActive.Load(),
+		ConnectionsFailed:   c.connsFailed.Load(),
+		ConnectionsRejected: c.connsRejected.Load(),
+	}
+}
+
+// sub 计算两次快照之间的增量，活动连接数是瞬时值，直接取新值
+func (s TrafficStats) sub(prev TrafficStats) TrafficStats {
+	return TrafficStats{
+		BytesUp:             s.BytesUp - prev.BytesUp,
+		BytesDown:           s.BytesDown - prev.BytesDown,
+		ConnectionsTotal:    s.ConnectionsTotal - prev.ConnectionsTotal,
+		ConnectionsActive:   s.ConnectionsActive,
+		ConnectionsFailed:   s.ConnectionsFailed - prev.ConnectionsFailed,
+		ConnectionsRejected: s.ConnectionsRejected - prev.ConnectionsRejected,
+	}
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// trafficMinuteRetention 分钟粒度记录的保留天数，更早的记录合并为按天记录
+	trafficMinuteRetention = 30
+	daySeconds             = 24 * 60 * 60
+)
+
+// trafficRecord 流量历史文件中的一行，每行一个代理在一个时间桶内的增量
+type trafficRecord struct {
+	Time   int64  `json:"t"`              // 桶起始时间(Unix秒)
+	Span   int64  `json:"span,omitempty"` // 桶长度(秒)，为空表示一分钟
+	ID     string `json:"id"`
+	Up     int64  `json:"up"`
+	Down   int64  `json:"down"`
+	Conns  int64  `json:"conns,omitempty"`
+	Failed int64  `json:"failed,omitempty"`
+}
+
+// TrafficPoint 图表中的一个数据点
+type TrafficPoint struct {
+	Time      time.Time `json:"time"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+}
+
+// MonthlyUsage 一个自然月的用量
+type MonthlyUsage struct {
+	Month     string `json:"month"` // 如 2026-01
+	BytesUp   int64  `json:"bytes_up"`
+	BytesDown int64  `json:"bytes_down"`
+}
+
+// TrafficHistory 一个代理在指定时间范围内的流量历史
+type TrafficHistory struct {
+	ProxyID     string         `json:"proxy_id"`
+	Range       string         `json:"range"`
+	StepSeconds int64          `json:"step_seconds"`
+	Points      []TrafficPoint `json:"points"`
+	BytesUp     int64          `json:"bytes_up"`   // 范围内合计
+	BytesDown   int64          `json:"bytes_down"` // 范围内合计
+	Months      []MonthlyUsage `json:"months"`     // 最近12个月，按时间先后
+}
+
+// trafficRanges 支持的查询范围及对应的桶长度
+var trafficRanges = map[string]struct {
+	duration time.Duration
+	step     time.Duration
+}{
+	"hour":  {time.Hour, time.Minute},
+	"day":   {24 * time.Hour, 15 * time.Minute},
+	"week":  {7 * 24 * time.Hour, time.Hour},
+	"month": {30 * 24 * time.Hour, 24 * time.Hour},
+}
+
+// trafficStore 把每分钟的流量增量追加到文件，并在内存中保留一份用于查询
+type trafficStore struct {
+	mu          sync.Mutex
+	path        string
+	records     []trafficRecord
+	last        map[string]TrafficStats // 上次写入时各代理的累计值
+	lastFlush   time.Time
+	compactedOn string
+}
+
+func newTrafficStore(path string) (*trafficStore, error) {
+	s := &trafficStore{
+		path:      path,
+		last:      make(map[string]TrafficStats),
+		lastFlush: time.Now(),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(time.Now()); err != nil {
+		fmt.Printf("压缩流量历史失败: %v\n", err)
+	}
+	return s, nil
+}
+
+func (s *trafficStore) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("无法读取流量历史: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec trafficRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// 最后一行可能在写入时被中断，跳过无法解析的行
+			continue
+		}
+		s.records = append(s.records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("无法读取流量历史: %w", err)
+	}
+
+	sort.SliceStable(s.records, func(i, j int) bool { return s.records[i].Time < s.records[j].Time })
+	return nil
+}
+
+// flush 写入各代理的增量，每天第一次写入时顺带压缩旧记录
+func (s *trafficStore) flush(now time.Time, snapshots map[string]TrafficStats) error {
+	if err := s.appendDeltas(now, snapshots); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	compacted := s.compactedOn == now.Format("2006-01-02")
+	s.mu.Unlock()
+	if compacted {
+		return nil
+	}
+	return s.compact(now)
+}
+
+// appendDeltas 把自上次写入以来的增量记入上次写入所在的分钟
+func (s *trafficStore) appendDeltas(now time.Time, snapshots map[string]TrafficStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := s.lastFlush.Truncate(time.Minute).Unix()
+	var lines []byte
+	for id, snapshot := range snapshots {
+		delta := snapshot.sub(s.last[id])
+		s.last[id] = snapshot
+		if delta.BytesUp == 0 && delta.BytesDown == 0 && delta.ConnectionsTotal == 0 && delta.ConnectionsFailed == 0 {
+			continue
+		}
+		rec := trafficRecord{
+			Time:   bucket,
+			ID:     id,
+			Up:     delta.BytesUp,
+			Down:   delta.BytesDown,
+			Conns:  delta.ConnectionsTotal,
+			Failed: delta.ConnectionsFailed,
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+		s.records = append(s.records, rec)
+	}
+	s.lastFlush = now
+
+	if len(lines) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("无法写入流量历史: %w", err)
+	}
+	_, err = f.Write(lines)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("无法写入流量历史: %w", err)
+	}
+	return nil
+}
+
+// compact 把超过保留期的分钟记录合并为按天记录，并重写文件
+func (s *trafficStore) compact(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.compactedOn = now.Format("2006-01-02")
+	cutoff := localDayStart(now.AddDate(0, 0, -trafficMinuteRetention)).Unix()
+
+	type dayKey struct {
+		id  string
+		day int64
+	}
+	days := make(map[dayKey]*trafficRecord)
+	var kept []trafficRecord
+	merged := 0
+	for _, rec := range s.records {
+		if rec.Span >= daySeconds || rec.Time >= cutoff {
+			kept = append(kept, rec)
+			continue
+		}
+		key := dayKey{rec.ID, localDayStart(time.Unix(rec.Time, 0)).Unix()}
+		day, ok := days[key]
+		if !ok {
+			day = &trafficRecord{Time: key.day, Span: daySeconds, ID: rec.ID}
+			days[key] = day
+		}
+		day.Up += rec.Up
+		day.Down += rec.Down
+		day.Conns += rec.Conns
+		day.Failed += rec.Failed
+		merged++
+	}
+	if merged == 0 {
+		return nil
+	}
+	for _, day := range days {
+		kept = append(kept, *day)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Time < kept[j].Time })
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".traffic-*.tmp")
+	if err != nil {
+		return fmt.Errorf("无法创建临时文件: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range kept {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("无法写入流量历史: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("无法写入流量历史: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("无法替换流量历史文件: %w", err)
+	}
+
+	s.records = kept
+	return nil
+}
+
+// query 按范围汇总一个代理的流量，current为代理当前的累计值，未写入的部分计入最后一个桶
+func (s *trafficStore) query(id, rangeName string, now time.Time, current TrafficStats) (*TrafficHistory, error) {
+	r, ok := trafficRanges[rangeName]
+	if !ok {
+		return nil, fmt.Errorf("不支持的时间范围: %s", rangeName)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := bucketStart(now, r.step)
+	count := int(r.duration / r.step)
+	history := &TrafficHistory{
+		ProxyID:     id,
+		Range:       rangeName,
+		StepSeconds: int64(r.step / time.Second),
+		Points:      make([]TrafficPoint, count),
+	}
+	index := make(map[int64]int, count)
+	for i := 0; i < count; i++ {
+		var t time.Time
+		if r.step >= 24*time.Hour {
+			t = end.AddDate(0, 0, i-count+1)
+		} else {
+			t = end.Add(time.Duration(i-count+1) * r.step)
+		}
+		history.Points[i].Time = t
+		index[t.Unix()] = i
+	}
+
+	months := make(map[string]*MonthlyUsage)
+	monthKeys := make([]string, 0, 12)
+	for i := 11; i >= 0; i-- {
+		key := time.Date(now.Year(), now.Month()-time.Month(i), 1, 0, 0, 0, 0, now.Location()).Format("2006-01")
+		months[key] = &MonthlyUsage{Month: key}
+		monthKeys = append(monthKeys, key)
+	}
+
+	add := func(t time.Time, up, down int64) {
+		if i, ok := index[bucketStart(t, r.step).Unix()]; ok {
+			history.Points[i].BytesUp += up
+			history.Points[i].BytesDown += down
+			history.BytesUp += up
+			history.BytesDown += down
+		}
+		if m, ok := months[t.In(now.Location()).Format("2006-01")]; ok {
+			m.BytesUp += up
+			m.BytesDown += down
+		}
+	}
+	for _, rec := range s.records {
+		if rec.ID == id {
+			add(time.Unix(rec.Time, 0), rec.Up, rec.Down)
+		}
+	}
+	// 查询与写入之间可能刚好发生了一次写入，此时没有未写入的增量
+	pending := current.sub(s.last[id])
+	add(now, max(pending.BytesUp, 0), max(pending.BytesDown, 0))
+
+	for _, key := range monthKeys {
+		history.Months = append(history.Months, *months[key])
+	}
+	return history, nil
+}
+
+// bucketStart 返回时间所在桶的起点，按天的桶以本地时间零点为界
+func bucketStart(t time.Time, step time.Duration) time.Time {
+	if step >= 24*time.Hour {
+		return localDayStart(t)
+	}
+	return t.Truncate(step)
+}
+
+func localDayStart(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+// dialUpstream 按配置的传输方式建立到上游代理的连接，
+// 返回的连接上承载的是原始字节流，后续由各上游协议完成握手。
+// ctx 只约束建立连接的过程，取消后正在进行的拨号和传输层握手会立即失败。
+// h2 传输复用 pool 中的连接
+func dialUpstream(ctx context.Context, pool *h2Pool, upstream config.UpstreamProxy, timeouts *timeoutSettings) (net.Conn, error) {
+	transport := upstream.Transport
+	if transport == nil || transport.Type == "" || transport.Type == "tcp" {
+		conn, err := timeouts.dialer().DialContext(ctx, "tcp", upstream.Address)
+		if err != nil {
+			return nil, fmt.Errorf("无法连接到上游代理 %s: %w", upstream.Address, err)
+		}
+		return conn, nil
+	}
+
+	switch transport.Type {
+	case "ws":
+		return dialWebSocket(ctx, upstream.Address, transport, timeouts)
+	case "h2":
+		return dialH2Connect(ctx, pool, upstream.Address, transport, timeouts)
+	default:
+		return nil, fmt.Errorf("不支持的上游传输方式: %s", transport.Type)
+	}
+}
+
+// abortOnCancel ctx取消时把conn的读写超时设为过去的时间，中断正在进行的握手。
+// 握手结束后调用返回的函数，返回false表示ctx已经取消
+func abortOnCancel(ctx context.Context, conn net.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+}
+
+// transportHost 返回HTTP请求中使用的Host，默认取上游地址
+func transportHost(address string, transport *config.UpstreamTransport) string {
+	if transport.Host != "" {
+		return transport.Host
+	}
+	return address
+}
+
+func transportPath(transport *config.UpstreamTransport) string {
+	if transport.Path == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(transport.Path, "/") {
+		return "/" + transport.Path
+	}
+	return transport.Path
+}
+
+func transportTLSConfig(address string, transport *config.UpstreamTransport, nextProtos ...string) *tls.Config {
+	serverName := transport.ServerName
+	if serverName == "" {
+		serverName = transportHost(address, transport)
+	}
+	if host, _, err := net.SplitHostPort(serverName); err == nil {
+		serverName = host
+	}
+
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: transport.SkipVerify,
+		NextProtos:         nextProtos,
+	}
+}
+
+func setTransportHeaders(h http.Header, transport *config.UpstreamTransport) {
+	for key, value := range transport.Headers {
+		h.Set(key, value)
+	}
+}
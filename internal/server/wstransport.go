@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// wsMaxFrameSize 单个数据帧载荷的上限，超过时断开连接
+	wsMaxFrameSize = 16 << 20
+	// wsCloseTimeout 发送关闭帧的最长等待时间
+	wsCloseTimeout = time.Second
+)
+
+// wsConn 把字节流承载在WebSocket二进制帧中的客户端连接
+type wsConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	wmu sync.Mutex
+
+	remaining int64 // 当前数据帧尚未读取的载荷长度
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	closed    bool
+}
+
+// dialWebSocket 完成WebSocket升级握手(RFC 6455)，返回承载字节流的连接
+func dialWebSocket(ctx context.Context, address string, transport *config.UpstreamTransport, timeouts *timeoutSettings) (net.Conn, error) {
+	conn, err := timeouts.dialer().DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("无法连接到上游代理 %s: %w", address, err)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeouts.handshake))
+	defer conn.SetDeadline(time.Time{})
+	stop := abortOnCancel(ctx, conn)
+
+	if transport.TLS {
+		tlsConn := tls.Client(conn, transportTLSConfig(address, transport, "http/1.1"))
+		if err := tlsConn.Handshake(); err != nil {
+			stop()
+			conn.Close()
+			return nil, fmt.Errorf("WebSocket TLS握手失败: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	ws, err := wsHandshake(conn, address, transport)
+	if !stop() && err == nil {
+		err = fmt.Errorf("WebSocket握手被取消: %w", ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+func wsHandshake(conn net.Conn, address string, transport *config.UpstreamTransport) (*wsConn, error) {
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	scheme := "http"
+	if transport.TLS {
+		scheme = "https"
+	}
+	host := transportHost(address, transport)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Scheme: scheme, Host: host, Opaque: transportPath(transport)},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       host,
+	}
+	setTransportHeaders(req.Header, transport)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("发送WebSocket握手请求失败: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("读取WebSocket握手响应失败: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("WebSocket握手失败: %s", resp.Status)
+	}
+
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	expected := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if resp.Header.Get("Sec-WebSocket-Accept") != expected {
+		return nil, fmt.Errorf("WebSocket握手校验失败")
+	}
+
+	return &wsConn{Conn: conn, br: br}, nil
+}
+
+// writeFrame 发送一个客户端帧，客户端帧必须使用掩码
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	header = append(header, mask[:]...)
+
+	frame := make([]byte, len(header)+len(payload))
+	copy(frame, header)
+	body := frame[len(header):]
+	for i, b := range payload {
+		body[i] = b ^ mask[i%4]
+	}
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.nextDataFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.br.Read(b)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			b[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextDataFrame 读取下一个帧头，处理控制帧，直到遇到数据帧
+func (c *wsConn) nextDataFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0F
+	c.masked = head[1]&0x80 != 0
+
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n&(1<<63) != 0 {
+			return fmt.Errorf("无效的WebSocket帧长度: 最高位不为0")
+		}
+		length = int64(n)
+	}
+	if length > wsMaxFrameSize {
+		return fmt.Errorf("WebSocket帧过大: %d 字节", length)
+	}
+
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+	c.maskPos = 0
+
+	switch opcode {
+	case wsOpBinary, wsOpText, wsOpContinuation:
+		c.remaining = length
+		return nil
+	case wsOpPing, wsOpPong, wsOpClose:
+		if length > 125 {
+			return fmt.Errorf("无效的WebSocket控制帧长度: %d", length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		if c.masked {
+			for i := range payload {
+				payload[i] ^= c.mask[i%4]
+			}
+		}
+		switch opcode {
+		case wsOpPing:
+			return c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.closed = true
+			c.writeFrame(wsOpClose, payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("未知的WebSocket帧类型: %d", opcode)
+	}
+}
+
+// Close 尽量发送关闭帧后关闭连接。其他goroutine正阻塞在写入时不等待，
+// 直接关闭底层连接让写入返回
+func (c *wsConn) Close() error {
+	if c.wmu.TryLock() {
+		c.Conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+		c.writeFrameLocked(wsOpClose, []byte{0x03, 0xE8}) // 1000 正常关闭
+		c.wmu.Unlock()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWebSocketFrameLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		length uint64
+	}{
+		{name: "最高位为1", length: 1 << 63},
+		{name: "超过上限", length: wsMaxFrameSize + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+
+			go func() {
+				frame := binary.BigEndian.AppendUint64([]byte{0x80 | wsOpBinary, 127}, tt.length)
+				server.Write(frame)
+			}()
+
+			c := &wsConn{Conn: client, br: bufio.NewReader(client)}
+			if _, err := c.Read(make([]byte, 16)); err == nil {
+				t.Fatal("应拒绝该帧长度")
+			}
+		})
+	}
+}
+
+func TestWebSocketCloseWhileWriting(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := &wsConn{Conn: client, br: bufio.NewReader(client)}
+
+	// 对端不读取，写入阻塞并持有写锁
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("blocked"))
+		writeErr <- err
+	}()
+	for c.wmu.TryLock() {
+		c.wmu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("写入阻塞时 Close 没有返回")
+	}
+	if err := <-writeErr; err == nil {
+		t.Fatal("关闭后阻塞的写入应返回错误")
+	}
+}
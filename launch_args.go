@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// 命令行参数：
+//
+//	--start <代理>   启动代理，可以用ID或名称指定
+//	--stop <代理>    停止代理
+//	--import <文件>  从CSV文件导入代理
+//
+// 已有实例运行时，参数转发给它执行。
+var launchOptions = map[string]bool{"start": true, "stop": true, "import": true}
+
+// parseLaunchArgs 检查命令行参数并转换为 --选项 值 的形式，
+// 文件路径转换为绝对路径，转发给工作目录不同的实例后仍然有效
+func parseLaunchArgs(args []string) ([]string, error) {
+	var result []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-psn_") {
+			continue // 旧版macOS从访达启动时附加的进程序列号
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || !launchOptions[name] {
+			return nil, fmt.Errorf("未知的参数: %s", arg)
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("参数 %s 缺少值", arg)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "import" {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return nil, fmt.Errorf("无效的文件路径 %s: %w", value, err)
+			}
+			value = abs
+		}
+		result = append(result, "--"+name, value)
+	}
+	return result, nil
+}
+
+// runLaunchArgs 依次执行 parseLaunchArgs 返回的命令，有失败时继续执行其余命令
+func (a *App) runLaunchArgs(args []string) error {
+	var errs []string
+	for i := 0; i+1 < len(args); i += 2 {
+		var err error
+		switch value := args[i+1]; args[i] {
+		case "--start":
+			err = a.runOnProxy(value, a.StartProxy)
+		case "--stop":
+			err = a.runOnProxy(value, a.StopProxy)
+		case "--import":
+			err = a.importFile(value)
+		default:
+			err = fmt.Errorf("未知的参数: %s", args[i])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", args[i], args[i+1], err))
+		}
+	}
+	runtime.EventsEmit(a.ctx, "proxies:changed")
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// handleForwardedArgs 处理再次启动应用时转发来的参数，并把窗口显示到前台
+func (a *App) handleForwardedArgs(args []string) error {
+	log.Printf("收到其他实例转发的参数: %v", args)
+	runtime.WindowUnminimise(a.ctx)
+	runtime.WindowShow(a.ctx)
+	if len(args) == 0 {
+		return nil
+	}
+	return a.runLaunchArgs(args)
+}
+
+// runOnProxy 按ID或名称查找代理后执行操作
+func (a *App) runOnProxy(ref string, op func(id string) error) error {
+	var matches []string
+	for _, proxy := range a.configManager.GetAllProxies() {
+		if proxy.ID == ref {
+			return op(proxy.ID)
+		}
+		if proxy.Name == ref {
+			matches = append(matches, proxy.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("代理 %s 不存在", ref)
+	case 1:
+		return op(matches[0])
+	default:
+		return fmt.Errorf("有 %d 个代理名为 %s，请使用ID", len(matches), ref)
+	}
+}
+
+func (a *App) importFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	return a.ImportConfig(string(data))
+}
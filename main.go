@@ -2,6 +2,14 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"proxy-manager-desktop/internal/config"
+	"proxy-manager-desktop/internal/instance"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -12,9 +20,31 @@ import (
 var assets embed.FS
 
 func main() {
-	app := NewApp()
+	launchArgs, err := parseLaunchArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// 同一配置目录只运行一个实例，再次启动时把参数转发给已运行的实例后退出
+	configPath := findConfigPath()
+	inst, err := instance.Lock(filepath.Dir(configPath))
+	if errors.Is(err, instance.ErrRunning) {
+		if err := instance.Forward(filepath.Dir(configPath), launchArgs, 30*time.Second); err != nil {
+			fmt.Fprintf(os.Stderr, "转发到已运行的实例失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		println("Error:", err.Error())
+		os.Exit(1)
+	}
+	defer inst.Close()
+
+	app := NewApp(configPath, inst, launchArgs)
 
-	err := wails.Run(&options.App{
+	err = wails.Run(&options.App{
 		Title:  "代理管理器 - NoBiggie社区特供版",
 		Width:  1024,
 		Height: 768,
@@ -28,9 +58,20 @@ func main() {
 		Bind: []interface{}{
 			app,
 		},
+		ErrorFormatter: formatError,
 	})
 
 	if err != nil {
 		println("Error:", err.Error())
 	}
 }
+
+// formatError 决定绑定方法返回的错误在前端的形式。配置校验错误返回
+// {message, fields}，前端据此标出出错的字段，其他错误仍是字符串
+func formatError(err error) any {
+	var ve *config.ValidationError
+	if errors.As(err, &ve) {
+		return map[string]any{"message": err.Error(), "fields": ve.Fields}
+	}
+	return err.Error()
+}